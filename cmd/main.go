@@ -1,7 +1,14 @@
 package main
 
 import (
-	"github.com/dgrijalva/jwt-go"
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // digest timezone is loaded even where OS has no timezone database
+
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/tinoquang/comic-notifier/pkg/api"
@@ -10,6 +17,8 @@ import (
 	"github.com/tinoquang/comic-notifier/pkg/crawler"
 	"github.com/tinoquang/comic-notifier/pkg/db/cloud"
 	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/metrics"
 	"github.com/tinoquang/comic-notifier/pkg/msg"
 	"github.com/tinoquang/comic-notifier/pkg/server"
 )
@@ -18,10 +27,15 @@ func main() {
 
 	e := echo.New()
 	e.Pre(middleware.RemoveTrailingSlash())
+	e.Use(metrics.Middleware)
 
 	// Init global config
 	conf.Init()
 
+	if err := logging.Init(conf.Cfg.Log.Level, conf.Cfg.Log.Format); err != nil {
+		panic(err)
+	}
+
 	dbconn := db.NewDBConn()
 	firebase := cloud.NewFirebaseConnection()
 
@@ -30,21 +44,31 @@ func main() {
 	// Init Repository
 	store := db.NewStore(dbconn, firebase)
 
+	// SIGTERM stops update and notify services, server is then shut down below
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Init main business logic server
-	svr := server.New(store, crawler)
+	svr := server.New(ctx, store, crawler)
 
 	// // Facebook webhook
-	msg.RegisterHandler(e.Group("/webhook"), svr.Msg)
+	webhook := msg.RegisterHandler(e.Group("/webhook"), svr.Msg)
 
 	// API handler register
 	apiGroup := e.Group("/api/v1")
 	apiGroup.Use(middleware.JWTWithConfig(middleware.JWTConfig{
 		SigningKey:  []byte(conf.Cfg.JWT.SecretKey),
-		Claims:      &jwt.StandardClaims{},
+		Claims:      &auth.Claims{},
 		TokenLookup: "cookie:_session",
 	}))
 	api.RegisterHandlers(apiGroup, svr.API)
 
+	// Prometheus metrics, they expose traffic and queue sizes so they're only served to scraper with credentials
+	if conf.Cfg.Metrics.Password != "" {
+		e.GET("/metrics", echo.WrapHandler(metrics.Handler()),
+			middleware.BasicAuth(metrics.Authenticator(conf.Cfg.Metrics.User, conf.Cfg.Metrics.Password)))
+	}
+
 	/* Routing */
 	e.Static("/static", "ui/static")
 	e.Static("/assets", "ui/assets")
@@ -58,6 +82,48 @@ func main() {
 	auth.RegisterHandler(e.Group(""), store, crawler)
 
 	// Start the server
-	e.Logger.Fatal(e.Start(":" + conf.Cfg.Port))
+	go func() {
+		if err := e.Start(":" + conf.Cfg.Port); err != nil && err != http.ErrServerClosed {
+			logging.Danger(err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	logging.Info("Shutting down ...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(conf.Cfg.ShutdownTimeout)*time.Second)
+	defer cancel()
+
+	// Stop accepting requests first, then finish webhook messages already queued, the running update sweep and notify batch
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		logging.Danger("Can't stop HTTP server, err", err)
+	}
+
+	finished := true
+	if err := webhook.Shutdown(shutdownCtx); err != nil {
+		logging.Danger("Queued webhook messages are not finished, err", err)
+		finished = false
+	}
+
+	if err := svr.Shutdown(shutdownCtx); err != nil {
+		logging.Danger("Update and notify services are not finished, err", err)
+		finished = false
+	}
+
+	// Jobs still running would use DB and firebase after they are closed, so leave them open and let process exit
+	if !finished {
+		logging.Danger("Shutdown timed out, DB and firebase are left open, process exits")
+		return
+	}
+
+	if err := dbconn.Close(); err != nil {
+		logging.Danger(err)
+	}
+
+	if err := firebase.Close(); err != nil {
+		logging.Danger(err)
+	}
 
+	logging.Info("Server is stopped")
 }
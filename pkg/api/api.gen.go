@@ -1,4 +1,4 @@
-// Package api provides primitives to interact the openapi HTTP API.
+// Package api provides primitives to interact with the openapi HTTP API.
 //
 // Code generated by github.com/deepmap/oapi-codegen DO NOT EDIT.
 package api
@@ -9,19 +9,111 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"net/url"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/deepmap/oapi-codegen/pkg/runtime"
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/labstack/echo/v4"
 )
 
+// Defines values for DigestSettingsMode.
+const (
+	DigestSettingsModeDaily DigestSettingsMode = "daily"
+
+	DigestSettingsModeInstant DigestSettingsMode = "instant"
+
+	DigestSettingsModeWeekly DigestSettingsMode = "weekly"
+)
+
+// Defines values for ErrorCode.
+const (
+	ErrorCodeAlreadySubscribed ErrorCode = "already_subscribed"
+
+	ErrorCodeCrawlTimeout ErrorCode = "crawl_timeout"
+
+	ErrorCodeInvalidUrl ErrorCode = "invalid_url"
+
+	ErrorCodeUnsupportedPage ErrorCode = "unsupported_page"
+)
+
+// Defines values for NotificationStatus.
+const (
+	NotificationStatusFailed NotificationStatus = "failed"
+
+	NotificationStatusPending NotificationStatus = "pending"
+
+	NotificationStatusSent NotificationStatus = "sent"
+)
+
+// Defines values for NotifySettingsChannel.
+const (
+	NotifySettingsChannelDiscord NotifySettingsChannel = "discord"
+
+	NotifySettingsChannelEmail NotifySettingsChannel = "email"
+
+	NotifySettingsChannelMessenger NotifySettingsChannel = "messenger"
+
+	NotifySettingsChannelTelegram NotifySettingsChannel = "telegram"
+
+	NotifySettingsChannelWebhook NotifySettingsChannel = "webhook"
+)
+
+// Defines values for SiteHealthLastError.
+const (
+	SiteHealthLastErrorFetch SiteHealthLastError = "fetch"
+
+	SiteHealthLastErrorLayout SiteHealthLastError = "layout"
+
+	SiteHealthLastErrorTimeout SiteHealthLastError = "timeout"
+
+	SiteHealthLastErrorUnknown SiteHealthLastError = "unknown"
+)
+
+// Defines values for Order.
+const (
+	Asc Order = "asc"
+
+	Desc Order = "desc"
+)
+
+// Defines values for Sort.
+const (
+	LastUpdate Sort = "last_update"
+
+	Name Sort = "name"
+
+	Subscribed Sort = "subscribed"
+)
+
+// Broadcast defines model for Broadcast.
+type Broadcast struct {
+
+	// Text sent to users
+	Message string `json:"message"`
+}
+
+// BroadcastResult defines model for BroadcastResult.
+type BroadcastResult struct {
+
+	// Number of users message is sent to
+	Recipients int `json:"recipients"`
+}
+
 // Comic defines model for Comic.
 type Comic struct {
 
 	// Chapter url
 	ChapURL *string `json:"chapURL,omitempty"`
 
+	// Number of chapters user has not read
+	ChaptersBehind *int `json:"chaptersBehind,omitempty"`
+
+	// Disabled comic is not crawled
+	Disabled *bool `json:"disabled,omitempty"`
+
 	// Comic ID
 	Id *int `json:"id,omitempty"`
 
@@ -41,9 +133,201 @@ type Comic struct {
 	Url *string `json:"url,omitempty"`
 }
 
-// ComicPage defines model for ComicPage.
+// List comic response
 type ComicPage struct {
 	Comics []Comic `json:"comics"`
+
+	// Maximum number of comics in this page
+	Limit int `json:"limit"`
+
+	// Offset of this page
+	Offset int `json:"offset"`
+
+	// Number of comics matching query in all pages
+	Total int `json:"total"`
+}
+
+// DigestSettings defines model for DigestSettings.
+type DigestSettings struct {
+
+	// Hour of day digest is delivered, in bot's timezone. Default is 20
+	Hour *int `json:"hour,omitempty"`
+
+	// Send new chapters at once, or collect them into a daily or weekly digest
+	Mode DigestSettingsMode `json:"mode"`
+
+	// Day of week weekly digest is delivered, 0 is Sunday. Default is 0
+	Weekday *int `json:"weekday,omitempty"`
+}
+
+// Send new chapters at once, or collect them into a daily or weekly digest
+type DigestSettingsMode string
+
+// DisabledStatus defines model for DisabledStatus.
+type DisabledStatus struct {
+
+	// Stop crawling if true, resume if false
+	Disabled bool `json:"disabled"`
+}
+
+// Error defines model for Error.
+type Error struct {
+
+	// Error code, stable for clients to handle
+	Code ErrorCode `json:"code"`
+
+	// Human readable error
+	Message string `json:"message"`
+}
+
+// Error code, stable for clients to handle
+type ErrorCode string
+
+// MergeComic defines model for MergeComic.
+type MergeComic struct {
+
+	// ID of duplicate comic, it's deleted after merge
+	DuplicateID int `json:"duplicateID"`
+}
+
+// MessengerPage defines model for MessengerPage.
+type MessengerPage struct {
+
+	// When page is added
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+
+	// Messages sent to disabled page are ignored
+	Disabled bool `json:"disabled"`
+
+	// Welcome message sent when user starts talking to page, default one is used if empty
+	Greeting *string `json:"greeting,omitempty"`
+
+	// Facebook page ID
+	Id string `json:"id"`
+
+	// Page name
+	Name *string `json:"name,omitempty"`
+}
+
+// MessengerPageSettings defines model for MessengerPageSettings.
+type MessengerPageSettings struct {
+
+	// Page access token, used to answer messages sent to page
+	AccessToken string `json:"accessToken"`
+
+	// Ignore messages sent to page if true
+	Disabled *bool `json:"disabled,omitempty"`
+
+	// Welcome message sent when user starts talking to page
+	Greeting *string `json:"greeting,omitempty"`
+
+	// Page name
+	Name *string `json:"name,omitempty"`
+}
+
+// Notification defines model for Notification.
+type Notification struct {
+
+	// Number of delivery attempts
+	Attempts int `json:"attempts"`
+
+	// New chapter
+	ChapterID int `json:"chapterID"`
+
+	// Comic of new chapter
+	ComicID int `json:"comicID"`
+
+	// When notification is queued
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+
+	// Notification ID
+	Id int `json:"id"`
+
+	// Error of the last failed attempt
+	LastError *string `json:"lastError,omitempty"`
+
+	// When pending notification is sent
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+
+	// Delivery status
+	Status NotificationStatus `json:"status"`
+
+	// Notified user
+	UserID int `json:"userID"`
+}
+
+// Delivery status
+type NotificationStatus string
+
+// NotifySettings defines model for NotifySettings.
+type NotifySettings struct {
+
+	// Channel used to send notification
+	Channel NotifySettingsChannel `json:"channel"`
+
+	// Telegram chat ID, Discord or HTTP webhook URL, or email address. Not used by messenger
+	Target *string `json:"target,omitempty"`
+}
+
+// Channel used to send notification
+type NotifySettingsChannel string
+
+// ReadProgress defines model for ReadProgress.
+type ReadProgress struct {
+
+	// URL of last read chapter
+	ChapURL *string `json:"chapURL,omitempty"`
+}
+
+// RetryResult defines model for RetryResult.
+type RetryResult struct {
+
+	// Number of notifications queued again
+	Retried int `json:"retried"`
+}
+
+// SiteHealth defines model for SiteHealth.
+type SiteHealth struct {
+
+	// Site is flagged broken when most of its comics fail to crawl
+	Broken bool `json:"broken"`
+
+	// When site was flagged broken
+	BrokenSince *time.Time `json:"brokenSince,omitempty"`
+
+	// Failed crawls since the last successful one
+	ConsecutiveFailures *int `json:"consecutiveFailures,omitempty"`
+
+	// Number of crawls of site's comics
+	Crawls *int `json:"crawls,omitempty"`
+
+	// Comics of disabled site are not crawled
+	Disabled *bool `json:"disabled,omitempty"`
+
+	// Number of failed crawls
+	Failures *int `json:"failures,omitempty"`
+
+	// Kind of the last crawl error, layout errors usually mean site was redesigned
+	LastError *SiteHealthLastError `json:"lastError,omitempty"`
+
+	// Time of the last crawl error
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+
+	// Time of the last successful crawl
+	LastSuccessAt *time.Time `json:"lastSuccessAt,omitempty"`
+
+	// Site host
+	Page string `json:"page"`
+}
+
+// Kind of the last crawl error, layout errors usually mean site was redesigned
+type SiteHealthLastError string
+
+// Subscription defines model for Subscription.
+type Subscription struct {
+
+	// Comic link
+	Url string `json:"url"`
 }
 
 // User defines model for User.
@@ -55,9 +339,24 @@ type User struct {
 	// Number of comics subscribed
 	Comics *int `json:"comics,omitempty"`
 
+	// Hour of day digest is delivered
+	DigestHour *int `json:"digestHour,omitempty"`
+
+	// instant, daily or weekly
+	DigestMode *string `json:"digestMode,omitempty"`
+
+	// Day of week weekly digest is delivered, 0 is Sunday
+	DigestWeekday *int `json:"digestWeekday,omitempty"`
+
 	// Comic name
 	Name *string `json:"name,omitempty"`
 
+	// Channel used to send notification
+	NotifyChannel *string `json:"notifyChannel,omitempty"`
+
+	// Where notification is sent to, depends on channel. It's only returned to its owner
+	NotifyTarget *string `json:"notifyTarget,omitempty"`
+
 	// User avatar link
 	ProfilePic *string `json:"profile_pic,omitempty"`
 
@@ -71,12 +370,61 @@ type Limit int64
 // Offset defines model for offset.
 type Offset int64
 
+// Order defines model for order.
+type Order string
+
 // Q defines model for q.
 type Q string
 
+// Site defines model for site.
+type Site string
+
+// Sort defines model for sort.
+type Sort string
+
+// AdminBroadcastJSONBody defines parameters for AdminBroadcast.
+type AdminBroadcastJSONBody Broadcast
+
+// AdminMergeComicJSONBody defines parameters for AdminMergeComic.
+type AdminMergeComicJSONBody MergeComic
+
+// AdminUpdateComicStatusJSONBody defines parameters for AdminUpdateComicStatus.
+type AdminUpdateComicStatusJSONBody DisabledStatus
+
+// AdminUpdateMessengerPageJSONBody defines parameters for AdminUpdateMessengerPage.
+type AdminUpdateMessengerPageJSONBody MessengerPageSettings
+
+// AdminNotificationsParams defines parameters for AdminNotifications.
+type AdminNotificationsParams struct {
+
+	// Notification status
+	Status *AdminNotificationsParamsStatus `json:"status,omitempty"`
+
+	// Used to request the next page in a list operation.
+	Offset *Offset `json:"offset,omitempty"`
+
+	// Used to specify the maximum number of records which are returned in the next page.
+	Limit *Limit `json:"limit,omitempty"`
+}
+
+// AdminNotificationsParamsStatus defines parameters for AdminNotifications.
+type AdminNotificationsParamsStatus string
+
+// AdminUpdateSiteStatusJSONBody defines parameters for AdminUpdateSiteStatus.
+type AdminUpdateSiteStatusJSONBody DisabledStatus
+
 // ComicsParams defines parameters for Comics.
 type ComicsParams struct {
 
+	// Only return comics of this site, e.g. beeng.net
+	Site *Site `json:"site,omitempty"`
+
+	// Field to sort comics by, subscribed date only applies to user's comics
+	Sort *ComicsParamsSort `json:"sort,omitempty"`
+
+	// Sort direction, default is ascending for name and descending for dates
+	Order *ComicsParamsOrder `json:"order,omitempty"`
+
 	// Used to request the next page in a list operation.
 	Offset *Offset `json:"offset,omitempty"`
 
@@ -84,12 +432,30 @@ type ComicsParams struct {
 	Limit *Limit `json:"limit,omitempty"`
 }
 
+// ComicsParamsSort defines parameters for Comics.
+type ComicsParamsSort string
+
+// ComicsParamsOrder defines parameters for Comics.
+type ComicsParamsOrder string
+
+// UpdateNotifySettingsJSONBody defines parameters for UpdateNotifySettings.
+type UpdateNotifySettingsJSONBody NotifySettings
+
 // GetUserComicsParams defines parameters for GetUserComics.
 type GetUserComicsParams struct {
 
 	// Used to query by name in a list operation.
 	Q *Q `json:"q,omitempty"`
 
+	// Only return comics of this site, e.g. beeng.net
+	Site *Site `json:"site,omitempty"`
+
+	// Field to sort comics by, subscribed date only applies to user's comics
+	Sort *GetUserComicsParamsSort `json:"sort,omitempty"`
+
+	// Sort direction, default is ascending for name and descending for dates
+	Order *GetUserComicsParamsOrder `json:"order,omitempty"`
+
 	// Used to request the next page in a list operation.
 	Offset *Offset `json:"offset,omitempty"`
 
@@ -97,26 +463,116 @@ type GetUserComicsParams struct {
 	Limit *Limit `json:"limit,omitempty"`
 }
 
+// GetUserComicsParamsSort defines parameters for GetUserComics.
+type GetUserComicsParamsSort string
+
+// GetUserComicsParamsOrder defines parameters for GetUserComics.
+type GetUserComicsParamsOrder string
+
+// SubscribeComicJSONBody defines parameters for SubscribeComic.
+type SubscribeComicJSONBody Subscription
+
+// UpdateDigestSettingsJSONBody defines parameters for UpdateDigestSettings.
+type UpdateDigestSettingsJSONBody DigestSettings
+
+// UpdateReadProgressJSONBody defines parameters for UpdateReadProgress.
+type UpdateReadProgressJSONBody ReadProgress
+
+// AdminBroadcastJSONRequestBody defines body for AdminBroadcast for application/json ContentType.
+type AdminBroadcastJSONRequestBody AdminBroadcastJSONBody
+
+// AdminMergeComicJSONRequestBody defines body for AdminMergeComic for application/json ContentType.
+type AdminMergeComicJSONRequestBody AdminMergeComicJSONBody
+
+// AdminUpdateComicStatusJSONRequestBody defines body for AdminUpdateComicStatus for application/json ContentType.
+type AdminUpdateComicStatusJSONRequestBody AdminUpdateComicStatusJSONBody
+
+// AdminUpdateMessengerPageJSONRequestBody defines body for AdminUpdateMessengerPage for application/json ContentType.
+type AdminUpdateMessengerPageJSONRequestBody AdminUpdateMessengerPageJSONBody
+
+// AdminUpdateSiteStatusJSONRequestBody defines body for AdminUpdateSiteStatus for application/json ContentType.
+type AdminUpdateSiteStatusJSONRequestBody AdminUpdateSiteStatusJSONBody
+
+// UpdateNotifySettingsJSONRequestBody defines body for UpdateNotifySettings for application/json ContentType.
+type UpdateNotifySettingsJSONRequestBody UpdateNotifySettingsJSONBody
+
+// SubscribeComicJSONRequestBody defines body for SubscribeComic for application/json ContentType.
+type SubscribeComicJSONRequestBody SubscribeComicJSONBody
+
+// UpdateDigestSettingsJSONRequestBody defines body for UpdateDigestSettings for application/json ContentType.
+type UpdateDigestSettingsJSONRequestBody UpdateDigestSettingsJSONBody
+
+// UpdateReadProgressJSONRequestBody defines body for UpdateReadProgress for application/json ContentType.
+type UpdateReadProgressJSONRequestBody UpdateReadProgressJSONBody
+
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
 
+	// (POST /admin/broadcast)
+	AdminBroadcast(ctx echo.Context) error
+
+	// (POST /admin/comics/{id}/crawl)
+	AdminCrawlComic(ctx echo.Context, id int) error
+
+	// (POST /admin/comics/{id}/merge)
+	AdminMergeComic(ctx echo.Context, id int) error
+
+	// (PUT /admin/comics/{id}/status)
+	AdminUpdateComicStatus(ctx echo.Context, id int) error
+
+	// (GET /admin/messenger-pages)
+	AdminMessengerPages(ctx echo.Context) error
+
+	// (PUT /admin/messenger-pages/{id})
+	AdminUpdateMessengerPage(ctx echo.Context, id string) error
+
+	// (GET /admin/notifications)
+	AdminNotifications(ctx echo.Context, params AdminNotificationsParams) error
+
+	// (POST /admin/notifications/retry)
+	AdminRetryFailedNotifications(ctx echo.Context) error
+
+	// (POST /admin/notifications/{id}/retry)
+	AdminRetryNotification(ctx echo.Context, id int) error
+
+	// (PUT /admin/sites/{page}/status)
+	AdminUpdateSiteStatus(ctx echo.Context, page string) error
+
+	// (POST /admin/sweep)
+	AdminSweep(ctx echo.Context) error
+
 	// (GET /comics)
 	Comics(ctx echo.Context, params ComicsParams) error
 
 	// (GET /comics/{id})
 	GetComic(ctx echo.Context, id int) error
 
+	// (GET /sites)
+	Sites(ctx echo.Context) error
+
 	// (GET /users)
 	Users(ctx echo.Context) error
 
 	// (GET /users/{id})
 	GetUser(ctx echo.Context, id string) error
 
+	// (PUT /users/{id})
+	UpdateNotifySettings(ctx echo.Context, id string) error
+
 	// (GET /users/{id}/comics)
 	GetUserComics(ctx echo.Context, id string, params GetUserComicsParams) error
 
+	// (POST /users/{id}/comics)
+	SubscribeComic(ctx echo.Context, id string) error
+
+	// (PUT /users/{id}/digest)
+	UpdateDigestSettings(ctx echo.Context, id string) error
+
 	// (DELETE /users/{user_id}/comics/{id})
 	UnsubscribeComic(ctx echo.Context, userId string, id int) error
+
+	// (PUT /users/{user_id}/comics/{id})
+	UpdateReadProgress(ctx echo.Context, userId string, id int) error
 }
 
 // ServerInterfaceWrapper converts echo contexts to parameters.
@@ -124,12 +580,197 @@ type ServerInterfaceWrapper struct {
 	Handler ServerInterface
 }
 
+// AdminBroadcast converts echo context to params.
+func (w *ServerInterfaceWrapper) AdminBroadcast(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.AdminBroadcast(ctx)
+	return err
+}
+
+// AdminCrawlComic converts echo context to params.
+func (w *ServerInterfaceWrapper) AdminCrawlComic(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "id" -------------
+	var id int
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.AdminCrawlComic(ctx, id)
+	return err
+}
+
+// AdminMergeComic converts echo context to params.
+func (w *ServerInterfaceWrapper) AdminMergeComic(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "id" -------------
+	var id int
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.AdminMergeComic(ctx, id)
+	return err
+}
+
+// AdminUpdateComicStatus converts echo context to params.
+func (w *ServerInterfaceWrapper) AdminUpdateComicStatus(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "id" -------------
+	var id int
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.AdminUpdateComicStatus(ctx, id)
+	return err
+}
+
+// AdminMessengerPages converts echo context to params.
+func (w *ServerInterfaceWrapper) AdminMessengerPages(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.AdminMessengerPages(ctx)
+	return err
+}
+
+// AdminUpdateMessengerPage converts echo context to params.
+func (w *ServerInterfaceWrapper) AdminUpdateMessengerPage(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "id" -------------
+	var id string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.AdminUpdateMessengerPage(ctx, id)
+	return err
+}
+
+// AdminNotifications converts echo context to params.
+func (w *ServerInterfaceWrapper) AdminNotifications(ctx echo.Context) error {
+	var err error
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params AdminNotificationsParams
+	// ------------- Optional query parameter "status" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "status", ctx.QueryParams(), &params.Status)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter status: %s", err))
+	}
+
+	// ------------- Optional query parameter "offset" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "offset", ctx.QueryParams(), &params.Offset)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter offset: %s", err))
+	}
+
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", ctx.QueryParams(), &params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter limit: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.AdminNotifications(ctx, params)
+	return err
+}
+
+// AdminRetryFailedNotifications converts echo context to params.
+func (w *ServerInterfaceWrapper) AdminRetryFailedNotifications(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.AdminRetryFailedNotifications(ctx)
+	return err
+}
+
+// AdminRetryNotification converts echo context to params.
+func (w *ServerInterfaceWrapper) AdminRetryNotification(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "id" -------------
+	var id int
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.AdminRetryNotification(ctx, id)
+	return err
+}
+
+// AdminUpdateSiteStatus converts echo context to params.
+func (w *ServerInterfaceWrapper) AdminUpdateSiteStatus(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "page" -------------
+	var page string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "page", runtime.ParamLocationPath, ctx.Param("page"), &page)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter page: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.AdminUpdateSiteStatus(ctx, page)
+	return err
+}
+
+// AdminSweep converts echo context to params.
+func (w *ServerInterfaceWrapper) AdminSweep(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.AdminSweep(ctx)
+	return err
+}
+
 // Comics converts echo context to params.
 func (w *ServerInterfaceWrapper) Comics(ctx echo.Context) error {
 	var err error
 
 	// Parameter object where we will unmarshal all parameters from the context
 	var params ComicsParams
+	// ------------- Optional query parameter "site" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "site", ctx.QueryParams(), &params.Site)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter site: %s", err))
+	}
+
+	// ------------- Optional query parameter "sort" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "sort", ctx.QueryParams(), &params.Sort)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter sort: %s", err))
+	}
+
+	// ------------- Optional query parameter "order" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "order", ctx.QueryParams(), &params.Order)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter order: %s", err))
+	}
+
 	// ------------- Optional query parameter "offset" -------------
 
 	err = runtime.BindQueryParameter("form", true, false, "offset", ctx.QueryParams(), &params.Offset)
@@ -155,7 +796,7 @@ func (w *ServerInterfaceWrapper) GetComic(ctx echo.Context) error {
 	// ------------- Path parameter "id" -------------
 	var id int
 
-	err = runtime.BindStyledParameter("simple", false, "id", ctx.Param("id"), &id)
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
 	}
@@ -165,6 +806,15 @@ func (w *ServerInterfaceWrapper) GetComic(ctx echo.Context) error {
 	return err
 }
 
+// Sites converts echo context to params.
+func (w *ServerInterfaceWrapper) Sites(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.Sites(ctx)
+	return err
+}
+
 // Users converts echo context to params.
 func (w *ServerInterfaceWrapper) Users(ctx echo.Context) error {
 	var err error
@@ -180,7 +830,7 @@ func (w *ServerInterfaceWrapper) GetUser(ctx echo.Context) error {
 	// ------------- Path parameter "id" -------------
 	var id string
 
-	err = runtime.BindStyledParameter("simple", false, "id", ctx.Param("id"), &id)
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
 	}
@@ -190,13 +840,29 @@ func (w *ServerInterfaceWrapper) GetUser(ctx echo.Context) error {
 	return err
 }
 
+// UpdateNotifySettings converts echo context to params.
+func (w *ServerInterfaceWrapper) UpdateNotifySettings(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "id" -------------
+	var id string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.UpdateNotifySettings(ctx, id)
+	return err
+}
+
 // GetUserComics converts echo context to params.
 func (w *ServerInterfaceWrapper) GetUserComics(ctx echo.Context) error {
 	var err error
 	// ------------- Path parameter "id" -------------
 	var id string
 
-	err = runtime.BindStyledParameter("simple", false, "id", ctx.Param("id"), &id)
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
 	}
@@ -210,6 +876,27 @@ func (w *ServerInterfaceWrapper) GetUserComics(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter q: %s", err))
 	}
 
+	// ------------- Optional query parameter "site" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "site", ctx.QueryParams(), &params.Site)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter site: %s", err))
+	}
+
+	// ------------- Optional query parameter "sort" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "sort", ctx.QueryParams(), &params.Sort)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter sort: %s", err))
+	}
+
+	// ------------- Optional query parameter "order" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "order", ctx.QueryParams(), &params.Order)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter order: %s", err))
+	}
+
 	// ------------- Optional query parameter "offset" -------------
 
 	err = runtime.BindQueryParameter("form", true, false, "offset", ctx.QueryParams(), &params.Offset)
@@ -229,13 +916,45 @@ func (w *ServerInterfaceWrapper) GetUserComics(ctx echo.Context) error {
 	return err
 }
 
+// SubscribeComic converts echo context to params.
+func (w *ServerInterfaceWrapper) SubscribeComic(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "id" -------------
+	var id string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.SubscribeComic(ctx, id)
+	return err
+}
+
+// UpdateDigestSettings converts echo context to params.
+func (w *ServerInterfaceWrapper) UpdateDigestSettings(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "id" -------------
+	var id string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.UpdateDigestSettings(ctx, id)
+	return err
+}
+
 // UnsubscribeComic converts echo context to params.
 func (w *ServerInterfaceWrapper) UnsubscribeComic(ctx echo.Context) error {
 	var err error
 	// ------------- Path parameter "user_id" -------------
 	var userId string
 
-	err = runtime.BindStyledParameter("simple", false, "user_id", ctx.Param("user_id"), &userId)
+	err = runtime.BindStyledParameterWithLocation("simple", false, "user_id", runtime.ParamLocationPath, ctx.Param("user_id"), &userId)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter user_id: %s", err))
 	}
@@ -243,7 +962,7 @@ func (w *ServerInterfaceWrapper) UnsubscribeComic(ctx echo.Context) error {
 	// ------------- Path parameter "id" -------------
 	var id int
 
-	err = runtime.BindStyledParameter("simple", false, "id", ctx.Param("id"), &id)
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
 	}
@@ -253,6 +972,30 @@ func (w *ServerInterfaceWrapper) UnsubscribeComic(ctx echo.Context) error {
 	return err
 }
 
+// UpdateReadProgress converts echo context to params.
+func (w *ServerInterfaceWrapper) UpdateReadProgress(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "user_id" -------------
+	var userId string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "user_id", runtime.ParamLocationPath, ctx.Param("user_id"), &userId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter user_id: %s", err))
+	}
+
+	// ------------- Path parameter "id" -------------
+	var id int
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.UpdateReadProgress(ctx, userId, id)
+	return err
+}
+
 // This is a simple interface which specifies echo.Route addition functions which
 // are present on both echo.Echo and echo.Group, since we want to allow using
 // either of them for path registration
@@ -281,40 +1024,102 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 		Handler: si,
 	}
 
+	router.POST(baseURL+"/admin/broadcast", wrapper.AdminBroadcast)
+	router.POST(baseURL+"/admin/comics/:id/crawl", wrapper.AdminCrawlComic)
+	router.POST(baseURL+"/admin/comics/:id/merge", wrapper.AdminMergeComic)
+	router.PUT(baseURL+"/admin/comics/:id/status", wrapper.AdminUpdateComicStatus)
+	router.GET(baseURL+"/admin/messenger-pages", wrapper.AdminMessengerPages)
+	router.PUT(baseURL+"/admin/messenger-pages/:id", wrapper.AdminUpdateMessengerPage)
+	router.GET(baseURL+"/admin/notifications", wrapper.AdminNotifications)
+	router.POST(baseURL+"/admin/notifications/retry", wrapper.AdminRetryFailedNotifications)
+	router.POST(baseURL+"/admin/notifications/:id/retry", wrapper.AdminRetryNotification)
+	router.PUT(baseURL+"/admin/sites/:page/status", wrapper.AdminUpdateSiteStatus)
+	router.POST(baseURL+"/admin/sweep", wrapper.AdminSweep)
 	router.GET(baseURL+"/comics", wrapper.Comics)
 	router.GET(baseURL+"/comics/:id", wrapper.GetComic)
+	router.GET(baseURL+"/sites", wrapper.Sites)
 	router.GET(baseURL+"/users", wrapper.Users)
 	router.GET(baseURL+"/users/:id", wrapper.GetUser)
+	router.PUT(baseURL+"/users/:id", wrapper.UpdateNotifySettings)
 	router.GET(baseURL+"/users/:id/comics", wrapper.GetUserComics)
+	router.POST(baseURL+"/users/:id/comics", wrapper.SubscribeComic)
+	router.PUT(baseURL+"/users/:id/digest", wrapper.UpdateDigestSettings)
 	router.DELETE(baseURL+"/users/:user_id/comics/:id", wrapper.UnsubscribeComic)
+	router.PUT(baseURL+"/users/:user_id/comics/:id", wrapper.UpdateReadProgress)
 
 }
 
 // Base64 encoded, gzipped, json marshaled Swagger object
 var swaggerSpec = []string{
 
-	"H4sIAAAAAAAC/9xX32/bNhD+VwhuQF+EyF2zodBbkWKFgWAIkvmpMApaOlnsJJImT2kMQ//7cKRiORYV",
-	"u02GDXuyzB933919952047lujFag0PFsx42wogEE6//VspFIDwW43EqDUiue8YWDgqFmzkAuyy3DClgj",
-	"HmTTNky1zQos0yWzkGtbOPatknnFhAVmAVuroGBS+TsKHpAZsYYLnnBJljct2C1PuBIN8Kz3n3CXV9CI",
-	"AKQUbY08+3WW8FLbRiDPuFT42yVPOG4NhL+wBsu7LuG6LB08E4OFTQsOn+IhgILV0iHTBqygO1MYewdR",
-	"kGdi3EzD887YasvI2/fA2sQR8QGBQyvVmneEIJz0Nb/Sjcw9FSw5QQl+Oa+EWdxej5FeVcIgWNbaemw7",
-	"4bKIXCEXbP4xko2Ey2Yd9bO4vaaE5HT3jWPiXqCwMY+1QHBIsCY8v3EsnGF5wB6zEtIYR+73IneIOuM7",
-	"N0QozzRRBPixu5S+CXfRzHb7Fb36CjmSDX/8JgrimkjjnTMLzmjlKIKjEtO2f5IIjX/42ULJM/5TOqhE",
-	"2nMlDUQZcAhrxdYDo56SFgqefX40uuwS4rQdE0sYE+PIB2OYy7WBJzwZ8jWAfXrvj73+hBPMtSvaX0ER",
-	"pdsP1dnqUtbwxYRGGXWu7dnJaqn+ihpwsZA9UaZjHleclqQqdSR7N3NWassoDkUSnVcCV5pkCiXW0Ifo",
-	"tz7czHnC78G6cPftxYwwagNKGMkz/u5idvGO2CKw8hlPh+SvY9p663W+F6rHQnBvMojWvHjMsfN2h5nz",
-	"Oc654Uja622XnDwZpke3JEIGynvIv8xmge0KQWFPwVrmHln61VEIuwPtPL8ZfOuNG6JLjvJz1+Y5OFe2",
-	"db3th+Je1/fp8vUWa7dvIr6kpT736U4W3akC9B2wEjRMtAqselqET4BXvSQdlWFSsv20IS4Mw0ZSbw1d",
-	"j7aFw+kzGnkvLckZsjTO+u+6Vb0As28SK4qmS/jl7HKi/x0rNDimNDJ4kA6na9K6/oXpnHYIh48LsehX",
-	"/3mqehl+MUtDFIcJoZXDfJxF0eeT8gnQoz1BTi+5L+PmXmSX/7sS/JBa92/tZOVggjLUUzWaUvNIpWi0",
-	"zz8mrJBlCRYUhnb0e34G3g0z8OUFPT0nNucMk//w2HmtkTNd8ymZ9CUjfSxJW5+Xx3RHP18GQu71oYAa",
-	"MPIStlB7FPv35iPRHE6cNcVeg359FN/LwX9hnJ7IJhvuPDsGTxWYPh/B3j9m3H/I8ArRZGla61zUlXaY",
-	"vZ+9n6XCyPT+LT8m5zWdYn/SF9mdt8S7Zfd3AAAA//9wE0sJmhAAAA==",
+	"H4sIAAAAAAAC/+xcX3PbtrL/KhjeO5MX2lKdtLf1W2rfNJ6TpJnInj50MhmIXEpoSIABQDtqxt/9zC7A",
+	"vwJF2ZWd9PQ8xSJBYLF/f7sL5EuUqKJUEqQ10emXqOSaF2BB069cFMLiHymYRIvSCiWj0+jKQMqsYqaE",
+	"RGQbZtfACv5ZFFXBZFUsQTOVMQ2J0qlhN2uRrBnXwDTYSktImZD0jYTPlpV8BcdRHAmc+VMFehPFkeQF",
+	"RKd+/TgyyRoK7gjJeJXb6PT7eRxlShfcRqeRkPaHZ1EceSKi0+/m8ziymxLcS1iBjm5v40hlmYEdO9Lw",
+	"qQJj+9QhuZzlwlimStAcvxmj2C8QJDlEcYhGnYLeJnGhtGWp0JDgg5j5aZkwjJsEZCrkimVKM6SEcZmy",
+	"FHrPU27BjJFNa3apBol8/D3iJoliIiV635BrrBZyRdR+GmcmrcGWG0fRHZj4Kcy/KAoRYISFbRp+lfnG",
+	"6xtLVCESgypp18Iw/CBmcLw6ZksAuTqWYEcIobm7tASWVzqgTy8E5M5EUGqegOUmZqZa4rglpCQPppBO",
+	"Xpa5AIPjKwP6ifFfjFGFS4ZkRe/jKOfGfqhKnB+HNSuGBHhbz0P2/rNWPE24oR2VGgVlBdCrAozhqwCn",
+	"L9FMDEhbk2+CYkLLEhpSpLOeq6VHLf+AxEa3cUvCOzBVHiBEQyJKUfurPi1vGudDhDC/ENqIpzBscl3i",
+	"OvOH6DtDyWxTlax5efXu1TZJZ2teWtCs0vk2X2L6zoI2P8NayHTXjuqRtDW25oZJZZkGngb2FEepMHyZ",
+	"Q2DKc//GKRkTbqJE85scOnMtlcqBS5xLBGYhPrCL8+DiolgFmXH17hVqCS38xDB+zS3XIbbk6Kss8m5k",
+	"5SeGuTE1X0KzOHsJU+5tZeubMqjkb1GLKD5wz7fQtyjjkeWC4r8d06+3QSJeofN0QtNgSiUN7mCgh85x",
+	"nH6JhIWC/vhfDVl0Gv3PrI30M2/zM1otaungWvMNCSAc+V9vRXnv2yiiC0PxMqgSY5H3V3reOOfR762y",
+	"PN9pH46QgttkjSHPRR+MOnlO05pp22/crlstbsO548f72zg6FyswdgHWCrky255grapA9H6pKqIy5RuW",
+	"0gxoeCnk4ho0pDESulT2iWFWFPCnknDMztsIfzLvopuTp3FUCOl+zEPcKlQaUKAFyJRJuGl9CbdMyQRi",
+	"pjRLVJ5DQtinYEJaxThLucg3+PIG4GNekx7FTcgR0lgu8QkNjeLIjQzEGvcq5ZuAS+IbZA6+7680YNIc",
+	"fy8qmfJNjz097vywmznDWIScCjn62k0uLLdVQM7jDnZhVen8KeqhyJjVFcRosxXioIxlPDcQ8LQD0poV",
+	"QuT9v9ZKB+JQUPA0mOG7mBmLkxIiTHKKc+jY1lymOfQEe81zkX5wjquSpipLpS2kH7yJ0gY/oLaqykZx",
+	"xHN0jpsPO/FGPI4jXlYFl+RgiT6g/U0hCdpuvBNQvAa9gpGonVZlLhJu4eJ8m6CLczLYeohzMDETaKQp",
+	"5GAhZTzD2F7gEtPepbtamFJjQK5A1+5/IFoN3EL6POBEf1uD9KmKYTxNKY43uQYCwSMUVChmjavxa8fU",
+	"BjqxeqhbCRM6sZJKj4CGlQZAFxmgFvJEFdCgM5r/BrdA0MZYrlEpef4R7ccqWq9NeJSkbVaYZYiMQVHa",
+	"TWhnIdTygiewVOqj28LFeei7MGogBBAGDQMxC+THTtvtCXo8kvAkAWMu1UeQI/S4EczikNhxBN22NDeg",
+	"a/a28uvH1n1U4ILkG56pdmyPIfyHEFOXuyEhvVFWZGiuNPGWbKxFxduZg/jAtWHN4FCo9rE45IHetKE6",
+	"/Cl6pNCHDnOqjMmpGSZ8iuwwAY3uUwXVHZxLyAS7fB3LHzCDbQJcKJQRXASG41jGBTolz+SgqsBn+9y9",
+	"HveevlIy3DHq5977NQ1WGOCbWhP8gDbM+mWjOKoXot0EQycaSFBNiGRIyYKmwxD5Jz9Xq0NdRWw2Erd6",
+	"Pmoim3EHlqy5lJAHk2J80XgsQ8C0a3Ath4raWeLOIIeV5oVzsInSKQHO5Vqpj/hJwUUeZJ3lehXKPi79",
+	"hGgkll2cx+zczYuY9+Xl5VvmZ2dX714RSqY1MMZqMOaYvVHW7WK5YT1Kd8MWz5gQU98BT99qtcL571Bn",
+	"wNRaZc4iXJY6lhffBhe1ejNecbFawM7iRFd2tZdgfMWF3Kfg4qYPMWMhLLwEntv1NllLHY6M+A1abpbz",
+	"1QolQ+NcjCmUoVxT2LrGRu6DihKIZ4PBzE2wEDKBEedhcMkbPlxzb7+RKGkgqay4hhdc5JUGE8Iu5OeI",
+	"TsMMktM6QVNROMuqnCkJI74eP9yZQrupVUYb6pYh71JfOmvKrfUYxx8EjFOVpmx09y2VWZcPdw0f/xIy",
+	"7UUPmsZlGzHL+UZV1v1ChFnxPEe75h0Ra0jBiJWEtOOk2jQoA5usqQi7cQ8q+VGqGxl0Sw2hobB0KQoY",
+	"I3VvzcIPF0439lqjo0e1Qey3ULhuRra4VsZOukQP87zhBH1BtexMPfQGO2pvuZAUHT7zosxxzrW1pTmd",
+	"zZry/8zqagPyyGou10dK5kLCzCpxZNdcro4SXh4Vyh4VQq6PjDk5evr0/36aT24JSQpt5MpAIHPnZRmC",
+	"S8/LkplElWPZSlvwmyiMdfLysD2vwNiX9yle7ZjvdbAc4YtG8bC8FE5OcJ7fDlg5CpJ7n1IxBb3N2V8B",
+	"OSNzXo7Ald/WoKE3R6e1gRkywknDlGQeYRyzCyxXqLYb5mjB+KduZLhsXmqViRw+lCLZJgGV1xfua8Pa",
+	"nsCIdCQ1G1flbViCj4TMVMAo3l5QAQvlI7EDjfBtqcjLCEs23rx6/vYiiqNr0MZ9+93xHGlUJUheiug0",
+	"eno8P34aoQezazKkGU8LIWfLXjNMGTtSUK0zWcy885w1yb3rQR2z5zgbiSCKo6b3eZHiPvBV23Rz7gOM",
+	"/VmlG1fNkxak9e4h9zKf/WGcB2w7gLtq/O38t30Phdk7PXC9BNr7yfzk8At7YEnLB2tMqMVLwPSLsqDb",
+	"OHo2n49WpHC0q/rQwKcjSuobWyRMp14ck5TfI/fkPT7yonYucvZFpLczF/ZGRX6Gr30XRqqb2NVCEp6s",
+	"ia5Uq7JExOO7XE1VLqsQSpRcG0indYJWOfN9pu6ZjN939OGoV4xa3LaKKdfrSzzQzm6R+fstdZgfTB18",
+	"r2lbCc7qPqRHhjG5p36fQgMz/BqTisaJQnoX+ePIZ6MeXlmWqUrSjN/PT8bGeVnLJ5YtoSb3DrrlCsWj",
+	"ukWl6mHF2bVi3J83wq4pS22CuTb9IjUyqlA1o9rnoqlZTytfp2I+oXyuRO5po2M+wrCPUNoDauPhHWJn",
+	"f3t5xEcwgUUDuynf0Ku6PW9GnWFjNX44qYmwBvLsIexibx1va2BlZUdPH1ApRdJfvq+S7jqWMK20V3Te",
+	"hche1MWrx3ach1fVQRPym1RXd9LIy+3RVa8puh25Hv/plygInenwRIvNSt/P0NeudrdUNu61U0xTr6hx",
+	"8z6Os9PXMdFflMxeBzh6S24f5JgQXZMSON4dCk4NZEJ+YdQhPE/TgVzQNzilIiBA4qBwZnylOd7uSGEB",
+	"o2lLugYYpC5eNnPs60T6PJ1wI4GO4r3dSZMKPVjgC/UdH9mpDDR2QkMd6Cu9cgcD4fOO1VI8FMb4RPQg",
+	"2tyrbY/6l3furGlvMENlq+xSfUYX4wJjjMAWsG0ltLHTKvmmt/qEMnYHt72m4BnS+mXonK3vQd2nS3Ub",
+	"h6Xfkj3zZ6r2GOkOXd2+fwxH2mXdPf1oc7550A95GE2caezZTNQmsCDh6+V9zaTezLT2UV/INR6Givhg",
+	"DqLbiwow/kVwOxr6XaeHYTkB3H34HuD5nVj+pl8m3N/o/w6FgL6pbUv4TfDQwd0FO4IvAwq0N9o0wiKe",
+	"wXh0j0zH3TxI9uuP7YtWsMWyX8bTbcYEVMR3YL46Svm6OU+n47xv4oPSO5jDMTcA5Q7vYrm2jEu/OKPh",
+	"TF2DZmkFtW5JdTOtPgtaKVz/HSxKiwjjTmdhgU7p2ipFVh/I05WUh8FdbU9rF9SqY23TpO7v8ax+PLCJ",
+	"CczhhTk9Tum9MIy7WfUtwqLJzH8vgN5AH5e51eJoYC79dGAXQXANMruipyE90TcZ4y7517equIGU1aGv",
+	"rwO/gP2nFNFfYPTql4l3V1gMSxU4a4TPwlgzKhMKepPSoL7ImjwnqgHwZO3J8bfo+qJZCPtIJZKuR78f",
+	"rh/uzTGkyy584rnlrsDt6bvc4CFvrvzTh+cNrvTXsx23iy4/8EmXH3sZ9G6m/AL2yp1v3GnKOOagZZi/",
+	"uQjiMDh12LG+btrDwvXhxC2tpE8GZz73kQYeYcGmVSqyDDSd90YHRe/oUMCiPRTwzdbOBvueSls8E6ls",
+	"SQVId6LjMZGsU6w9MWxQA0ZbT35zHsw1V4MQFrqN4it/g2g0CpH8w4lX0H3cCxb6tqS7W9DevbYqptqI",
+	"O/pWsErmYAwjZIW0r8Q1yDEXNIYsv7rqT0PHT3tB2/9C4ENA4B2q57Gx6ZxoDEDku9tNjdrikezxqkcL",
+	"s8qdsvAQeQDQ6mF7Iej/HEffO2Y6elrFH3371nqxPT1jvNuTPRwp7mj1TtZs+f+xekAleWXXSos/6+M8",
+	"Pz08nbSyv686YFnLsJOTx2JYfUSsF0vdSaRA8L2QFrTk9UFwGvbssUm1SjGTq5v24sRY8tiJ3/7y+Fi9",
+	"1EPS9i6ghgTENfSPgsX1nXUEG9zsuqMegq+D2/v/GK822HdAwG4EK1QKDWptbjD6U//fKHh1Em+OB4yf",
+	"H1XptqGh5vT2eXDsiv98aAFskwu7A3mByWUbosOxuTPi0aKz38VdAelXKLRNcJO139znCFIHY00n1nQT",
+	"r/S3+RAa8m68GREuzdC7BvgPFu/hXWGPswEX82p4hTJmSfC/HOr+LwT+XmbvaPo3ci6vp4MTKq90s7nd",
+	"2o/P6PRcUCFfqYTn7BJ5taBBkf/Pkejq1elsluMAbEWe/jj/cT7jpZhdfxfdvr/99wDJCXzpkFAAAA==",
 }
 
-// GetSwagger returns the Swagger specification corresponding to the generated code
-// in this file.
-func GetSwagger() (*openapi3.Swagger, error) {
+// GetSwagger returns the content of the embedded swagger specification file
+// or error if failed to decode
+func decodeSpec() ([]byte, error) {
 	zipped, err := base64.StdEncoding.DecodeString(strings.Join(swaggerSpec, ""))
 	if err != nil {
 		return nil, fmt.Errorf("error base64 decoding spec: %s", err)
@@ -329,9 +1134,57 @@ func GetSwagger() (*openapi3.Swagger, error) {
 		return nil, fmt.Errorf("error decompressing spec: %s", err)
 	}
 
-	swagger, err := openapi3.NewSwaggerLoader().LoadSwaggerFromData(buf.Bytes())
+	return buf.Bytes(), nil
+}
+
+var rawSpec = decodeSpecCached()
+
+// a naive cached of a decoded swagger spec
+func decodeSpecCached() func() ([]byte, error) {
+	data, err := decodeSpec()
+	return func() ([]byte, error) {
+		return data, err
+	}
+}
+
+// Constructs a synthetic filesystem for resolving external references when loading openapi specifications.
+func PathToRawSpec(pathToFile string) map[string]func() ([]byte, error) {
+	var res = make(map[string]func() ([]byte, error))
+	if len(pathToFile) > 0 {
+		res[pathToFile] = rawSpec
+	}
+
+	return res
+}
+
+// GetSwagger returns the Swagger specification corresponding to the generated code
+// in this file. The external references of Swagger specification are resolved.
+// The logic of resolving external references is tightly connected to "import-mapping" feature.
+// Externally referenced files must be embedded in the corresponding golang packages.
+// Urls can be supported but this task was out of the scope.
+func GetSwagger() (swagger *openapi3.Swagger, err error) {
+	var resolvePath = PathToRawSpec("")
+
+	loader := openapi3.NewSwaggerLoader()
+	loader.IsExternalRefsAllowed = true
+	loader.ReadFromURIFunc = func(loader *openapi3.SwaggerLoader, url *url.URL) ([]byte, error) {
+		var pathToFile = url.String()
+		pathToFile = path.Clean(pathToFile)
+		getSpec, ok := resolvePath[pathToFile]
+		if !ok {
+			err1 := fmt.Errorf("path not found: %s", pathToFile)
+			return nil, err1
+		}
+		return getSpec()
+	}
+	var specData []byte
+	specData, err = rawSpec()
+	if err != nil {
+		return
+	}
+	swagger, err = loader.LoadSwaggerFromData(specData)
 	if err != nil {
-		return nil, fmt.Errorf("error loading Swagger: %s", err)
+		return
 	}
-	return swagger, nil
+	return
 }
@@ -18,6 +18,15 @@ import (
 	"github.com/tinoquang/comic-notifier/pkg/util"
 )
 
+// RoleAdmin is role of users allowed to call admin API
+const RoleAdmin = "admin"
+
+// Claims of session JWT, Id is user's app scope ID
+type Claims struct {
+	Role string `json:"role,omitempty"`
+	jwt.StandardClaims
+}
+
 // Handler main authenticate handler
 type AuthHandler struct {
 	store db.Store
@@ -25,7 +34,7 @@ type AuthHandler struct {
 }
 
 type infoCrawler interface {
-	GetUserInfoFromFacebook(field, id string) (user db.User, err error)
+	GetUserInfoFromFacebook(field, id, pageToken string) (user db.User, err error)
 }
 
 // RegisterHandler create new auth route
@@ -36,7 +45,7 @@ func RegisterHandler(g *echo.Group, store db.Store, crawl infoCrawler) {
 	g.GET("/auth", h.auth)
 	g.GET("/status", h.loggedIn, middleware.JWTWithConfig(middleware.JWTConfig{
 		SigningKey:  []byte(conf.Cfg.JWT.SecretKey),
-		Claims:      &jwt.StandardClaims{},
+		Claims:      &Claims{},
 		TokenLookup: "cookie:_session",
 	}))
 	g.GET("/login", h.login)
@@ -145,12 +154,15 @@ func (h *AuthHandler) auth(ctx echo.Context) error {
 
 func (h *AuthHandler) generateJWT(userAppID string) (string, error) {
 
-	claims := &jwt.StandardClaims{
-		Issuer:    conf.Cfg.JWT.Issuer,
-		IssuedAt:  time.Now().Unix(),
-		ExpiresAt: time.Now().AddDate(0, 1, 0).Unix(),
-		Audience:  conf.Cfg.JWT.Audience,
-		Id:        userAppID,
+	claims := &Claims{
+		Role: userRole(userAppID),
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    conf.Cfg.JWT.Issuer,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().AddDate(0, 1, 0).Unix(),
+			Audience:  conf.Cfg.JWT.Audience,
+			Id:        userAppID,
+		},
 	}
 	// Create JWT and send back
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -165,6 +177,18 @@ func (h *AuthHandler) generateJWT(userAppID string) (string, error) {
 
 }
 
+// userRole return admin role for users configured as admin, other users have no role
+func userRole(userAppID string) string {
+
+	for _, id := range conf.Cfg.Admin.AppIDs {
+		if id == userAppID {
+			return RoleAdmin
+		}
+	}
+
+	return ""
+}
+
 func (h *AuthHandler) validateToken(token string) (userAppID string, err error) {
 
 	userAppID = ""
@@ -208,7 +232,7 @@ func (h *AuthHandler) checkUserExist(ctx echo.Context, userAppID string) {
 			return
 		}
 
-		u, err := h.crawl.GetUserInfoFromFacebook("appid", userAppID)
+		u, err := h.crawl.GetUserInfoFromFacebook("appid", userAppID, "")
 		if err != nil {
 			logging.Danger(err)
 			return
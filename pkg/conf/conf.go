@@ -20,6 +20,8 @@ var Cfg *Config
 type WebhookCfg struct {
 	WebhookToken  string
 	GraphEndpoint string
+	Workers       int // events of one user are handled by the same worker in order
+	QueueSize     int // events waiting per worker, webhook requests wait when it's full
 }
 
 // FacebookSecret include token and app secret facebook provide
@@ -51,17 +53,66 @@ type JWT struct {
 	SecretKey string
 }
 
+// SMTPCfg for sending notification email
+type SMTPCfg struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	From     string
+}
+
+// NotifierCfg for notification channels other than Messenger, channel is disabled if its config is empty
+type NotifierCfg struct {
+	TelegramToken  string
+	SMTP           SMTPCfg
+	DigestTimezone string // users' digest delivery time is in this location
+}
+
+// CrawlerCfg for comic crawler configuration
+type CrawlerCfg struct {
+	SiteFile    string
+	RateLimit   float64 // requests per second to each host, sites can override it in site file
+	Burst       int
+	Concurrency int // max parallel requests to each host
+}
+
+// AdminCfg for operator of the bot, admin alerts are only logged if PSID is empty.
+// Users whose app scope ID is in AppIDs get admin role in their JWT
+type AdminCfg struct {
+	PSID   string
+	AppIDs []string
+}
+
+// MetricsCfg for Prometheus endpoint, it's served with basic auth and disabled if Password is empty
+type MetricsCfg struct {
+	User     string
+	Password string
+}
+
+// LogCfg for logger, level is one of debug, info, warning, error and format is logfmt or json
+type LogCfg struct {
+	Level  string
+	Format string
+}
+
 // Config main struct for get config from env
 type Config struct {
-	Port           string
-	Host           string
-	Webhook        WebhookCfg
-	FBSecret       FacebookSecret
-	DBInfo         string
-	WrkDat         WorkerData
-	FirebaseBucket FirebaseBucket
-	JWT            JWT
-	CtxTimeout     int
+	Port            string
+	Host            string
+	Webhook         WebhookCfg
+	FBSecret        FacebookSecret
+	DBInfo          string
+	WrkDat          WorkerData
+	FirebaseBucket  FirebaseBucket
+	JWT             JWT
+	Crawler         CrawlerCfg
+	Notifier        NotifierCfg
+	Admin           AdminCfg
+	Metrics         MetricsCfg
+	Log             LogCfg
+	CtxTimeout      int
+	ShutdownTimeout int // seconds to finish in-flight work after SIGTERM, Heroku kills process 30s after it
 }
 
 func currentPath() string {
@@ -78,6 +129,8 @@ func Init() {
 		Webhook: WebhookCfg{
 			WebhookToken:  getEnv("FBWEBHOOK_TOKEN", ""),
 			GraphEndpoint: getEnv("FBWEBHOOK_GRAPH_ENDPOINT", ""),
+			Workers:       lookupEnvAsInt("FBWEBHOOK_WORKERS", 8),
+			QueueSize:     lookupEnvAsInt("FBWEBHOOK_QUEUE_SIZE", 64),
 		},
 		FBSecret: FacebookSecret{
 			PakeToken: getEnv("FBSECRET_PAGE_TOKEN", ""),
@@ -101,9 +154,39 @@ func Init() {
 			Issuer:    getEnv("JWT_ISSUER", ""),
 			Audience:  getEnv("JWT_AUDIENCE", ""),
 		},
-		Port:       getEnv("PORT", ""),
-		Host:       getEnv("HOST", ""),
-		CtxTimeout: getEnvAsInt("CTX_TIMEOUT", 15),
+		Crawler: CrawlerCfg{
+			SiteFile:    getEnv("CRAWLER_SITE_FILE", currentPath()+"/sites.yml"),
+			RateLimit:   lookupEnvAsFloat("CRAWLER_RATE_LIMIT", 1),
+			Burst:       lookupEnvAsInt("CRAWLER_BURST", 3),
+			Concurrency: lookupEnvAsInt("CRAWLER_CONCURRENCY", 2),
+		},
+		Notifier: NotifierCfg{
+			TelegramToken: lookupEnv("TELEGRAM_BOT_TOKEN"),
+			SMTP: SMTPCfg{
+				Host:     lookupEnv("SMTP_HOST"),
+				Port:     lookupEnv("SMTP_PORT"),
+				User:     lookupEnv("SMTP_USER"),
+				Password: lookupEnv("SMTP_PASSWORD"),
+				From:     lookupEnv("SMTP_FROM"),
+			},
+			DigestTimezone: getEnv("DIGEST_TIMEZONE", "Asia/Ho_Chi_Minh"),
+		},
+		Admin: AdminCfg{
+			PSID:   lookupEnv("ADMIN_PSID"),
+			AppIDs: lookupEnvAsList("ADMIN_APP_IDS"),
+		},
+		Metrics: MetricsCfg{
+			User:     getEnv("METRICS_USER", "prometheus"),
+			Password: lookupEnv("METRICS_PASSWORD"),
+		},
+		Log: LogCfg{
+			Level:  lookupEnv("LOG_LEVEL"),
+			Format: lookupEnv("LOG_FORMAT"),
+		},
+		Port:            getEnv("PORT", ""),
+		Host:            getEnv("HOST", ""),
+		CtxTimeout:      getEnvAsInt("CTX_TIMEOUT", 15),
+		ShutdownTimeout: lookupEnvAsInt("SHUTDOWN_TIMEOUT", 25),
 	}
 }
 
@@ -121,6 +204,12 @@ func getEnv(key string, defaultVal string) (value string) {
 	return defaultVal
 }
 
+// Simple helper function to read an optional environment, return empty string if not set
+func lookupEnv(key string) string {
+	value, _ := os.LookupEnv(key)
+	return value
+}
+
 // Simple helper function to read an environment variable into integer or return a default value
 func getEnvAsInt(name string, defaultVal int) int {
 	valueStr := getEnv(name, "")
@@ -131,6 +220,36 @@ func getEnvAsInt(name string, defaultVal int) int {
 	return defaultVal
 }
 
+// Simple helper function to read an optional environment variable into integer or return a default value
+func lookupEnvAsInt(name string, defaultVal int) int {
+	if value, err := strconv.Atoi(lookupEnv(name)); err == nil {
+		return value
+	}
+
+	return defaultVal
+}
+
+// Simple helper function to read an optional environment variable into float or return a default value
+func lookupEnvAsFloat(name string, defaultVal float64) float64 {
+	if value, err := strconv.ParseFloat(lookupEnv(name), 64); err == nil {
+		return value
+	}
+
+	return defaultVal
+}
+
+// Simple helper function to read an optional comma separated environment variable, return nil if not set
+func lookupEnvAsList(name string) []string {
+	var values []string
+	for _, v := range strings.Split(lookupEnv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
 func getDBSecret() string {
 	DBConfig, err := url.Parse(getEnv("DATABASE_URL", ""))
 
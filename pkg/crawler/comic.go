@@ -2,278 +2,204 @@ package crawler
 
 import (
 	"context"
-	"fmt"
 	"net/url"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/pkg/errors"
 
 	"github.com/PuerkitoBio/goquery"
 
-	"github.com/tinoquang/comic-notifier/pkg/conf"
 	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
 	"github.com/tinoquang/comic-notifier/pkg/logging"
 	"github.com/tinoquang/comic-notifier/pkg/util"
 )
 
 type helper interface {
-	detectSpoiler(name, chapURL, chapterName, attr1, attr2 string) error
-	getPageSource(comicURL string) (doc *goquery.Document, err error)
+	chapterStats(ctx context.Context, chapURL string, check *spoilerCheck) (images, pageSize int, err error)
+	getPageSource(ctx context.Context, comicURL string) (doc *goquery.Document, err error)
+	getPageSourceIfModified(ctx context.Context, comicURL string, cache db.PageCache) (doc *goquery.Document, newCache db.PageCache, err error)
 }
 type comicCrawler struct {
-	crawlerMap  map[string]func(ctx context.Context, doc *goquery.Document, comic *db.Comic, helper helper, checkSpoiler bool) (err error)
-	crawlHelper helper
+	crawlerMap    map[string]func(ctx context.Context, doc *goquery.Document, comic *db.Comic) (chapters []db.Chapter, err error)
+	spoilerChecks map[string]*spoilerCheck
+	searchSites   []site
+	crawlHelper   helper
 }
 
-func newComicCrawler(crawlHelper helper) *comicCrawler {
+// maxSearchResults limit comics returned by SearchComic, Messenger carousel shows at most 10 elements
+const maxSearchResults = 10
 
-	crawlerMap := make(map[string]func(ctx context.Context, doc *goquery.Document, comic *db.Comic, helper helper, checkSpoiler bool) (err error))
-	crawlerMap["beeng.net"] = crawlBeeng
-	crawlerMap["blogtruyen.vn"] = crawlBlogtruyen
-	crawlerMap["truyentranhtuan.com"] = crawlTruyentranhtuan
-	crawlerMap["truyenqq.com"] = crawlTruyenqq
-	crawlerMap["hocvientruyentranh.net"] = crawlHocvientruyentranh
+func newComicCrawler(sites []site, crawlHelper helper) *comicCrawler {
 
-	return &comicCrawler{
-		crawlerMap:  crawlerMap,
-		crawlHelper: crawlHelper,
-	}
-}
-
-// GetComicInfo return link of latest chapter of a page
-func (c *comicCrawler) GetComicInfo(ctx context.Context, comicURL string, checkSpoiler bool) (comic db.Comic, err error) {
-
-	defer func() {
-		if r := recover(); r != nil {
-			switch x := r.(type) {
-			case string:
-				err = errors.New(x)
-			case error:
-				err = x
-			default:
-				err = errors.New("Unknown panic")
-			}
+	crawlerMap := make(map[string]func(ctx context.Context, doc *goquery.Document, comic *db.Comic) (chapters []db.Chapter, err error))
+	spoilerChecks := make(map[string]*spoilerCheck)
+	searchSites := []site{}
+	for i := range sites {
+		crawlerMap[sites[i].Host] = sites[i].crawl
+		if sites[i].Spoiler != nil {
+			spoilerChecks[sites[i].Host] = sites[i].Spoiler
+		}
+		if sites[i].Search != nil {
+			searchSites = append(searchSites, sites[i])
 		}
-
-		return
-	}()
-
-	parsedURL, err := url.Parse(comicURL)
-	if err != nil /*|| parsedURL.Host == "" */ {
-		return db.Comic{}, util.ErrInvalidURL
 	}
 
-	if _, ok := c.crawlerMap[parsedURL.Hostname()]; !ok {
-		return db.Comic{}, util.ErrPageNotSupported
+	return &comicCrawler{
+		crawlerMap:    crawlerMap,
+		spoilerChecks: spoilerChecks,
+		searchSites:   searchSites,
+		crawlHelper:   crawlHelper,
 	}
+}
 
-	// Remove all params in comicURL --> avoid duplicate URL
-	parsedURL.RawQuery = ""
-	comicURL = parsedURL.String()
+// GetComicInfo return link of latest chapter of a page and its full chapter list, ordered oldest first
+func (c *comicCrawler) GetComicInfo(ctx context.Context, comicURL string) (comic db.Comic, chapters []db.Chapter, err error) {
 
-	doc, err := c.crawlHelper.getPageSource(comicURL)
-	if err != nil {
-		if strings.Contains(err.Error(), "Timeout") {
-			return db.Comic{}, util.ErrCrawlTimeout
-		}
-		return db.Comic{}, util.ErrCrawlFailed
-	}
+	return c.getComicInfo(ctx, comicURL, func(pageURL string) (*goquery.Document, error) {
+		return c.crawlHelper.getPageSource(ctx, pageURL)
+	})
+}
 
-	comic = db.Comic{
-		Page: parsedURL.Hostname(),
-		Url:  comicURL,
-	}
+// GetComicUpdate work like GetComicInfo, but page is fetched conditionally using cache of previous crawl.
+// util.ErrNotModified is returned when page is unchanged, newCache should be saved only after comic is updated
+func (c *comicCrawler) GetComicUpdate(ctx context.Context, comicURL string, cache db.PageCache) (comic db.Comic, chapters []db.Chapter, newCache db.PageCache, err error) {
 
-	err = c.crawlerMap[parsedURL.Hostname()](ctx, doc, &comic, c.crawlHelper, checkSpoiler)
-	if err != nil {
+	newCache = cache
+	comic, chapters, err = c.getComicInfo(ctx, comicURL, func(pageURL string) (doc *goquery.Document, err error) {
+		doc, newCache, err = c.crawlHelper.getPageSourceIfModified(ctx, pageURL, cache)
 		return
-	}
-
-	err = verifyComic(&comic)
+	})
 	return
 }
 
-func crawlBeeng(ctx context.Context, doc *goquery.Document, comic *db.Comic, helper helper, checkSpoiler bool) (err error) {
-
-	comic.Name = doc.Find(".detail").Find("h1").Text()
-	comic.ImgUrl, _ = doc.Find(".cover").Find("img[src]").Attr("data-src")
-	comic.CloudImgUrl = fmt.Sprintf("%s/%s/%s", conf.Cfg.FirebaseBucket.URL, comic.Page, comic.Name)
-
-	// Find latest chap
-	firstItem := doc.Find(".listChapters").Find(".list").Find("li:nth-child(1)")
-	if firstItem.Nodes == nil {
-		return util.ErrCrawlFailed
-	}
-
-	comic.LatestChap = strings.TrimSpace(firstItem.Find(".titleComic").Text())
-	comic.ChapUrl, _ = firstItem.Find("a[href]").Attr("href")
-
-	lastUpdate := strings.Fields(strings.TrimSpace(firstItem.Find(".views").Text()))
-	if len(lastUpdate) == 0 {
-		return util.ErrCrawlFailed
-	}
+// GetChapterStats return number of images and size of chapter page, used to detect spoiler chapter.
+// util.ErrSpoilerCheckNotSupported is returned when site of chapter has no spoiler check, and util.ErrLayoutChanged
+// when chapter images can't be found in page
+func (c *comicCrawler) GetChapterStats(ctx context.Context, chapURL string) (images, pageSize int, err error) {
 
-	comic.LastUpdate, err = time.Parse("02-01-2006", string(lastUpdate[0]))
+	parsedURL, err := url.Parse(chapURL)
 	if err != nil {
-		logging.Danger(err)
-		return util.ErrCrawlFailed
+		return 0, 0, util.ErrInvalidURL
 	}
 
-	if checkSpoiler {
-		err = helper.detectSpoiler(comic.Name, comic.ChapUrl, comic.LatestChap, ".comicDetail2#lightgallery2", "img")
-		if err != nil {
-			return
-		}
+	check, ok := c.spoilerChecks[parsedURL.Hostname()]
+	if !ok {
+		return 0, 0, util.ErrSpoilerCheckNotSupported
 	}
 
-	return
+	return c.crawlHelper.chapterStats(ctx, chapURL, check)
 }
 
-/* ------------------------------------------------------------------------------------------------ */
-
-// blogtruyen crawler
-func crawlBlogtruyen(ctx context.Context, doc *goquery.Document, comic *db.Comic, helper helper, checkSpoiler bool) (err error) {
-
-	name, _ := doc.Find(".entry-title").Find("a[title]").Attr("title")
-	comic.Name = strings.TrimLeft(strings.TrimSpace(name), "truyện tranh")
-	comic.ImgUrl, _ = doc.Find(".thumbnail").Find("img[src]").Attr("src")
-	comic.CloudImgUrl = fmt.Sprintf("%s/%s/%s", conf.Cfg.FirebaseBucket.URL, comic.Page, comic.Name)
-
-	// Find latest chap
-	firstItem := doc.Find(".list-wrap#list-chapters").Find("p:nth-child(1)")
-	if firstItem.Nodes == nil {
-		logging.Danger(err)
-		return util.ErrCrawlFailed
-	}
-
-	comic.LatestChap = firstItem.Find(".title").Find("a[href]").Text()
-	comic.ChapUrl, _ = firstItem.Find(".title").Find("a[href]").Attr("href")
-
-	comic.ChapUrl = "https://blogtruyen.vn" + comic.ChapUrl
-
-	lastUpdate := strings.Fields(strings.TrimSpace(firstItem.Find(".publishedDate").Text()))
-	if len(lastUpdate) == 0 {
-		return util.ErrCrawlFailed
-	}
-
-	comic.LastUpdate, err = time.Parse("02/01/2006", string(lastUpdate[0]))
-	if err != nil {
-		logging.Danger(err)
-		return util.ErrCrawlFailed
+// SearchComic search comic by name on search page of every supported site, at most maxSearchResults comics are returned.
+// Results of sites are interleaved so every site is shown, comic has only page, name, URL and cover image.
+// Error is returned only when every site fails
+func (c *comicCrawler) SearchComic(ctx context.Context, keyword string) ([]db.Comic, error) {
+
+	results := make([][]db.Comic, len(c.searchSites))
+	errs := make([]error, len(c.searchSites))
+
+	var wg sync.WaitGroup
+	for i := range c.searchSites {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			s := &c.searchSites[i]
+			doc, err := c.crawlHelper.getPageSource(ctx, s.searchURL(keyword))
+			if err != nil {
+				logging.Ctx(ctx).With(logging.FieldSite, s.Host).Danger(err)
+				errs[i] = err
+				return
+			}
+			results[i] = s.searchResults(doc)
+		}(i)
 	}
+	wg.Wait()
 
-	if checkSpoiler {
-		err = helper.detectSpoiler(comic.Name, comic.ChapUrl, comic.LatestChap, "#content", "img[src]")
+	failed := 0
+	for _, err := range errs {
 		if err != nil {
-			return
+			failed++
 		}
 	}
-
-	return
-}
-
-/* ------------------------------------------------------------------------------------------------------------------- */
-func crawlTruyentranhtuan(ctx context.Context, doc *goquery.Document, comic *db.Comic, helper helper, checkSpoiler bool) (err error) {
-
-	comic.Name = doc.Find("#infor-box").Find("h1").Text()
-	comic.ImgUrl, _ = doc.Find(".manga-cover").Find("img[src]").Attr("src")
-	comic.CloudImgUrl = fmt.Sprintf("%s/%s/%s", conf.Cfg.FirebaseBucket.URL, comic.Page, comic.Name)
-
-	// Find latest chap
-	firstItem := doc.Find("#manga-chapter").Find(".chapter-name").First()
-	if firstItem.Nodes == nil {
-		return util.ErrCrawlFailed
-	}
-
-	comic.LatestChap = firstItem.Find("a[href]").Text()
-	comic.ChapUrl, _ = firstItem.Find("a[href]").Attr("href")
-
-	lastUpdate := doc.Find("#manga-chapter").Find(".date-name").First().Text()
-	if len(lastUpdate) == 0 {
-		return util.ErrCrawlFailed
+	if failed != 0 && failed == len(errs) {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, util.ErrCrawlTimeout
+		}
+		return nil, util.ErrCrawlFailed
 	}
 
-	date := strings.Split(lastUpdate, ".")
-	if len(date[0]) == 1 {
-		date[0] = "0" + date[0]
-	}
+	comics := []db.Comic{}
+	for row := 0; len(comics) < maxSearchResults; row++ {
+		found := false
+		for _, siteResults := range results {
+			if row < len(siteResults) && len(comics) < maxSearchResults {
+				comics = append(comics, siteResults[row])
+				found = true
+			}
+		}
 
-	comic.LastUpdate, err = time.Parse("02.01.2006", strings.Join(date, "."))
-	if err != nil {
-		logging.Danger(err)
-		return util.ErrCrawlFailed
+		if !found {
+			break
+		}
 	}
 
-	// Page is load by JS, can't get by just using HTTP.Get --> resolve later
-	// if comic.ChapUrl != "" {
-	// 	err = helper.detectSpoiler(comic.ChapUrl, ".vung_doc", "img")
-	// 	if err != nil {
-	// 		return
-	// 	}
-	// }
-
-	return
+	return comics, nil
 }
 
-func crawlTruyenqq(ctx context.Context, doc *goquery.Document, comic *db.Comic, helper helper, checkSpoiler bool) (err error) {
-
-	comic.Name = doc.Find(".center").Find("h1").Text()
-	comic.ImgUrl, _ = doc.Find(".left").Find("img[src]").Attr("src")
-	comic.CloudImgUrl = fmt.Sprintf("%s/%s/%s", conf.Cfg.FirebaseBucket.URL, comic.Page, comic.Name)
+func (c *comicCrawler) getComicInfo(ctx context.Context, comicURL string, getPageSource func(pageURL string) (*goquery.Document, error)) (comic db.Comic, chapters []db.Chapter, err error) {
 
-	// Find latest chap
-	firstItem := doc.Find(".works-chapter-list").Find(".works-chapter-item.row").First()
-	if firstItem.Nodes == nil {
-		return util.ErrCrawlFailed
-	}
+	defer func() {
+		if r := recover(); r != nil {
+			switch x := r.(type) {
+			case string:
+				err = errors.New(x)
+			case error:
+				err = x
+			default:
+				err = errors.New("Unknown panic")
+			}
+		}
 
-	comic.LatestChap = firstItem.Find("a[href]").Text()
-	comic.ChapUrl, _ = firstItem.Find("a[href]").Attr("href")
+		return
+	}()
 
-	lastUpdate := strings.TrimSpace(firstItem.Find(".text-right").Text())
-	if len(lastUpdate) == 0 {
-		return util.ErrCrawlFailed
+	parsedURL, err := url.Parse(comicURL)
+	if err != nil /*|| parsedURL.Host == "" */ {
+		return db.Comic{}, nil, util.ErrInvalidURL
 	}
 
-	comic.LastUpdate, err = time.Parse("02/01/2006", string(lastUpdate))
-	if err != nil {
-		logging.Danger(err)
-		return util.ErrCrawlFailed
+	if _, ok := c.crawlerMap[parsedURL.Hostname()]; !ok {
+		return db.Comic{}, nil, util.ErrPageNotSupported
 	}
 
-	if checkSpoiler {
-		err = helper.detectSpoiler(comic.Name, comic.ChapUrl, comic.LatestChap, ".story-see-content", "img")
-		if err != nil {
-			return
+	// Remove all params in comicURL --> avoid duplicate URL
+	parsedURL.RawQuery = ""
+	comicURL = parsedURL.String()
+
+	doc, err := getPageSource(comicURL)
+	if err != nil {
+		if err == util.ErrNotModified || err == util.ErrThrottled {
+			return db.Comic{}, nil, err
+		}
+		if strings.Contains(err.Error(), "Timeout") {
+			return db.Comic{}, nil, util.ErrCrawlTimeout
 		}
+		return db.Comic{}, nil, util.ErrCrawlFailed
 	}
 
-	return
-}
-
-func crawlHocvientruyentranh(ctx context.Context, doc *goquery.Document, comic *db.Comic, helper helper, checkSpoiler bool) (err error) {
-
-	comic.Name = doc.Find(".__info").Find("h3").Text()
-	comic.ImgUrl, _ = doc.Find(".__image").Find("img[src]").Attr("src")
-	comic.CloudImgUrl = fmt.Sprintf("%s/%s/%s", conf.Cfg.FirebaseBucket.URL, comic.Page, comic.Name)
-
-	// Find latest chap
-	firstItem := doc.Find("tbody").Find("tr").First()
-	if firstItem.Nodes == nil {
-		return util.ErrCrawlFailed
+	comic = db.Comic{
+		Page: parsedURL.Hostname(),
+		Url:  comicURL,
 	}
 
-	comic.LatestChap = firstItem.Find("a[href]").Text()
-	comic.ChapUrl, _ = firstItem.Find("a[href]").Attr("href")
-
-	if checkSpoiler {
-		err = helper.detectSpoiler(comic.Name, comic.ChapUrl, comic.LatestChap, ".manga-container", "img")
-		if err != nil {
-			return
-		}
+	chapters, err = c.crawlerMap[parsedURL.Hostname()](ctx, doc, &comic)
+	if err != nil {
+		return
 	}
 
+	err = verifyComic(&comic)
 	return
 }
 
@@ -282,51 +208,24 @@ func verifyComic(comic *db.Comic) (err error) {
 	err = nil
 	switch {
 	case comic.Name == "":
-		return errors.Errorf("Comic name is missing, url = %s", comic.Url)
+		return errors.Wrapf(util.ErrLayoutChanged, "Comic name is missing, url = %s", comic.Url)
 	case comic.ChapUrl == "":
-		return errors.Errorf("Comic chapURL is missing, url = %s", comic.Url)
+		return errors.Wrapf(util.ErrLayoutChanged, "Comic chapURL is missing, url = %s", comic.Url)
 	case comic.ImgUrl == "":
-		return errors.Errorf("Comic ImgUrl is missing, url = %s", comic.Url)
+		return errors.Wrapf(util.ErrLayoutChanged, "Comic ImgUrl is missing, url = %s", comic.Url)
 	case comic.CloudImgUrl == "":
-		return errors.Errorf("Comic cloudImgUrl is missing, url = %s", comic.Url)
+		return errors.Wrapf(util.ErrLayoutChanged, "Comic cloudImgUrl is missing, url = %s", comic.Url)
 	case comic.LatestChap == "":
-		return errors.Errorf("Comic latestchap is missing, url = %s", comic.Url)
+		return errors.Wrapf(util.ErrLayoutChanged, "Comic latestchap is missing, url = %s", comic.Url)
 	default:
 		err = nil
 	}
 
 	if comic.Page != "hocvientruyentranh.net" {
 		if comic.LastUpdate.IsZero() {
-			return errors.Errorf("Comic date is missing, url = %s", comic.Url)
+			return errors.Wrapf(util.ErrLayoutChanged, "Comic date is missing, url = %s", comic.Url)
 		}
 	}
 
 	return
 }
-
-// mangaK crawler: work on this one later
-// func crawlMangaK(ctx context.Context, doc *goquery.Document, comic *db.Comic, helper helper, checkSpoiler bool) (err error) {
-
-// 	comic.Name = doc.Find(".entry-title").Text()
-// 	comic.ImgUrl, _ = doc.Find(".info_image").Find("img[src]").Attr("src")
-// 	comic.CloudImgUrl = fmt.Sprintf("%s/%s/%s", conf.Cfg.FirebaseBucket.URL, comic.Page, comic.Name)
-
-// 	// Find latest chap
-// 	firstItem := doc.Find(".chapter-list").Find(".row:nth-child(1)")
-// 	if firstItem.Nodes == nil {
-// 		return util.ErrCrawlFailed
-// 	}
-
-// 	comic.LatestChap = firstItem.Find("span:nth-child(1)").Text()
-// 	comic.ChapUrl, _ = firstItem.Find("a[href]").Attr("href")
-
-// 	if comic.ChapUrl != "" {
-// 		err = helper.detectSpoiler(comic.Name, comic.ChapUrl,comic.LatestChap, ".vung_doc", "img")
-// 		if err != nil {
-// 			return
-// 		}
-// 	}
-
-// 	err = verifyComic(comic)
-// 	return
-// }
@@ -2,7 +2,10 @@ package crawler
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,29 +15,48 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/tinoquang/comic-notifier/pkg/conf"
 	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/util"
 )
 
 type comicData struct {
 	URL      string
 	testData string
+	chapters int
 }
 
 type mockHelper struct {
 	testData          string
-	detectSpoilerMock func() error
+	chapterStatsMock  func() (int, int, error)
 	getPageSourceMock func(testData string) (*goquery.Document, error)
 }
 
-func (m mockHelper) detectSpoiler(name, chapURL, chapterName, attr1, attr2 string) error {
+func (m mockHelper) chapterStats(ctx context.Context, chapURL string, check *spoilerCheck) (images, pageSize int, err error) {
 
-	return m.detectSpoilerMock()
+	return m.chapterStatsMock()
 }
 
-func (m mockHelper) getPageSource(pageURL string) (doc *goquery.Document, err error) {
+func (m mockHelper) getPageSource(ctx context.Context, pageURL string) (doc *goquery.Document, err error) {
 
 	return m.getPageSourceMock(m.testData)
 }
 
+func (m mockHelper) getPageSourceIfModified(ctx context.Context, pageURL string, cache db.PageCache) (doc *goquery.Document, newCache db.PageCache, err error) {
+
+	doc, err = m.getPageSourceMock(m.testData)
+	return doc, cache, err
+}
+
+// newTestCrawler create crawler of default sites using mocked helper
+func newTestCrawler(h helper) *comicCrawler {
+
+	sites, err := loadSites(conf.Cfg.Crawler.SiteFile)
+	if err != nil {
+		panic(err)
+	}
+
+	return newComicCrawler(sites, h)
+}
+
 func readTestFile(path string) (*goquery.Document, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -57,9 +79,9 @@ func TestInvalidURL(t *testing.T) {
 	}
 
 	conf.Init()
-	c := newComicCrawler(mockBeeng)
+	c := newTestCrawler(mockBeeng)
 
-	_, err := c.GetComicInfo(context.Background(), "https://beeng", false)
+	_, _, err := c.GetComicInfo(context.Background(), "https://beeng")
 	assert.EqualError(t, err, "Page is not supported yet")
 }
 
@@ -73,9 +95,9 @@ func TestGetPageSourceTimeout(t *testing.T) {
 	}
 
 	conf.Init()
-	c := newComicCrawler(mockBeeng)
+	c := newTestCrawler(mockBeeng)
 
-	_, err := c.GetComicInfo(context.Background(), "https://beeng.net", false)
+	_, _, err := c.GetComicInfo(context.Background(), "https://beeng.net")
 	assert.EqualError(t, err, "Time out when crawl comic")
 
 }
@@ -90,9 +112,9 @@ func TestGetPageSourceFailed(t *testing.T) {
 	}
 
 	conf.Init()
-	c := newComicCrawler(mockBeeng)
+	c := newTestCrawler(mockBeeng)
 
-	_, err := c.GetComicInfo(context.Background(), "https://beeng.net", false)
+	_, _, err := c.GetComicInfo(context.Background(), "https://beeng.net")
 	assert.EqualError(t, err, "Crawl failed")
 
 }
@@ -105,22 +127,27 @@ func TestCrawlComic(t *testing.T) {
 		{
 			URL:      "https://beeng.net/dao-hai-tac-31953.html",
 			testData: "./test_data/beeng_daohaitac.html",
+			chapters: 6,
 		},
 		{
 			URL:      "https://blogtruyen.vn/139/one-piece",
 			testData: "./test_data/blogtruyen_onepiece.html",
+			chapters: 4,
 		},
 		{
 			URL:      "http://truyentranhtuan.com/one-piece/",
 			testData: "./test_data/truyentranhtuan_onepiece.html",
+			chapters: 6,
 		},
 		{
 			URL:      "http://truyenqq.com/truyen-tranh/dao-hai-tac-128",
 			testData: "./test_data/truyenqq_daohaitac.html",
+			chapters: 6,
 		},
 		{
 			URL:      "https://hocvientruyentranh.net/truyen/67/one-piece",
 			testData: "./test_data/hocvientruyentranh_onepiece.html",
+			chapters: 4,
 		},
 	}
 
@@ -178,62 +205,46 @@ func TestCrawlComic(t *testing.T) {
 	}
 	for i, comic := range comicTests {
 		h := mockHelper{
-			testData: comic.testData,
-			detectSpoilerMock: func() error {
-				return nil
-			},
+			testData:          comic.testData,
 			getPageSourceMock: readTestFile,
 		}
 
-		crawler := newComicCrawler(h)
+		crawler := newTestCrawler(h)
 
-		c, err := crawler.GetComicInfo(context.Background(), comic.URL, false)
+		c, chapters, err := crawler.GetComicInfo(context.Background(), comic.URL)
 
 		require.Nil(t, err)
 		require.Equal(t, c, want[i])
+
+		// Chapters are ordered oldest first, last one is latest chapter
+		require.Len(t, chapters, comic.chapters)
+		latest := chapters[len(chapters)-1]
+		require.Equal(t, latest.Name, c.LatestChap)
+		require.Equal(t, latest.Url, c.ChapUrl)
+		require.Equal(t, latest.PublishedDate.Time, c.LastUpdate)
 	}
 
 }
 
-func TestDetectSpolierFailed(t *testing.T) {
+func TestGetChapterStats(t *testing.T) {
 
 	conf.Init()
 
-	comicTests := []comicData{
-		{
-			URL:      "https://beeng.net/dao-hai-tac-31953.html",
-			testData: "./test_data/beeng_daohaitac.html",
-		},
-		{
-			URL:      "https://blogtruyen.vn/139/one-piece",
-			testData: "./test_data/blogtruyen_onepiece.html",
-		},
-		{
-			URL:      "http://truyenqq.com/truyen-tranh/dao-hai-tac-128",
-			testData: "./test_data/truyenqq_daohaitac.html",
-		},
-		{
-			URL:      "https://hocvientruyentranh.net/truyen/67/one-piece",
-			testData: "./test_data/hocvientruyentranh_onepiece.html",
+	h := mockHelper{
+		chapterStatsMock: func() (int, int, error) {
+			return 20, 4096, nil
 		},
 	}
+	c := newTestCrawler(h)
 
-	for _, comic := range comicTests {
-		mockBeeng := mockHelper{
-			testData: comic.testData,
-			detectSpoilerMock: func() error {
-				return errors.Errorf("Check spoiler failed")
-			},
-			getPageSourceMock: readTestFile,
-		}
-
-		c := newComicCrawler(mockBeeng)
-
-		_, err := c.GetComicInfo(context.Background(), comic.URL, true)
-
-		require.EqualError(t, err, "Check spoiler failed")
-	}
+	images, pageSize, err := c.GetChapterStats(context.Background(), "https://beeng.net/dao-hai-tac-31953/chapter-1008-959587.html")
+	require.Nil(t, err)
+	require.Equal(t, 20, images)
+	require.Equal(t, 4096, pageSize)
 
+	// Site without spoiler check
+	_, _, err = c.GetChapterStats(context.Background(), "https://test.vn/chapter-1008")
+	require.Equal(t, util.ErrSpoilerCheckNotSupported, err)
 }
 
 func TestVerifycomic(t *testing.T) {
@@ -241,6 +252,7 @@ func TestVerifycomic(t *testing.T) {
 	comic := db.Comic{}
 
 	require.Contains(t, verifyComic(&comic).Error(), "Comic name is missing")
+	require.Equal(t, util.ErrLayoutChanged, errors.Cause(verifyComic(&comic)))
 
 	comic.Name = "name"
 	require.Contains(t, verifyComic(&comic).Error(), "Comic chapURL is missing")
@@ -266,3 +278,85 @@ func TestVerifycomic(t *testing.T) {
 	require.Nil(t, verifyComic(&comic))
 
 }
+
+func TestCrawlChapterList(t *testing.T) {
+
+	conf.Init()
+
+	h := mockHelper{
+		testData:          "./test_data/truyentranhtuan_onepiece.html",
+		getPageSourceMock: readTestFile,
+	}
+
+	_, chapters, err := newTestCrawler(h).GetComicInfo(context.Background(), "http://truyentranhtuan.com/one-piece/")
+	require.Nil(t, err)
+
+	require.Equal(t, db.Chapter{
+		Name:          "One Piece 1003",
+		Url:           "http://truyentranhtuan.com/one-piece-chuong-1003/",
+		PublishedDate: sql.NullTime{Time: time.Date(2021, 2, 8, 0, 0, 0, 0, time.UTC), Valid: true},
+	}, chapters[0])
+
+	h.testData = "./test_data/blogtruyen_onepiece.html"
+	_, chapters, err = newTestCrawler(h).GetComicInfo(context.Background(), "https://blogtruyen.vn/139/one-piece")
+	require.Nil(t, err)
+
+	require.Equal(t, db.Chapter{
+		Name:          "One Piece Chapter 1005",
+		Url:           "https://blogtruyen.vn/c554440/one-piece-chapter-1005",
+		PublishedDate: sql.NullTime{Time: time.Date(2021, 2, 27, 0, 0, 0, 0, time.UTC), Valid: true},
+	}, chapters[0])
+}
+
+// searchHelper serve search pages from memory by URL, unknown URL fails
+type searchHelper struct {
+	mockHelper
+	pages map[string]string
+}
+
+func (h searchHelper) getPageSource(ctx context.Context, pageURL string) (*goquery.Document, error) {
+
+	page, ok := h.pages[pageURL]
+	if !ok {
+		return nil, errors.Errorf("Failed")
+	}
+
+	return goquery.NewDocumentFromReader(strings.NewReader(page))
+}
+
+func TestSearchComic(t *testing.T) {
+
+	conf.Init()
+
+	row := `<li><div class="book_avatar"><img src="https://test.vn/%[1]d.jpg"></div><div class="book_name"><a href="http://truyenqq.com/truyen-tranh/one-piece-%[1]d">One Piece %[1]d</a></div></li>`
+	truyenqq := `<ul class="list_grid">`
+	for i := 1; i <= 12; i++ {
+		truyenqq += fmt.Sprintf(row, i)
+	}
+	truyenqq += `</ul>`
+
+	h := searchHelper{pages: map[string]string{
+		"http://truyenqq.com/tim-kiem.html?q=one+piece": truyenqq,
+		"https://hocvientruyentranh.net/searchs?q=one+piece": `<table class="table"><tbody>
+			<tr><td><a href="https://hocvientruyentranh.net/truyen/67/one-piece">One Piece</a></td></tr>
+		</tbody></table>`,
+	}}
+
+	// Other sites fail, their results are skipped
+	comics, err := newTestCrawler(h).SearchComic(context.Background(), "one piece")
+	require.Nil(t, err)
+	require.Len(t, comics, maxSearchResults)
+
+	// Sites are interleaved, so a site with fewer results is still shown
+	require.Equal(t, db.Comic{
+		Page:   "truyenqq.com",
+		Name:   "One Piece 1",
+		Url:    "http://truyenqq.com/truyen-tranh/one-piece-1",
+		ImgUrl: "https://test.vn/1.jpg",
+	}, comics[0])
+	require.Equal(t, "https://hocvientruyentranh.net/truyen/67/one-piece", comics[1].Url)
+	require.Equal(t, "One Piece 2", comics[2].Name)
+
+	_, err = newTestCrawler(searchHelper{}).SearchComic(context.Background(), "one piece")
+	require.Equal(t, util.ErrCrawlFailed, err)
+}
@@ -18,13 +18,25 @@ type crawler struct {
 // NewCrawler constructor
 func NewCrawler() *crawler {
 
+	sites, err := loadSites(conf.Cfg.Crawler.SiteFile)
+	if err != nil {
+		panic(err)
+	}
+
+	client := newPoliteClient(rateLimit{
+		RPS:         conf.Cfg.Crawler.RateLimit,
+		Burst:       conf.Cfg.Crawler.Burst,
+		Concurrency: conf.Cfg.Crawler.Concurrency,
+	}, sites)
+
 	return &crawler{
-		newComicCrawler(crawlHelper{}),
+		newComicCrawler(sites, crawlHelper{client: client}),
 	}
 }
 
-// GetUserInfoFromFacebook call facebook API to get user info, include psid, appid and profile picture
-func (crwl *crawler) GetUserInfoFromFacebook(field, id string) (user db.User, err error) {
+// GetUserInfoFromFacebook call facebook API to get user info, include psid, appid and profile picture.
+// PSID is looked up with pageToken of the page user messaged, empty pageToken means default page
+func (crwl *crawler) GetUserInfoFromFacebook(field, id, pageToken string) (user db.User, err error) {
 
 	err = nil
 	user = db.User{}
@@ -40,7 +52,10 @@ func (crwl *crawler) GetUserInfoFromFacebook(field, id string) (user db.User, er
 	case "psid":
 		user.Psid.String = id
 		queries["fields"] = "name,picture.width(500).height(500),ids_for_apps"
-		queries["access_token"] = conf.Cfg.FBSecret.PakeToken
+		queries["access_token"] = pageToken
+		if pageToken == "" {
+			queries["access_token"] = conf.Cfg.FBSecret.PakeToken
+		}
 	case "appid":
 		user.Appid.String = id
 		queries["fields"] = "name,ids_for_pages,picture.width(500).height(500)"
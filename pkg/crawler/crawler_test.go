@@ -44,7 +44,7 @@ func TestGetUserInfoWithPSID(t *testing.T) {
 
 	crawler := NewCrawler()
 
-	u, err := crawler.GetUserInfoFromFacebook("psid", psid)
+	u, err := crawler.GetUserInfoFromFacebook("psid", psid, "")
 
 	require.Nil(t, err)
 
@@ -86,7 +86,7 @@ func TestGetUserInfoWithAppID(t *testing.T) {
 
 	crawler := NewCrawler()
 
-	u, err := crawler.GetUserInfoFromFacebook("appid", appID)
+	u, err := crawler.GetUserInfoFromFacebook("appid", appID, "")
 
 	require.Nil(t, err)
 
@@ -107,7 +107,7 @@ func TestSendRequestFailed(t *testing.T) {
 
 	crawler := NewCrawler()
 
-	_, err := crawler.GetUserInfoFromFacebook("appid", psid)
+	_, err := crawler.GetUserInfoFromFacebook("appid", psid, "")
 
 	require.Contains(t, err.Error(), "Send request failed")
 }
@@ -145,7 +145,7 @@ func TestParsingResponseFailed(t *testing.T) {
 
 	crawler := NewCrawler()
 
-	_, err := crawler.GetUserInfoFromFacebook("psid", psid)
+	_, err := crawler.GetUserInfoFromFacebook("psid", psid, "")
 
 	require.Contains(t, err.Error(), "invalid characte")
 }
@@ -154,7 +154,7 @@ func TestInvalidField(t *testing.T) {
 	conf.Init()
 	crawler := NewCrawler()
 	psid := "123"
-	_, err := crawler.GetUserInfoFromFacebook("wrong field", psid)
+	_, err := crawler.GetUserInfoFromFacebook("wrong field", psid, "")
 
 	require.EqualError(t, err, "Wrong field request, field: wrong field")
 }
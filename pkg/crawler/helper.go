@@ -2,38 +2,94 @@ package crawler
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/pkg/errors"
+
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
 	"github.com/tinoquang/comic-notifier/pkg/util"
 )
 
-type crawlHelper struct{}
+type crawlHelper struct {
+	client *politeClient
+}
 
-func (ch crawlHelper) detectSpoiler(name, chapURL, chapterName, attr1, attr2 string) error {
+// chapterStats return number of images and size of chapter page
+func (ch crawlHelper) chapterStats(ctx context.Context, chapURL string, check *spoilerCheck) (images, pageSize int, err error) {
 
-	if strings.Contains(chapterName, "leak") || strings.Contains(chapterName, "spoil") {
-		return errors.Errorf("%s has spoiler chapter", name)
+	pageBody, err := ch.client.get(ctx, chapURL)
+	if err != nil {
+		return
 	}
 
-	// Check if chapter is full upload (detect spolier chap)
-	doc, err := ch.getPageSource(chapURL)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(pageBody))
 	if err != nil {
-		return err
+		return
 	}
 
-	if chapSelections := doc.Find(attr1).Find(attr2); chapSelections.Size() < 3 {
-		return errors.Errorf("%s has spoiler chapter", name)
+	images, err = countImages(doc, check)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "url = %s", chapURL)
+	}
 
+	return images, len(pageBody), nil
+}
+
+// countImages return number of images in chapter page. Page loaded by JS has no image tag,
+// its images are read from the image list variable used by reader script instead. util.ErrLayoutChanged
+// is returned when the variable is missing or can't be parsed, so chapter isn't mistaken for one without images
+func countImages(doc *goquery.Document, check *spoilerCheck) (int, error) {
+
+	if check.Script == "" {
+		return doc.Find(check.Container).Find(check.Image).Size(), nil
 	}
 
-	return nil
+	var images []string
+	err := errors.Wrapf(util.ErrLayoutChanged, "Image list %s is missing", check.Script)
+	doc.Find("script").EachWithBreak(func(i int, script *goquery.Selection) bool {
+		match := check.imageList.FindStringSubmatch(script.Text())
+		if match == nil {
+			return true
+		}
+
+		err = nil
+		if jsonErr := json.Unmarshal([]byte(match[1]), &images); jsonErr != nil {
+			err = errors.Wrapf(util.ErrLayoutChanged, "Image list %s can't be parsed, %v", check.Script, jsonErr)
+		}
+		return false
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, img := range images {
+		if strings.TrimSpace(img) != "" {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// getPageSourceIfModified fetch page conditionally using cache of previous crawl, see politeClient.getIfModified
+func (ch crawlHelper) getPageSourceIfModified(ctx context.Context, pageURL string, cache db.PageCache) (doc *goquery.Document, newCache db.PageCache, err error) {
+
+	pageBody, newCache, err := ch.client.getIfModified(ctx, pageURL, cache)
+	if err != nil {
+		return
+	}
+
+	doc, err = goquery.NewDocumentFromReader(bytes.NewReader(pageBody))
+	return
 }
 
-func (ch crawlHelper) getPageSource(pageURL string) (doc *goquery.Document, err error) {
+func (ch crawlHelper) getPageSource(ctx context.Context, pageURL string) (doc *goquery.Document, err error) {
 
-	pageBody, err := util.MakeGetRequest(pageURL, nil)
+	pageBody, err := ch.client.get(ctx, pageURL)
 	if err != nil {
 		return
 	}
@@ -1,12 +1,17 @@
 package crawler
 
 import (
-	"errors"
+	"context"
+	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	"github.com/tinoquang/comic-notifier/pkg/util"
 )
 
 func TestGetPageSourceSuccess(t *testing.T) {
@@ -35,9 +40,9 @@ func TestGetPageSourceSuccess(t *testing.T) {
 		},
 	)
 
-	h := crawlHelper{}
+	h := crawlHelper{client: newPoliteClient(rateLimit{}, nil)}
 
-	_, err := h.getPageSource(reqURL)
+	_, err := h.getPageSource(context.Background(), reqURL)
 	require.Nil(t, err)
 }
 
@@ -49,9 +54,9 @@ func TestGetRequestFailed(t *testing.T) {
 	reqURL := "test.vn"
 	httpmock.RegisterResponder("GET", reqURL, httpmock.NewErrorResponder(errors.New("Make get request failed")))
 
-	h := crawlHelper{}
+	h := crawlHelper{client: newPoliteClient(rateLimit{}, nil)}
 
-	_, err := h.getPageSource(reqURL)
+	_, err := h.getPageSource(context.Background(), reqURL)
 	require.Contains(t, err.Error(), "Make get request failed")
 }
 
@@ -88,13 +93,15 @@ func TestNoSpoiler(t *testing.T) {
 		},
 	)
 
-	h := crawlHelper{}
+	h := crawlHelper{client: newPoliteClient(rateLimit{}, nil)}
 
-	err := h.detectSpoiler("name", "test.vn", "Black Clover Chap 286", ".story-see-content", "img")
+	images, pageSize, err := h.chapterStats(context.Background(), "test.vn", &spoilerCheck{Container: ".story-see-content", Image: "img"})
 	require.Nil(t, err)
+	require.Equal(t, 6, images)
+	require.NotZero(t, pageSize)
 }
 
-func TestGetRequestFailedWhenDetectSpolier(t *testing.T) {
+func TestGetRequestFailedWhenGetChapterStats(t *testing.T) {
 
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -102,13 +109,13 @@ func TestGetRequestFailedWhenDetectSpolier(t *testing.T) {
 	reqURL := "test.vn"
 	httpmock.RegisterResponder("GET", reqURL, httpmock.NewErrorResponder(errors.New("Make get request failed")))
 
-	h := crawlHelper{}
+	h := crawlHelper{client: newPoliteClient(rateLimit{}, nil)}
 
-	err := h.detectSpoiler("name", "test.vn", "Black Clover", ".story-see-content", "img")
+	_, _, err := h.chapterStats(context.Background(), "test.vn", &spoilerCheck{Container: ".story-see-content", Image: "img"})
 	require.Contains(t, err.Error(), "Make get request failed")
 }
 
-func TestChapterNameContainLeakOrSpoil(t *testing.T) {
+func TestPartialChapter(t *testing.T) {
 
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -137,43 +144,69 @@ func TestChapterNameContainLeakOrSpoil(t *testing.T) {
 		},
 	)
 
-	h := crawlHelper{}
+	h := crawlHelper{client: newPoliteClient(rateLimit{}, nil)}
 
-	err := h.detectSpoiler("name", "test.vn", "Black Clover leak", ".story-see-content", "img")
-	require.NotNil(t, err)
+	images, _, err := h.chapterStats(context.Background(), "test.vn", &spoilerCheck{Container: ".story-see-content", Image: "img"})
+	require.Nil(t, err)
+	require.Equal(t, 2, images)
 }
 
-func TestHasSpoiler(t *testing.T) {
+func TestChapterStatsFromImageListScript(t *testing.T) {
 
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 
-	reqURL := "test.vn"
-	httpmock.RegisterResponder("GET", reqURL,
-		func(req *http.Request) (*http.Response, error) {
-			resp := httpmock.NewStringResponse(200, `
-			<!DOCTYPE html>
-			<html lang="en">
-			  <head>
-				<meta charset="UTF-8" />
-				<meta http-equiv="X-UA-Compatible" content="IE=edge" />
-				<meta name="viewport" content="width=device-width, initial-scale=1.0" />
-				<title>Document</title>
-			  </head>
-			  <body>
-			  <div class="story-see-content">
-			  <img class="lazy" src="http://tintruyen.net/499/fix-286/0.jpg?d=dfgd6546" alt="Black Clover Chap 286 - Next Chap 287"><br>
-<img class="lazy" src="http://tintruyen.net/499/fix-286/1.jpg?d=dfgd6546" alt="Black Clover Chap 286 - Next Chap 287"><br>
-<br>                </div>
-			  </body>
-			</html>`,
-			)
-			return resp, nil
-		},
-	)
+	fixtures := map[string]string{
+		"http://truyentranhtuan.com/one-piece-chuong-1008/": "./test_data/truyentranhtuan_chapter.html",
+		"http://truyentranhtuan.com/one-piece-chuong-1009/": "./test_data/truyentranhtuan_chapter_spoiler.html",
+	}
+	for chapURL, fixture := range fixtures {
+		body, err := ioutil.ReadFile(fixture)
+		require.Nil(t, err)
+		httpmock.RegisterResponder("GET", chapURL, httpmock.NewBytesResponder(200, body))
+	}
+
+	h := crawlHelper{client: newPoliteClient(rateLimit{}, nil)}
+	check := &spoilerCheck{Script: "slides_page_path"}
+	require.Nil(t, check.compile())
+
+	images, _, err := h.chapterStats(context.Background(), "http://truyentranhtuan.com/one-piece-chuong-1008/", check)
+	require.Nil(t, err)
+	require.Equal(t, 17, images)
+
+	// Only 2 images are uploaded
+	images, _, err = h.chapterStats(context.Background(), "http://truyentranhtuan.com/one-piece-chuong-1009/", check)
+	require.Nil(t, err)
+	require.Equal(t, 2, images)
+}
 
-	h := crawlHelper{}
+func TestCountImagesFromScript(t *testing.T) {
 
-	err := h.detectSpoiler("name", "test.vn", "Black Clover", ".story-see-content", "img")
-	require.Contains(t, err.Error(), "has spoiler chapter")
+	doc, err := readTestFile("./test_data/truyentranhtuan_chapter.html")
+	require.Nil(t, err)
+
+	count := func(script string) (int, error) {
+		check := &spoilerCheck{Script: script}
+		require.Nil(t, check.compile())
+		return countImages(doc, check)
+	}
+
+	images, err := count("slides_page_path")
+	require.Nil(t, err)
+	require.Equal(t, 17, images)
+
+	// Variable with similar name is not mistaken
+	images, err = count("slides_page_url_path")
+	require.Nil(t, err)
+	require.Equal(t, 0, images)
+
+	// Missing image list means layout is changed
+	_, err = count("page_path")
+	require.Equal(t, util.ErrLayoutChanged, errors.Cause(err))
+
+	// Image list which isn't strict JSON isn't counted as no image
+	doc, err = goquery.NewDocumentFromReader(strings.NewReader(`<script>var slides_page_path = ['a.jpg', 'b.jpg',];</script>`))
+	require.Nil(t, err)
+	_, err = count("slides_page_path")
+	require.Equal(t, util.ErrLayoutChanged, errors.Cause(err))
 }
@@ -0,0 +1,293 @@
+package crawler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+const (
+	maxSlowDownRetries = 2                // retry times after host responds 429 or 503
+	minSlowDown        = 2 * time.Second  // first backoff when host doesn't send Retry-After, doubled each time
+	maxSlowDown        = 10 * time.Minute // longest pause applied to a host
+)
+
+// rateLimit politeness config of a host, zero value means unlimited
+type rateLimit struct {
+	RPS         float64 `yaml:"rps"`         // requests per second
+	Burst       int     `yaml:"burst"`       // requests allowed at once before rps applies
+	Concurrency int     `yaml:"concurrency"` // max parallel requests
+}
+
+// hostLimiter throttle requests to one host using a token bucket and concurrency cap,
+// host is paused entirely after it asks to slow down
+type hostLimiter struct {
+	bucket *rate.Limiter
+	slots  chan struct{}
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+	slowDown    time.Duration
+}
+
+func newHostLimiter(cfg rateLimit) *hostLimiter {
+
+	l := &hostLimiter{bucket: rate.NewLimiter(rate.Inf, 0)}
+	if cfg.RPS > 0 {
+		burst := cfg.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		l.bucket = rate.NewLimiter(rate.Limit(cfg.RPS), burst)
+	}
+
+	if cfg.Concurrency > 0 {
+		l.slots = make(chan struct{}, cfg.Concurrency)
+	}
+
+	return l
+}
+
+// acquire block until request is allowed, release must be called after request is done. util.ErrThrottled is returned
+// when request can't be allowed before ctx is done, it's our own throttling so caller shouldn't count it as host failure
+func (l *hostLimiter) acquire(ctx context.Context) error {
+
+	l.mu.Lock()
+	pause := time.Until(l.pausedUntil)
+	l.mu.Unlock()
+
+	if pause > 0 {
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(pause).After(deadline) {
+			return util.ErrThrottled
+		}
+
+		select {
+		case <-ctx.Done():
+			return util.ErrThrottled
+		case <-time.After(pause):
+		}
+	}
+
+	// Wait fails at once when bucket can't give a token before ctx deadline
+	if err := l.bucket.Wait(ctx); err != nil {
+		return util.ErrThrottled
+	}
+
+	if l.slots != nil {
+		select {
+		case <-ctx.Done():
+			return util.ErrThrottled
+		case l.slots <- struct{}{}:
+		}
+	}
+
+	return nil
+}
+
+func (l *hostLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// pause stop requests to host for retryAfter, or an exponential backoff when host doesn't tell how long to wait
+func (l *hostLimiter) pause(retryAfter time.Duration) time.Duration {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if retryAfter <= 0 {
+		l.slowDown *= 2
+		if l.slowDown < minSlowDown {
+			l.slowDown = minSlowDown
+		}
+		retryAfter = l.slowDown
+	}
+
+	if retryAfter > maxSlowDown {
+		retryAfter = maxSlowDown
+	}
+
+	if until := time.Now().Add(retryAfter); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+
+	return retryAfter
+}
+
+// recover reset backoff after host responds normally
+func (l *hostLimiter) recover() {
+
+	l.mu.Lock()
+	l.slowDown = 0
+	l.mu.Unlock()
+}
+
+// politeClient send GET requests, throttled per host
+type politeClient struct {
+	client   *http.Client
+	defaults rateLimit
+	limits   map[string]rateLimit // per host config from site file
+
+	mu       sync.Mutex
+	limiters map[string]*hostLimiter
+}
+
+func newPoliteClient(defaults rateLimit, sites []site) *politeClient {
+
+	limits := map[string]rateLimit{}
+	for _, s := range sites {
+		if s.RateLimit != nil {
+			limits[s.Host] = *s.RateLimit
+		}
+	}
+
+	return &politeClient{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		defaults: defaults,
+		limits:   limits,
+		limiters: map[string]*hostLimiter{},
+	}
+}
+
+func (p *politeClient) limiter(host string) *hostLimiter {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l, ok := p.limiters[host]
+	if !ok {
+		cfg, ok := p.limits[host]
+		if !ok {
+			cfg = p.defaults
+		}
+		l = newHostLimiter(cfg)
+		p.limiters[host] = l
+	}
+
+	return l
+}
+
+// get fetch page body
+func (p *politeClient) get(ctx context.Context, pageURL string) (body []byte, err error) {
+
+	_, body, err = p.do(ctx, pageURL, nil)
+	return
+}
+
+// getIfModified fetch page only when it's changed since cache was saved, util.ErrNotModified is returned when host
+// responds 304 or page body is the same. Returned cache holds validators of fetched page
+func (p *politeClient) getIfModified(ctx context.Context, pageURL string, cache db.PageCache) (body []byte, newCache db.PageCache, err error) {
+
+	header := http.Header{}
+	if cache.Etag != "" {
+		header.Set("If-None-Match", cache.Etag)
+	}
+	if cache.LastModified != "" {
+		header.Set("If-Modified-Since", cache.LastModified)
+	}
+
+	resp, body, err := p.do(ctx, pageURL, header)
+	if err != nil {
+		return nil, cache, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, cache, util.ErrNotModified
+	}
+
+	sum := sha256.Sum256(body)
+	newCache = db.PageCache{
+		ComicID:      cache.ComicID,
+		Etag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		BodyHash:     hex.EncodeToString(sum[:]),
+	}
+
+	if newCache.BodyHash == cache.BodyHash {
+		return nil, newCache, util.ErrNotModified
+	}
+
+	return body, newCache, nil
+}
+
+// do send GET request with header and return response with status 200 or 304, request is retried after waiting
+// when host responds 429 or 503
+func (p *politeClient) do(ctx context.Context, pageURL string, header http.Header) (resp *http.Response, body []byte, err error) {
+
+	reqURL, err := url.Parse(pageURL)
+	if err != nil {
+		return
+	}
+	l := p.limiter(reqURL.Hostname())
+
+	for retry := 0; ; retry++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		for key := range header {
+			req.Header.Set(key, header.Get(key))
+		}
+
+		if err = l.acquire(ctx); err != nil {
+			return nil, nil, err
+		}
+
+		resp, err = p.client.Do(req)
+		if err != nil {
+			l.release()
+			return nil, nil, err
+		}
+
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		l.release()
+
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+			wait := l.pause(parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()))
+			logging.Ctx(ctx).With(logging.FieldSite, reqURL.Hostname()).Info("Host responds", resp.Status, ", pause for", wait)
+			if retry >= maxSlowDownRetries {
+				return nil, nil, errors.New(resp.Status)
+			}
+		case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified:
+			logging.Ctx(ctx).With(logging.FieldSite, reqURL.Hostname()).Danger(string(body))
+			return nil, nil, errors.New(resp.Status)
+		default:
+			l.recover()
+			return resp, body, err
+		}
+	}
+}
+
+// parseRetryAfter read Retry-After header, which is either seconds or HTTP date
+func parseRetryAfter(header string, now time.Time) time.Duration {
+
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(header); err == nil {
+		return t.Sub(now)
+	}
+
+	return 0
+}
@@ -0,0 +1,161 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/require"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	require.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	require.Equal(t, 30*time.Second, parseRetryAfter("Wed, 10 Mar 2021 12:00:30 GMT", now))
+	require.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	require.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
+
+func TestSiteRateLimitOverride(t *testing.T) {
+
+	p := newPoliteClient(rateLimit{RPS: 1, Concurrency: 2}, []site{
+		{Host: "test.vn", RateLimit: &rateLimit{Concurrency: 1}},
+		{Host: "other.vn"},
+	})
+
+	require.Equal(t, 1, cap(p.limiter("test.vn").slots))
+	require.Equal(t, 2, cap(p.limiter("other.vn").slots))
+	require.Equal(t, p.limiter("test.vn"), p.limiter("test.vn"))
+}
+
+func TestConcurrencyCap(t *testing.T) {
+
+	l := newHostLimiter(rateLimit{Concurrency: 1})
+	require.Nil(t, l.acquire(context.Background()))
+
+	// Second request waits until first one is released
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.Equal(t, util.ErrThrottled, l.acquire(ctx))
+
+	l.release()
+	require.Nil(t, l.acquire(context.Background()))
+}
+
+func TestPauseBackoff(t *testing.T) {
+
+	l := newHostLimiter(rateLimit{})
+
+	require.Equal(t, minSlowDown, l.pause(0))
+	require.Equal(t, 2*minSlowDown, l.pause(0))
+	require.Equal(t, maxSlowDown, l.pause(time.Hour))
+
+	l.recover()
+	require.Equal(t, minSlowDown, l.pause(0))
+
+	// Paused host is not waited beyond request's deadline
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.Equal(t, util.ErrThrottled, l.acquire(ctx))
+}
+
+func TestRetryAfterTooManyRequests(t *testing.T) {
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	calls := 0
+	httpmock.RegisterResponder("GET", "https://test.vn/comic",
+		func(req *http.Request) (*http.Response, error) {
+			calls++
+			if calls == 1 {
+				resp := httpmock.NewStringResponse(http.StatusTooManyRequests, "")
+				resp.Header.Set("Retry-After", "1")
+				return resp, nil
+			}
+			return httpmock.NewStringResponse(http.StatusOK, "comic"), nil
+		},
+	)
+
+	p := newPoliteClient(rateLimit{}, nil)
+
+	start := time.Now()
+	body, err := p.get(context.Background(), "https://test.vn/comic")
+	require.Nil(t, err)
+	require.Equal(t, "comic", string(body))
+	require.Equal(t, 2, calls)
+	require.True(t, time.Since(start) >= time.Second)
+}
+
+func TestServiceUnavailableGiveUp(t *testing.T) {
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://test.vn/comic", httpmock.NewStringResponder(http.StatusServiceUnavailable, ""))
+
+	p := newPoliteClient(rateLimit{}, nil)
+
+	// Host doesn't send Retry-After, backoff is longer than request's deadline
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := p.get(ctx, "https://test.vn/comic")
+	require.NotNil(t, err)
+	require.Equal(t, 1, httpmock.GetTotalCallCount())
+}
+
+func TestGetIfModified(t *testing.T) {
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://test.vn/comic",
+		func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("If-None-Match") == `"v1"` {
+				return httpmock.NewStringResponse(http.StatusNotModified, ""), nil
+			}
+
+			resp := httpmock.NewStringResponse(http.StatusOK, "comic")
+			resp.Header.Set("ETag", `"v1"`)
+			resp.Header.Set("Last-Modified", "Wed, 10 Mar 2021 12:00:00 GMT")
+			return resp, nil
+		},
+	)
+
+	p := newPoliteClient(rateLimit{}, nil)
+
+	body, cache, err := p.getIfModified(context.Background(), "https://test.vn/comic", db.PageCache{ComicID: 1})
+	require.Nil(t, err)
+	require.Equal(t, "comic", string(body))
+	require.Equal(t, int32(1), cache.ComicID)
+	require.Equal(t, `"v1"`, cache.Etag)
+	require.Equal(t, "Wed, 10 Mar 2021 12:00:00 GMT", cache.LastModified)
+	require.NotEmpty(t, cache.BodyHash)
+
+	_, _, err = p.getIfModified(context.Background(), "https://test.vn/comic", cache)
+	require.Equal(t, util.ErrNotModified, err)
+}
+
+func TestGetIfModifiedSameBody(t *testing.T) {
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	// Host doesn't support conditional request
+	httpmock.RegisterResponder("GET", "https://test.vn/comic", httpmock.NewStringResponder(http.StatusOK, "comic"))
+
+	p := newPoliteClient(rateLimit{}, nil)
+
+	_, cache, err := p.getIfModified(context.Background(), "https://test.vn/comic", db.PageCache{})
+	require.Nil(t, err)
+
+	_, _, err = p.getIfModified(context.Background(), "https://test.vn/comic", cache)
+	require.Equal(t, util.ErrNotModified, err)
+	require.Equal(t, 2, httpmock.GetTotalCallCount())
+}
@@ -0,0 +1,295 @@
+package crawler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/tinoquang/comic-notifier/pkg/conf"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+// field locate a single value in page source
+type field struct {
+	Selector   string `yaml:"selector"`
+	Attr       string `yaml:"attr"`        // read this attribute instead of text
+	Prefix     string `yaml:"prefix"`      // prepend to value, used for relative URL
+	TrimPrefix string `yaml:"trim_prefix"` // remove from start of value
+	Sibling    bool   `yaml:"sibling"`     // search in following siblings instead of children
+}
+
+// dateField is a field which is parsed into time using one of the layouts
+type dateField struct {
+	field   `yaml:",inline"`
+	Layouts []string `yaml:"layouts"`
+}
+
+// chapterList describe each row in comic's chapter list, newest chapter first
+type chapterList struct {
+	Selector string     `yaml:"selector"`
+	Title    field      `yaml:"title"`
+	URL      field      `yaml:"url"`
+	Date     *dateField `yaml:"date"`
+}
+
+// spoilerCheck locate chapter's images for chapterStats, either by selectors or by the JS variable
+// holding image list when chapter page is loaded by JS
+type spoilerCheck struct {
+	Container string `yaml:"container"`
+	Image     string `yaml:"image"`
+	Script    string `yaml:"script"`
+
+	imageList *regexp.Regexp // match assignment of Script variable, compiled when site is validated
+}
+
+// scriptVariable is a JS variable name allowed as spoiler script
+var scriptVariable = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+// compile build the pattern matching image list variable, so countImages doesn't compile it on every chapter
+func (c *spoilerCheck) compile() (err error) {
+
+	if c.Script == "" {
+		return nil
+	}
+
+	if !scriptVariable.MatchString(c.Script) {
+		return errors.Errorf("%s is not a variable name", c.Script)
+	}
+
+	c.imageList, err = regexp.Compile(`\b` + regexp.QuoteMeta(c.Script) + `\s*=\s*(\[[^\]]*\])`)
+	return errors.WithStack(err)
+}
+
+// searchPage describe site's search result page, each result links to a comic page
+type searchPage struct {
+	URL     string `yaml:"url"`     // search page URL, {query} is replaced by escaped keyword
+	Results string `yaml:"results"` // each result row
+	Name    field  `yaml:"name"`
+	Link    field  `yaml:"link"`
+	Cover   field  `yaml:"cover"`
+}
+
+// site definition of a supported comic page
+type site struct {
+	Host      string        `yaml:"host"`
+	Name      field         `yaml:"name"`
+	Cover     field         `yaml:"cover"`
+	Chapters  chapterList   `yaml:"chapters"`
+	Spoiler   *spoilerCheck `yaml:"spoiler"`
+	Search    *searchPage   `yaml:"search"`
+	RateLimit *rateLimit    `yaml:"rate_limit"` // override default rate limit of crawler
+}
+
+// loadSites read site definitions from file
+func loadSites(path string) ([]site, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSites(data)
+}
+
+func parseSites(data []byte) ([]site, error) {
+
+	sites := []site{}
+	if err := yaml.UnmarshalStrict(data, &sites); err != nil {
+		return nil, err
+	}
+
+	hosts := map[string]bool{}
+	for _, s := range sites {
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+
+		if hosts[s.Host] {
+			return nil, errors.Errorf("Site %s is defined more than once", s.Host)
+		}
+		hosts[s.Host] = true
+	}
+
+	return sites, nil
+}
+
+func (s *site) validate() error {
+
+	switch {
+	case s.Host == "":
+		return errors.New("Site host is missing")
+	case s.Name.Selector == "":
+		return errors.Errorf("Site %s: name selector is missing", s.Host)
+	case s.Cover.Selector == "":
+		return errors.Errorf("Site %s: cover selector is missing", s.Host)
+	case s.Chapters.Selector == "":
+		return errors.Errorf("Site %s: chapter list selector is missing", s.Host)
+	case s.Chapters.URL.Selector == "" && s.Chapters.URL.Attr == "":
+		return errors.Errorf("Site %s: chapter url is missing", s.Host)
+	case s.Chapters.Date != nil && len(s.Chapters.Date.Layouts) == 0:
+		return errors.Errorf("Site %s: chapter date layout is missing", s.Host)
+	case s.Spoiler != nil && s.Spoiler.Script == "" && (s.Spoiler.Container == "" || s.Spoiler.Image == ""):
+		return errors.Errorf("Site %s: spoiler selectors are missing", s.Host)
+	case s.Search != nil && !strings.Contains(s.Search.URL, "{query}"):
+		return errors.Errorf("Site %s: search url must contain {query}", s.Host)
+	case s.Search != nil && (s.Search.Results == "" || s.Search.Name.Selector == "" && s.Search.Name.Attr == "" || s.Search.Link.Attr == ""):
+		return errors.Errorf("Site %s: search selectors are missing", s.Host)
+	case s.RateLimit != nil && (s.RateLimit.RPS < 0 || s.RateLimit.Burst < 0 || s.RateLimit.Concurrency < 0):
+		return errors.Errorf("Site %s: rate limit must not be negative", s.Host)
+	}
+
+	if s.Spoiler != nil {
+		if err := s.Spoiler.compile(); err != nil {
+			return errors.Errorf("Site %s: spoiler script is invalid, %v", s.Host, err)
+		}
+	}
+
+	return nil
+}
+
+// crawl fill comic info and return its chapter list using site definition
+func (s *site) crawl(ctx context.Context, doc *goquery.Document, comic *db.Comic) (chapters []db.Chapter, err error) {
+
+	comic.Name = s.Name.value(doc.Selection)
+	comic.ImgUrl = s.Cover.value(doc.Selection)
+	comic.CloudImgUrl = fmt.Sprintf("%s/%s/%s", conf.Cfg.FirebaseBucket.URL, comic.Page, comic.Name)
+
+	rows := doc.Find(s.Chapters.Selector)
+	if rows.Nodes == nil {
+		return nil, util.ErrLayoutChanged
+	}
+
+	// Chapter list is shown newest first, the first row is latest chapter
+	firstItem := rows.First()
+	comic.LatestChap = s.Chapters.Title.value(firstItem)
+	comic.ChapUrl = s.Chapters.URL.value(firstItem)
+
+	if s.Chapters.Date != nil {
+		comic.LastUpdate, err = s.Chapters.Date.time(firstItem)
+		if err != nil {
+			logging.Ctx(ctx).Danger(err)
+			return nil, util.ErrLayoutChanged
+		}
+	}
+
+	return s.chapters(rows), nil
+}
+
+// chapters parse all chapter rows, returned chapters are ordered oldest first
+func (s *site) chapters(rows *goquery.Selection) []db.Chapter {
+
+	chapters := []db.Chapter{}
+	for i := rows.Length() - 1; i >= 0; i-- {
+		row := rows.Eq(i)
+
+		chap := db.Chapter{
+			Name: s.Chapters.Title.value(row),
+			Url:  s.Chapters.URL.value(row),
+		}
+		if chap.Url == "" {
+			continue
+		}
+
+		// Older chapters may have unusual date format, keep them without date instead of failing whole comic
+		if s.Chapters.Date != nil {
+			if t, err := s.Chapters.Date.time(row); err == nil {
+				chap.PublishedDate = sql.NullTime{Time: t, Valid: true}
+			}
+		}
+
+		chapters = append(chapters, chap)
+	}
+
+	return chapters
+}
+
+// searchURL return URL of site's search page for keyword
+func (s *site) searchURL(keyword string) string {
+	return strings.Replace(s.Search.URL, "{query}", url.QueryEscape(keyword), 1)
+}
+
+// searchResults parse site's search page, result without name or link is skipped
+func (s *site) searchResults(doc *goquery.Document) []db.Comic {
+
+	comics := []db.Comic{}
+	doc.Find(s.Search.Results).Each(func(_ int, row *goquery.Selection) {
+		comic := db.Comic{
+			Page: s.Host,
+			Name: s.Search.Name.value(row),
+			Url:  s.Search.Link.value(row),
+		}
+
+		// Cover is optional, some sites don't show it in search results
+		if s.Search.Cover.Selector != "" {
+			comic.ImgUrl = s.Search.Cover.value(row)
+		}
+
+		if comic.Name != "" && comic.Url != "" {
+			comics = append(comics, comic)
+		}
+	})
+
+	return comics
+}
+
+func (f *field) find(root *goquery.Selection) *goquery.Selection {
+
+	if f.Selector == "" {
+		return root
+	}
+
+	if f.Sibling {
+		return root.NextAllFiltered(f.Selector).First()
+	}
+
+	return root.Find(f.Selector).First()
+}
+
+func (f *field) value(root *goquery.Selection) (val string) {
+
+	sel := f.find(root)
+	if f.Attr != "" {
+		val, _ = sel.Attr(f.Attr)
+	} else {
+		val = sel.Text()
+	}
+
+	val = strings.TrimSpace(val)
+	if f.TrimPrefix != "" {
+		val = strings.TrimSpace(strings.TrimPrefix(val, f.TrimPrefix))
+	}
+
+	if val != "" {
+		val = f.Prefix + val
+	}
+
+	return
+}
+
+func (d *dateField) time(root *goquery.Selection) (t time.Time, err error) {
+
+	words := strings.Fields(d.value(root))
+	if len(words) == 0 {
+		return t, errors.New("Chapter date is missing")
+	}
+
+	for _, layout := range d.Layouts {
+		t, err = time.Parse(layout, words[0])
+		if err == nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,159 @@
+package crawler
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/require"
+	"github.com/tinoquang/comic-notifier/pkg/conf"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+)
+
+func TestLoadDefaultSites(t *testing.T) {
+
+	conf.Init()
+
+	sites, err := loadSites(conf.Cfg.Crawler.SiteFile)
+	require.Nil(t, err)
+	require.Len(t, sites, 5)
+}
+
+func TestParseSitesMissingSelector(t *testing.T) {
+
+	_, err := parseSites([]byte(`
+- host: test.vn
+  cover:
+    selector: .cover img
+    attr: src
+  chapters:
+    selector: li
+    url:
+      selector: a
+      attr: href
+`))
+	require.EqualError(t, err, "Site test.vn: name selector is missing")
+}
+
+func TestParseSitesDuplicateHost(t *testing.T) {
+
+	def := `
+- host: test.vn
+  name:
+    selector: h1
+  cover:
+    selector: .cover img
+    attr: src
+  chapters:
+    selector: li
+    url:
+      selector: a
+      attr: href
+`
+	_, err := parseSites([]byte(def + def))
+	require.EqualError(t, err, "Site test.vn is defined more than once")
+}
+
+func TestParseSitesUnknownKey(t *testing.T) {
+
+	_, err := parseSites([]byte(`
+- host: test.vn
+  title:
+    selector: h1
+`))
+	require.NotNil(t, err)
+}
+
+func TestParseSitesSearchWithoutQuery(t *testing.T) {
+
+	_, err := parseSites([]byte(`
+- host: test.vn
+  name:
+    selector: h1
+  cover:
+    selector: .cover img
+    attr: src
+  chapters:
+    selector: li
+    url:
+      selector: a
+      attr: href
+  search:
+    url: https://test.vn/search
+    results: .result
+    name:
+      selector: a
+    link:
+      selector: a
+      attr: href
+`))
+	require.EqualError(t, err, "Site test.vn: search url must contain {query}")
+}
+
+func TestParseSitesInvalidScript(t *testing.T) {
+
+	_, err := parseSites([]byte(`
+- host: test.vn
+  name:
+    selector: h1
+  cover:
+    selector: .cover img
+    attr: src
+  chapters:
+    selector: li
+    url:
+      selector: a
+      attr: href
+  spoiler:
+    script: "pages["
+`))
+	require.EqualError(t, err, "Site test.vn: spoiler script is invalid, pages[ is not a variable name")
+}
+
+func TestChapterWithBadDate(t *testing.T) {
+
+	s := site{Host: "test.vn", Chapters: chapterList{
+		Selector: "li",
+		Title:    field{Selector: "a"},
+		URL:      field{Selector: "a", Attr: "href"},
+		Date:     &dateField{field: field{Selector: "span"}, Layouts: []string{"02/01/2006"}},
+	}}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`
+	<li><a href="/chap-2">Chapter 2</a><span>27/02/2021</span></li>
+	<li><a href="/chap-1">Chapter 1</a><span>Yesterday</span></li>`))
+	require.Nil(t, err)
+
+	// Chapter whose date can't be parsed has no date instead of zero date
+	chapters := s.chapters(doc.Find(s.Chapters.Selector))
+	require.Equal(t, []db.Chapter{
+		{Name: "Chapter 1", Url: "/chap-1"},
+		{Name: "Chapter 2", Url: "/chap-2", PublishedDate: sql.NullTime{Time: time.Date(2021, 2, 27, 0, 0, 0, 0, time.UTC), Valid: true}},
+	}, chapters)
+}
+
+func TestSearchResults(t *testing.T) {
+
+	s := site{Host: "test.vn", Search: &searchPage{
+		URL:     "https://test.vn/search?q={query}",
+		Results: ".result",
+		Name:    field{Selector: "a"},
+		Link:    field{Selector: "a[href]", Attr: "href", Prefix: "https://test.vn"},
+		Cover:   field{Selector: "img", Attr: "src"},
+	}}
+
+	require.Equal(t, "https://test.vn/search?q=%C4%90%E1%BA%A3o+H%E1%BA%A3i+T%E1%BA%B7c", s.searchURL("Đảo Hải Tặc"))
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`
+	<div class="result"><img src="https://test.vn/1.jpg"><a href="/one-piece">One Piece</a></div>
+	<div class="result"><a href="/one-piece-color">One Piece Color</a></div>
+	<div class="result"><span>Advertisement</span></div>`))
+	require.Nil(t, err)
+
+	require.Equal(t, []db.Comic{
+		{Page: "test.vn", Name: "One Piece", Url: "https://test.vn/one-piece", ImgUrl: "https://test.vn/1.jpg"},
+		{Page: "test.vn", Name: "One Piece Color", Url: "https://test.vn/one-piece-color"},
+	}, s.searchResults(doc))
+}
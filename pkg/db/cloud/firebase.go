@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"cloud.google.com/go/storage"
-	firebase "firebase.google.com/go/v4"
 	"github.com/tinoquang/comic-notifier/pkg/conf"
 	"github.com/tinoquang/comic-notifier/pkg/logging"
 	"github.com/tinoquang/comic-notifier/pkg/util"
@@ -20,34 +19,26 @@ import (
 
 // firebaseConnection contains bucket object to communicate with Firebase storage
 type firebaseConnection struct {
+	client *storage.Client
 	bucket *storage.BucketHandle
 }
 
 // NewFirebaseConnection create new bucket object to communicate with Firebase storage
 func NewFirebaseConnection() *firebaseConnection {
 
-	var bucket *storage.BucketHandle
-
-	config := &firebase.Config{
-		StorageBucket: conf.Cfg.FirebaseBucket.Name,
-	}
-
-	app, err := firebase.NewApp(context.Background(), config, conf.Cfg.FirebaseBucket.Option)
+	// Firebase storage is a Cloud Storage bucket, client is created directly so it can be closed on shutdown
+	client, err := storage.NewClient(context.Background(), conf.Cfg.FirebaseBucket.Option)
 	if err != nil {
 		panic(err)
 	}
 
-	client, err := app.Storage(context.Background())
-	if err != nil {
-		panic(err)
-	}
-
-	bucket, err = client.DefaultBucket()
-	if err != nil {
-		panic(err)
-	}
+	bucket := client.Bucket(conf.Cfg.FirebaseBucket.Name)
+	return &firebaseConnection{client: client, bucket: bucket}
+}
 
-	return &firebaseConnection{bucket: bucket}
+// Close release connection to Firebase storage, it can't be used after that
+func (f *firebaseConnection) Close() error {
+	return f.client.Close()
 }
 
 // GetImg verify comic image is exist in cloud
@@ -0,0 +1,36 @@
+-- name: CreateChapter :exec
+INSERT INTO chapters
+	(comic_id,
+	name,
+	url,
+	published_date,
+	image_count,
+	page_size,
+	status,
+	number)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+	ON CONFLICT (comic_id, url) DO NOTHING;
+
+-- name: ListChaptersPerComic :many
+SELECT * FROM chapters
+WHERE comic_id=$1
+ORDER BY id;
+
+-- name: GetChapter :one
+SELECT * FROM chapters
+WHERE id=$1;
+
+-- name: GetChapterByURL :one
+SELECT * FROM chapters
+WHERE comic_id=$1 AND url=$2;
+
+-- name: GetLatestChapter :one
+SELECT * FROM chapters
+WHERE comic_id=$1
+ORDER BY id DESC
+LIMIT 1;
+
+-- name: UpdateChapterInspection :exec
+UPDATE chapters
+SET image_count=$2, page_size=$3, status=$4
+WHERE id=$1;
@@ -37,17 +37,52 @@ WHERE id = $1 FOR NO KEY UPDATE;
 -- name: SearchComicOfUserByName :many
 SELECT comics.* FROM comics
 LEFT JOIN subscribers ON comics.id=subscribers.comic_id
-WHERE subscribers.user_id=$1
-AND (comics.name ILIKE $2 or unaccent(comics.name) ILIKE $2)
-ORDER BY subscribers.created_at DESC;
--- LIMIT $3
--- OFFSET $4;
+WHERE subscribers.user_id=sqlc.arg(user_id)
+AND (comics.name ILIKE sqlc.arg(name) or unaccent(comics.name) ILIKE sqlc.arg(name))
+AND (sqlc.arg(page)::text='' OR comics.page=sqlc.arg(page))
+ORDER BY
+	CASE WHEN sqlc.arg(sort_by)::text='name' AND NOT sqlc.arg(descending)::bool THEN comics.name END ASC,
+	CASE WHEN sqlc.arg(sort_by)::text='name' AND sqlc.arg(descending)::bool THEN comics.name END DESC,
+	CASE WHEN sqlc.arg(sort_by)::text='last_update' AND NOT sqlc.arg(descending)::bool THEN comics.last_update END ASC,
+	CASE WHEN sqlc.arg(sort_by)::text='last_update' AND sqlc.arg(descending)::bool THEN comics.last_update END DESC,
+	CASE WHEN NOT sqlc.arg(descending)::bool THEN subscribers.created_at END ASC,
+	subscribers.created_at DESC
+LIMIT sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
+
+-- name: CountComicsOfUserByName :one
+SELECT COUNT(*) FROM comics
+LEFT JOIN subscribers ON comics.id=subscribers.comic_id
+WHERE subscribers.user_id=sqlc.arg(user_id)
+AND (comics.name ILIKE sqlc.arg(name) or unaccent(comics.name) ILIKE sqlc.arg(name))
+AND (sqlc.arg(page)::text='' OR comics.page=sqlc.arg(page));
 
 -- name: ListComics :many
 SELECT * FROM comics
-ORDER BY id DESC;
--- LIMIT $1
--- OFFSET $2;
+WHERE (sqlc.arg(page)::text='' OR page=sqlc.arg(page))
+ORDER BY
+	CASE WHEN sqlc.arg(sort_by)::text='name' AND NOT sqlc.arg(descending)::bool THEN name END ASC,
+	CASE WHEN sqlc.arg(sort_by)::text='name' AND sqlc.arg(descending)::bool THEN name END DESC,
+	CASE WHEN sqlc.arg(sort_by)::text='last_update' AND NOT sqlc.arg(descending)::bool THEN last_update END ASC,
+	CASE WHEN sqlc.arg(sort_by)::text='last_update' AND sqlc.arg(descending)::bool THEN last_update END DESC,
+	id DESC
+LIMIT sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
+
+-- name: CountComics :one
+SELECT COUNT(*) FROM comics
+WHERE (sqlc.arg(page)::text='' OR page=sqlc.arg(page));
+
+-- name: ListComicsPerSeries :many
+SELECT * FROM comics
+WHERE series_id=$1
+ORDER BY crawl_failures, last_update DESC;
+
+-- name: ListDueComics :many
+SELECT * FROM comics
+WHERE next_check_at <= now() AND NOT disabled
+AND page NOT IN (SELECT site_health.page FROM site_health WHERE site_health.disabled)
+ORDER BY next_check_at;
 
 -- name: ListComicsPerUser :many
 SELECT comics.* FROM comics
@@ -62,6 +97,44 @@ SET latest_chap=$2, chap_url=$3, img_url=$4, cloud_img_url=$5, last_update=$6
 WHERE id=$1
 RETURNING *;
 
+-- name: UpdateComicNextCheck :exec
+UPDATE comics
+SET next_check_at=$2
+WHERE id=$1;
+
+-- name: UpdateComicDisabled :one
+UPDATE comics
+SET disabled=$2
+WHERE id=$1
+RETURNING *;
+
+-- name: UpdateComicLatestChapter :exec
+UPDATE comics
+SET latest_chap=chapters.name, chap_url=chapters.url
+FROM chapters
+WHERE comics.id=$1 AND chapters.id=(
+	SELECT released.id FROM chapters AS released
+	WHERE released.comic_id=$1 AND released.status='released'
+	ORDER BY released.id DESC
+	LIMIT 1
+);
+
+-- name: UpdateComicSeries :exec
+UPDATE comics
+SET series_id=$2
+WHERE id=$1;
+
+-- name: RecordComicCrawlFailure :one
+UPDATE comics
+SET crawl_failures=crawl_failures+1, last_crawl_error=$2
+WHERE id=$1
+RETURNING crawl_failures;
+
+-- name: RecordComicCrawlSuccess :exec
+UPDATE comics
+SET crawl_failures=0, last_crawl_error='', last_success_at=now()
+WHERE id=$1;
+
 -- name: DeleteComic :exec
 DELETE FROM comics
 WHERE id = $1;
@@ -0,0 +1,19 @@
+-- name: GetMessengerPage :one
+SELECT * FROM messenger_pages
+WHERE id=$1;
+
+-- name: ListMessengerPages :many
+SELECT * FROM messenger_pages
+ORDER BY id;
+
+-- name: UpsertMessengerPage :one
+INSERT INTO messenger_pages
+	(id,
+	name,
+	access_token,
+	greeting,
+	disabled)
+	VALUES ($1,$2,$3,$4,$5)
+	ON CONFLICT (id) DO UPDATE
+	SET name=EXCLUDED.name, access_token=EXCLUDED.access_token, greeting=EXCLUDED.greeting, disabled=EXCLUDED.disabled
+	RETURNING *;
@@ -0,0 +1,61 @@
+-- name: CreateNotifications :exec
+INSERT INTO notifications
+	(user_id,
+	comic_id,
+	chapter_id)
+	SELECT subscribers.user_id, chapters.comic_id, chapters.id FROM subscribers
+	JOIN chapters ON chapters.comic_id=subscribers.comic_id
+	JOIN comics ON comics.id=chapters.comic_id
+	WHERE chapters.comic_id=$1 AND chapters.url=$2
+	AND NOT EXISTS (
+		SELECT 1 FROM notifications AS sent
+		JOIN chapters AS sent_chapter ON sent_chapter.id=sent.chapter_id
+		JOIN comics AS sent_comic ON sent_comic.id=sent.comic_id
+		WHERE sent.user_id=subscribers.user_id AND sent_comic.series_id=comics.series_id
+		AND sent_chapter.number=chapters.number
+	)
+	ON CONFLICT (user_id, chapter_id) DO NOTHING;
+
+-- name: ListDueNotifications :many
+SELECT * FROM notifications
+WHERE status='pending' AND next_attempt_at <= now()
+AND NOT EXISTS (
+	SELECT 1 FROM notifications AS earlier
+	WHERE earlier.user_id=notifications.user_id AND earlier.comic_id=notifications.comic_id
+	AND earlier.status='pending' AND earlier.next_attempt_at > now() AND earlier.chapter_id < notifications.chapter_id
+)
+ORDER BY user_id, id
+LIMIT $1;
+
+-- name: UpdateNotificationStatus :exec
+UPDATE notifications
+SET status=$2, attempts=attempts+1, next_attempt_at=$3, last_error=$4
+WHERE id=$1;
+
+-- name: DeferNotification :exec
+UPDATE notifications
+SET next_attempt_at=$2
+WHERE id=$1;
+
+-- name: ResumeDeferredNotifications :exec
+UPDATE notifications
+SET next_attempt_at=now()
+WHERE user_id=$1 AND status='pending' AND attempts=0;
+
+-- name: ListNotificationsByStatus :many
+SELECT * FROM notifications
+WHERE status=$1
+ORDER BY id DESC
+LIMIT $2
+OFFSET $3;
+
+-- name: RetryNotification :one
+UPDATE notifications
+SET status='pending', attempts=0, next_attempt_at=now()
+WHERE id=$1 AND status='failed'
+RETURNING *;
+
+-- name: RetryFailedNotifications :execrows
+UPDATE notifications
+SET status='pending', attempts=0, next_attempt_at=now()
+WHERE status='failed';
@@ -0,0 +1,17 @@
+-- name: GetPageCache :one
+SELECT * FROM page_caches
+WHERE comic_id=$1;
+
+-- name: UpsertPageCache :exec
+INSERT INTO page_caches
+	(comic_id,
+	etag,
+	last_modified,
+	body_hash)
+	VALUES ($1,$2,$3,$4)
+	ON CONFLICT (comic_id) DO UPDATE
+	SET etag=EXCLUDED.etag, last_modified=EXCLUDED.last_modified, body_hash=EXCLUDED.body_hash, updated_at=now();
+
+-- name: DeletePageCache :exec
+DELETE FROM page_caches
+WHERE comic_id=$1;
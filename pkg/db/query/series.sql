@@ -0,0 +1,55 @@
+-- name: UpsertSeries :one
+INSERT INTO series
+	(name,
+	normalized_name)
+	VALUES ($1,$2)
+	ON CONFLICT (normalized_name) DO UPDATE SET normalized_name=EXCLUDED.normalized_name
+	RETURNING *;
+
+-- name: GetSeries :one
+SELECT * FROM series
+WHERE id = $1;
+
+-- name: CreateSeriesSubscriber :one
+INSERT INTO series_subscribers
+	(user_id,
+	series_id)
+	VALUES ($1,$2)
+	RETURNING *;
+
+-- name: CountSeriesSubscribers :one
+SELECT COUNT(*) FROM series_subscribers
+WHERE series_id=$1;
+
+-- name: DeleteSeriesSubscriber :exec
+DELETE FROM series_subscribers
+WHERE user_id=$1 AND series_id=$2;
+
+-- name: DeleteSeriesSubscribersPerUser :exec
+DELETE FROM series_subscribers
+WHERE user_id=$1;
+
+-- name: CreateSeriesNotifications :exec
+WITH recipients AS (
+	SELECT series_subscribers.user_id, series_subscribers.series_id FROM series_subscribers
+	UNION
+	SELECT subscribers.user_id, down.series_id FROM subscribers
+	JOIN comics AS down ON down.id=subscribers.comic_id
+	WHERE down.series_id IS NOT NULL AND down.crawl_failures >= $3
+)
+INSERT INTO notifications
+	(user_id,
+	comic_id,
+	chapter_id)
+	SELECT recipients.user_id, chapters.comic_id, chapters.id FROM recipients
+	JOIN comics ON comics.series_id=recipients.series_id
+	JOIN chapters ON chapters.comic_id=comics.id
+	WHERE chapters.comic_id=$1 AND chapters.url=$2
+	AND NOT EXISTS (
+		SELECT 1 FROM notifications AS sent
+		JOIN chapters AS sent_chapter ON sent_chapter.id=sent.chapter_id
+		JOIN comics AS sent_comic ON sent_comic.id=sent.comic_id
+		WHERE sent.user_id=recipients.user_id AND sent_comic.series_id=comics.series_id
+		AND sent_chapter.number=chapters.number
+	)
+	ON CONFLICT (user_id, chapter_id) DO NOTHING;
@@ -0,0 +1,33 @@
+-- name: GetSiteHealth :one
+SELECT * FROM site_health
+WHERE page=$1;
+
+-- name: ListSiteHealth :many
+SELECT * FROM site_health
+ORDER BY page;
+
+-- name: UpdateSiteDisabled :one
+INSERT INTO site_health
+	(page,
+	disabled)
+	VALUES ($1,$2)
+	ON CONFLICT (page) DO UPDATE
+	SET disabled=EXCLUDED.disabled, updated_at=now()
+	RETURNING *;
+
+-- name: UpsertSiteHealth :exec
+INSERT INTO site_health
+	(page,
+	crawls,
+	failures,
+	consecutive_failures,
+	last_success_at,
+	last_error,
+	last_error_at,
+	broken,
+	broken_since)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+	ON CONFLICT (page) DO UPDATE
+	SET crawls=EXCLUDED.crawls, failures=EXCLUDED.failures, consecutive_failures=EXCLUDED.consecutive_failures,
+	last_success_at=EXCLUDED.last_success_at, last_error=EXCLUDED.last_error, last_error_at=EXCLUDED.last_error_at,
+	broken=EXCLUDED.broken, broken_since=EXCLUDED.broken_since, updated_at=now();
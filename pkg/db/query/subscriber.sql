@@ -1,14 +1,69 @@
 -- name: CreateSubscriber :one
 INSERT INTO subscribers
 	(user_id,
-	comic_id) 
-	VALUES ($1,$2)
+	comic_id,
+	last_read_chapter_id) 
+	VALUES ($1,$2,(SELECT MAX(id) FROM chapters WHERE chapters.comic_id=$2))
 	RETURNING *;
 
 -- name: GetSubscriber :one
 SELECT * FROM subscribers
 WHERE user_id=$1 AND comic_id=$2;
 
+-- name: UpdateLastReadChapter :one
+UPDATE subscribers
+SET last_read_chapter_id=$3
+WHERE user_id=$1 AND comic_id=$2
+RETURNING *;
+
+-- name: InitLastReadChapters :exec
+UPDATE subscribers
+SET last_read_chapter_id=(SELECT chapters.id FROM chapters WHERE chapters.comic_id=$1 AND chapters.url=$2)
+WHERE subscribers.comic_id=$1 AND subscribers.last_read_chapter_id IS NULL;
+
+-- name: ListUnreadChaptersPerUser :many
+SELECT subscribers.comic_id, COUNT(chapters.id) AS unread FROM subscribers
+JOIN chapters ON chapters.comic_id=subscribers.comic_id
+WHERE subscribers.user_id=$1 AND chapters.id > subscribers.last_read_chapter_id AND chapters.status='released'
+GROUP BY subscribers.comic_id;
+
+-- name: MoveSubscribers :exec
+INSERT INTO subscribers
+	(user_id,
+	comic_id,
+	last_read_chapter_id,
+	created_at)
+	SELECT dup.user_id, sqlc.arg(target_id), COALESCE(
+		(SELECT target_chap.id FROM chapters AS target_chap
+		JOIN chapters AS read_chap ON read_chap.id=dup.last_read_chapter_id
+		WHERE target_chap.comic_id=sqlc.arg(target_id)
+		AND (target_chap.url=read_chap.url OR target_chap.number=read_chap.number)
+		ORDER BY target_chap.id DESC
+		LIMIT 1),
+		(SELECT MAX(id) FROM chapters WHERE chapters.comic_id=sqlc.arg(target_id))), dup.created_at
+	FROM subscribers AS dup
+	WHERE dup.comic_id=sqlc.arg(duplicate_id)
+	AND NOT EXISTS (
+		SELECT 1 FROM subscribers AS existing
+		WHERE existing.user_id=dup.user_id AND existing.comic_id=sqlc.arg(target_id)
+	);
+
+-- name: DeleteSubscribersPerComic :exec
+DELETE FROM subscribers
+WHERE comic_id=$1;
+
 -- name: DeleteSubscriber :exec
+WITH pending AS (
+	DELETE FROM notifications
+	WHERE notifications.user_id=$1 AND notifications.comic_id=$2 AND notifications.status='pending'
+)
 DELETE FROM subscribers
 WHERE user_id=$1 AND comic_id=$2;
+
+-- name: DeleteSubscribersPerUser :exec
+WITH pending AS (
+	DELETE FROM notifications
+	WHERE notifications.user_id=$1 AND notifications.status='pending'
+)
+DELETE FROM subscribers
+WHERE user_id=$1;
@@ -3,12 +3,17 @@ INSERT INTO users
 	(name,
 	psid,
 	appid,
-	profile_pic) 
-	VALUES ($1, $2, $3, $4)
+	profile_pic,
+	messenger_page_id) 
+	VALUES ($1, $2, $3, $4, $5)
 	ON CONFLICT (psid) DO NOTHING
 	RETURNING *;
 
 
+-- name: GetUser :one
+SELECT * FROM users
+WHERE id = $1;
+
 -- name: GetUserByPSID :one
 SELECT * FROM users
 WHERE psid = $1;
@@ -32,6 +37,18 @@ SET appid=$1
 WHERE psid=$2
 RETURNING *;
 
+-- name: UpdateUserNotifyChannel :one
+UPDATE users
+SET notify_channel=$2, notify_target=$3
+WHERE appid=$1
+RETURNING *;
+
+-- name: UpdateUserDigest :one
+UPDATE users
+SET digest_mode=$2, digest_hour=$3, digest_weekday=$4
+WHERE appid=$1
+RETURNING *;
+
 -- name: DeleteUser :exec
 DELETE FROM users
 WHERE psid = $1;
@@ -1,9 +1,23 @@
+drop table if exists site_health;
+drop table if exists page_caches;
+drop table if exists notifications;
+drop table if exists series_subscribers;
 drop table if exists subscribers;
+drop table if exists chapters;
 drop table if exists users;
+drop table if exists messenger_pages;
 drop table if exists comics;
+drop table if exists series;
 
 CREATE EXTENSION IF NOT EXISTS unaccent;
 
+create table series (
+    "id" serial UNIQUE not null,
+    "name" VARCHAR(256) not null,
+    "normalized_name" VARCHAR(256) not null unique,
+    "created_at" timestamptz NOT NULL DEFAULT (now()),
+    PRIMARY KEY (id)
+);
 create table comics (
     id serial UNIQUE not null,
     page VARCHAR(128) not null,
@@ -14,6 +28,12 @@ create table comics (
     "latest_chap" VARCHAR(256) not null,
     "chap_url" VARCHAR(256) not null,
     "last_update" DATE NOT NULL DEFAULT NOW(),
+    "next_check_at" timestamptz NOT NULL DEFAULT (now()),
+    "series_id" INT REFERENCES series(id) ON DELETE SET NULL,
+    "crawl_failures" INT NOT NULL DEFAULT 0,
+    "last_success_at" timestamptz,
+    "last_crawl_error" VARCHAR(32) NOT NULL DEFAULT '',
+    "disabled" BOOLEAN NOT NULL DEFAULT FALSE,
     PRIMARY KEY (id)
 );
 create table users (
@@ -22,11 +42,85 @@ create table users (
     "psid" VARCHAR(64) UNIQUE,
     "appid" VARCHAR(64) UNIQUE,
     "profile_pic" VARCHAR(256),
+    "notify_channel" VARCHAR(32) NOT NULL DEFAULT 'messenger',
+    "notify_target" VARCHAR(256),
+    "messenger_page_id" VARCHAR(64),
+    "digest_mode" VARCHAR(16) NOT NULL DEFAULT 'instant',
+    "digest_hour" INTEGER NOT NULL DEFAULT 20,
+    "digest_weekday" INTEGER NOT NULL DEFAULT 0,
     PRIMARY KEY (id)
 );
+create table chapters (
+    "id" serial UNIQUE not null,
+    "comic_id" INT REFERENCES comics(id) ON DELETE CASCADE not null,
+    "name" VARCHAR(256) not null,
+    "url" VARCHAR(256) not null,
+    "published_date" DATE,
+    "created_at" timestamptz NOT NULL DEFAULT (now()),
+    "image_count" INT NOT NULL DEFAULT 0,
+    "page_size" INT NOT NULL DEFAULT 0,
+    "status" VARCHAR(16) NOT NULL DEFAULT 'released',
+    "number" FLOAT8,
+    PRIMARY KEY (id),
+    UNIQUE (comic_id, url)
+);
 create table subscribers (
     "id" serial UNIQUE not null,
     "user_id" INT REFERENCES users(id) not null,
     "comic_id" INT REFERENCES comics(id) not null,
-    "created_at" timestamptz NOT NULL DEFAULT (now())
-);
\ No newline at end of file
+    "last_read_chapter_id" INT REFERENCES chapters(id) ON DELETE SET NULL,
+    "created_at" timestamptz NOT NULL DEFAULT (now()),
+    CONSTRAINT subscribers_user_id_comic_id_key UNIQUE (user_id, comic_id)
+);
+create table series_subscribers (
+    "id" serial UNIQUE not null,
+    "user_id" INT REFERENCES users(id) ON DELETE CASCADE not null,
+    "series_id" INT REFERENCES series(id) ON DELETE CASCADE not null,
+    "created_at" timestamptz NOT NULL DEFAULT (now()),
+    PRIMARY KEY (id),
+    CONSTRAINT series_subscribers_user_id_series_id_key UNIQUE (user_id, series_id)
+);
+create table notifications (
+    "id" serial UNIQUE not null,
+    "user_id" INT REFERENCES users(id) ON DELETE CASCADE not null,
+    "comic_id" INT REFERENCES comics(id) ON DELETE CASCADE not null,
+    "chapter_id" INT REFERENCES chapters(id) ON DELETE CASCADE not null,
+    "status" VARCHAR(16) NOT NULL DEFAULT 'pending',
+    "attempts" INT NOT NULL DEFAULT 0,
+    "next_attempt_at" timestamptz NOT NULL DEFAULT (now()),
+    "last_error" TEXT,
+    "created_at" timestamptz NOT NULL DEFAULT (now()),
+    PRIMARY KEY (id),
+    UNIQUE (user_id, chapter_id)
+);
+create table page_caches (
+    "comic_id" INT REFERENCES comics(id) ON DELETE CASCADE not null,
+    "etag" VARCHAR(256) NOT NULL DEFAULT '',
+    "last_modified" VARCHAR(64) NOT NULL DEFAULT '',
+    "body_hash" VARCHAR(64) NOT NULL DEFAULT '',
+    "updated_at" timestamptz NOT NULL DEFAULT (now()),
+    PRIMARY KEY (comic_id)
+);
+create table site_health (
+    "page" VARCHAR(128) not null,
+    "crawls" INT NOT NULL DEFAULT 0,
+    "failures" INT NOT NULL DEFAULT 0,
+    "consecutive_failures" INT NOT NULL DEFAULT 0,
+    "last_success_at" timestamptz,
+    "last_error" VARCHAR(32) NOT NULL DEFAULT '',
+    "last_error_at" timestamptz,
+    "broken" BOOLEAN NOT NULL DEFAULT FALSE,
+    "broken_since" timestamptz,
+    "disabled" BOOLEAN NOT NULL DEFAULT FALSE,
+    "updated_at" timestamptz NOT NULL DEFAULT (now()),
+    PRIMARY KEY (page)
+);
+create table messenger_pages (
+    "id" VARCHAR(64) not null,
+    "name" VARCHAR(128) NOT NULL DEFAULT '',
+    "access_token" VARCHAR(512) not null,
+    "greeting" TEXT NOT NULL DEFAULT '',
+    "disabled" BOOLEAN NOT NULL DEFAULT FALSE,
+    "created_at" timestamptz NOT NULL DEFAULT (now()),
+    PRIMARY KEY (id)
+);
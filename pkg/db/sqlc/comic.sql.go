@@ -9,6 +9,39 @@ import (
 	"time"
 )
 
+const countComics = `-- name: CountComics :one
+SELECT COUNT(*) FROM comics
+WHERE ($1::text='' OR page=$1)
+`
+
+func (q *Queries) CountComics(ctx context.Context, page string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countComics, page)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countComicsOfUserByName = `-- name: CountComicsOfUserByName :one
+SELECT COUNT(*) FROM comics
+LEFT JOIN subscribers ON comics.id=subscribers.comic_id
+WHERE subscribers.user_id=$1
+AND (comics.name ILIKE $2 or unaccent(comics.name) ILIKE $2)
+AND ($3::text='' OR comics.page=$3)
+`
+
+type CountComicsOfUserByNameParams struct {
+	UserID int32
+	Name   string
+	Page   string
+}
+
+func (q *Queries) CountComicsOfUserByName(ctx context.Context, arg CountComicsOfUserByNameParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countComicsOfUserByName, arg.UserID, arg.Name, arg.Page)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createComic = `-- name: CreateComic :one
 INSERT INTO comics
 	(page,
@@ -21,7 +54,7 @@ INSERT INTO comics
 	last_update)
 	VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
 	ON CONFLICT (url) DO NOTHING
-	RETURNING id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update
+	RETURNING id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update, next_check_at, series_id, crawl_failures, last_success_at, last_crawl_error, disabled
 `
 
 type CreateComicParams struct {
@@ -57,6 +90,12 @@ func (q *Queries) CreateComic(ctx context.Context, arg CreateComicParams) (Comic
 		&i.LatestChap,
 		&i.ChapUrl,
 		&i.LastUpdate,
+		&i.NextCheckAt,
+		&i.SeriesID,
+		&i.CrawlFailures,
+		&i.LastSuccessAt,
+		&i.LastCrawlError,
+		&i.Disabled,
 	)
 	return i, err
 }
@@ -72,7 +111,7 @@ func (q *Queries) DeleteComic(ctx context.Context, id int32) error {
 }
 
 const getComic = `-- name: GetComic :one
-SELECT id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update FROM comics
+SELECT id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update, next_check_at, series_id, crawl_failures, last_success_at, last_crawl_error, disabled FROM comics
 WHERE id = $1
 `
 
@@ -89,12 +128,18 @@ func (q *Queries) GetComic(ctx context.Context, id int32) (Comic, error) {
 		&i.LatestChap,
 		&i.ChapUrl,
 		&i.LastUpdate,
+		&i.NextCheckAt,
+		&i.SeriesID,
+		&i.CrawlFailures,
+		&i.LastSuccessAt,
+		&i.LastCrawlError,
+		&i.Disabled,
 	)
 	return i, err
 }
 
 const getComicByPSIDAndComicID = `-- name: GetComicByPSIDAndComicID :one
-SELECT comics.id, comics.page, comics.name, comics.url, comics.img_url, comics.cloud_img_url, comics.latest_chap, comics.chap_url, comics.last_update FROM comics
+SELECT comics.id, comics.page, comics.name, comics.url, comics.img_url, comics.cloud_img_url, comics.latest_chap, comics.chap_url, comics.last_update, comics.next_check_at, comics.series_id, comics.crawl_failures, comics.last_success_at, comics.last_crawl_error, comics.disabled FROM comics
 JOIN subscribers ON comics.id=subscribers.comic_id
 JOIN users ON users.id=subscribers.user_id
 WHERE users.psid=$1 AND comics.id=$2
@@ -118,12 +163,18 @@ func (q *Queries) GetComicByPSIDAndComicID(ctx context.Context, arg GetComicByPS
 		&i.LatestChap,
 		&i.ChapUrl,
 		&i.LastUpdate,
+		&i.NextCheckAt,
+		&i.SeriesID,
+		&i.CrawlFailures,
+		&i.LastSuccessAt,
+		&i.LastCrawlError,
+		&i.Disabled,
 	)
 	return i, err
 }
 
 const getComicByPageAndComicName = `-- name: GetComicByPageAndComicName :one
-SELECT id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update FROM comics
+SELECT id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update, next_check_at, series_id, crawl_failures, last_success_at, last_crawl_error, disabled FROM comics
 WHERE comics.page=$1 AND comics.name=$2
 `
 
@@ -145,12 +196,18 @@ func (q *Queries) GetComicByPageAndComicName(ctx context.Context, arg GetComicBy
 		&i.LatestChap,
 		&i.ChapUrl,
 		&i.LastUpdate,
+		&i.NextCheckAt,
+		&i.SeriesID,
+		&i.CrawlFailures,
+		&i.LastSuccessAt,
+		&i.LastCrawlError,
+		&i.Disabled,
 	)
 	return i, err
 }
 
 const getComicByURL = `-- name: GetComicByURL :one
-SELECT id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update FROM comics
+SELECT id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update, next_check_at, series_id, crawl_failures, last_success_at, last_crawl_error, disabled FROM comics
 WHERE url = $1
 `
 
@@ -167,12 +224,18 @@ func (q *Queries) GetComicByURL(ctx context.Context, url string) (Comic, error)
 		&i.LatestChap,
 		&i.ChapUrl,
 		&i.LastUpdate,
+		&i.NextCheckAt,
+		&i.SeriesID,
+		&i.CrawlFailures,
+		&i.LastSuccessAt,
+		&i.LastCrawlError,
+		&i.Disabled,
 	)
 	return i, err
 }
 
 const getComicForUpdate = `-- name: GetComicForUpdate :one
-SELECT id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update FROM comics
+SELECT id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update, next_check_at, series_id, crawl_failures, last_success_at, last_crawl_error, disabled FROM comics
 WHERE id = $1 FOR NO KEY UPDATE
 `
 
@@ -189,20 +252,90 @@ func (q *Queries) GetComicForUpdate(ctx context.Context, id int32) (Comic, error
 		&i.LatestChap,
 		&i.ChapUrl,
 		&i.LastUpdate,
+		&i.NextCheckAt,
+		&i.SeriesID,
+		&i.CrawlFailures,
+		&i.LastSuccessAt,
+		&i.LastCrawlError,
+		&i.Disabled,
 	)
 	return i, err
 }
 
 const listComics = `-- name: ListComics :many
+SELECT id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update, next_check_at, series_id, crawl_failures, last_success_at, last_crawl_error, disabled FROM comics
+WHERE ($1::text='' OR page=$1)
+ORDER BY
+	CASE WHEN $2::text='name' AND NOT $3::bool THEN name END ASC,
+	CASE WHEN $2::text='name' AND $3::bool THEN name END DESC,
+	CASE WHEN $2::text='last_update' AND NOT $3::bool THEN last_update END ASC,
+	CASE WHEN $2::text='last_update' AND $3::bool THEN last_update END DESC,
+	id DESC
+LIMIT $4
+OFFSET $5
+`
+
+type ListComicsParams struct {
+	Page       string
+	SortBy     string
+	Descending bool
+	Limit      int32
+	Offset     int32
+}
 
-SELECT id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update FROM comics
-ORDER BY id DESC
+func (q *Queries) ListComics(ctx context.Context, arg ListComicsParams) ([]Comic, error) {
+	rows, err := q.db.QueryContext(ctx, listComics,
+		arg.Page,
+		arg.SortBy,
+		arg.Descending,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Comic{}
+	for rows.Next() {
+		var i Comic
+		if err := rows.Scan(
+			&i.ID,
+			&i.Page,
+			&i.Name,
+			&i.Url,
+			&i.ImgUrl,
+			&i.CloudImgUrl,
+			&i.LatestChap,
+			&i.ChapUrl,
+			&i.LastUpdate,
+			&i.NextCheckAt,
+			&i.SeriesID,
+			&i.CrawlFailures,
+			&i.LastSuccessAt,
+			&i.LastCrawlError,
+			&i.Disabled,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listComicsPerSeries = `-- name: ListComicsPerSeries :many
+SELECT id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update, next_check_at, series_id, crawl_failures, last_success_at, last_crawl_error, disabled FROM comics
+WHERE series_id=$1
+ORDER BY crawl_failures, last_update DESC
 `
 
-// LIMIT $3
-// OFFSET $4;
-func (q *Queries) ListComics(ctx context.Context) ([]Comic, error) {
-	rows, err := q.db.QueryContext(ctx, listComics)
+func (q *Queries) ListComicsPerSeries(ctx context.Context, seriesID sql.NullInt32) ([]Comic, error) {
+	rows, err := q.db.QueryContext(ctx, listComicsPerSeries, seriesID)
 	if err != nil {
 		return nil, err
 	}
@@ -220,6 +353,12 @@ func (q *Queries) ListComics(ctx context.Context) ([]Comic, error) {
 			&i.LatestChap,
 			&i.ChapUrl,
 			&i.LastUpdate,
+			&i.NextCheckAt,
+			&i.SeriesID,
+			&i.CrawlFailures,
+			&i.LastSuccessAt,
+			&i.LastCrawlError,
+			&i.Disabled,
 		); err != nil {
 			return nil, err
 		}
@@ -235,14 +374,11 @@ func (q *Queries) ListComics(ctx context.Context) ([]Comic, error) {
 }
 
 const listComicsPerUser = `-- name: ListComicsPerUser :many
-
-SELECT comics.id, comics.page, comics.name, comics.url, comics.img_url, comics.cloud_img_url, comics.latest_chap, comics.chap_url, comics.last_update FROM comics
+SELECT comics.id, comics.page, comics.name, comics.url, comics.img_url, comics.cloud_img_url, comics.latest_chap, comics.chap_url, comics.last_update, comics.next_check_at, comics.series_id, comics.crawl_failures, comics.last_success_at, comics.last_crawl_error, comics.disabled FROM comics
 LEFT JOIN subscribers ON comics.id=subscribers.comic_id 
 WHERE subscribers.user_id=$1 ORDER BY subscribers.created_at DESC
 `
 
-// LIMIT $1
-// OFFSET $2;
 func (q *Queries) ListComicsPerUser(ctx context.Context, userID int32) ([]Comic, error) {
 	rows, err := q.db.QueryContext(ctx, listComicsPerUser, userID)
 	if err != nil {
@@ -262,6 +398,58 @@ func (q *Queries) ListComicsPerUser(ctx context.Context, userID int32) ([]Comic,
 			&i.LatestChap,
 			&i.ChapUrl,
 			&i.LastUpdate,
+			&i.NextCheckAt,
+			&i.SeriesID,
+			&i.CrawlFailures,
+			&i.LastSuccessAt,
+			&i.LastCrawlError,
+			&i.Disabled,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDueComics = `-- name: ListDueComics :many
+SELECT id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update, next_check_at, series_id, crawl_failures, last_success_at, last_crawl_error, disabled FROM comics
+WHERE next_check_at <= now() AND NOT disabled
+AND page NOT IN (SELECT site_health.page FROM site_health WHERE site_health.disabled)
+ORDER BY next_check_at
+`
+
+func (q *Queries) ListDueComics(ctx context.Context) ([]Comic, error) {
+	rows, err := q.db.QueryContext(ctx, listDueComics)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Comic{}
+	for rows.Next() {
+		var i Comic
+		if err := rows.Scan(
+			&i.ID,
+			&i.Page,
+			&i.Name,
+			&i.Url,
+			&i.ImgUrl,
+			&i.CloudImgUrl,
+			&i.LatestChap,
+			&i.ChapUrl,
+			&i.LastUpdate,
+			&i.NextCheckAt,
+			&i.SeriesID,
+			&i.CrawlFailures,
+			&i.LastSuccessAt,
+			&i.LastCrawlError,
+			&i.Disabled,
 		); err != nil {
 			return nil, err
 		}
@@ -276,21 +464,73 @@ func (q *Queries) ListComicsPerUser(ctx context.Context, userID int32) ([]Comic,
 	return items, nil
 }
 
+const recordComicCrawlFailure = `-- name: RecordComicCrawlFailure :one
+UPDATE comics
+SET crawl_failures=crawl_failures+1, last_crawl_error=$2
+WHERE id=$1
+RETURNING crawl_failures
+`
+
+type RecordComicCrawlFailureParams struct {
+	ID             int32
+	LastCrawlError string
+}
+
+func (q *Queries) RecordComicCrawlFailure(ctx context.Context, arg RecordComicCrawlFailureParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, recordComicCrawlFailure, arg.ID, arg.LastCrawlError)
+	var crawl_failures int32
+	err := row.Scan(&crawl_failures)
+	return crawl_failures, err
+}
+
+const recordComicCrawlSuccess = `-- name: RecordComicCrawlSuccess :exec
+UPDATE comics
+SET crawl_failures=0, last_crawl_error='', last_success_at=now()
+WHERE id=$1
+`
+
+func (q *Queries) RecordComicCrawlSuccess(ctx context.Context, id int32) error {
+	_, err := q.db.ExecContext(ctx, recordComicCrawlSuccess, id)
+	return err
+}
+
 const searchComicOfUserByName = `-- name: SearchComicOfUserByName :many
-SELECT comics.id, comics.page, comics.name, comics.url, comics.img_url, comics.cloud_img_url, comics.latest_chap, comics.chap_url, comics.last_update FROM comics
+SELECT comics.id, comics.page, comics.name, comics.url, comics.img_url, comics.cloud_img_url, comics.latest_chap, comics.chap_url, comics.last_update, comics.next_check_at, comics.series_id, comics.crawl_failures, comics.last_success_at, comics.last_crawl_error, comics.disabled FROM comics
 LEFT JOIN subscribers ON comics.id=subscribers.comic_id
 WHERE subscribers.user_id=$1
 AND (comics.name ILIKE $2 or unaccent(comics.name) ILIKE $2)
-ORDER BY subscribers.created_at DESC
+AND ($3::text='' OR comics.page=$3)
+ORDER BY
+	CASE WHEN $4::text='name' AND NOT $5::bool THEN comics.name END ASC,
+	CASE WHEN $4::text='name' AND $5::bool THEN comics.name END DESC,
+	CASE WHEN $4::text='last_update' AND NOT $5::bool THEN comics.last_update END ASC,
+	CASE WHEN $4::text='last_update' AND $5::bool THEN comics.last_update END DESC,
+	CASE WHEN NOT $5::bool THEN subscribers.created_at END ASC,
+	subscribers.created_at DESC
+LIMIT $6
+OFFSET $7
 `
 
 type SearchComicOfUserByNameParams struct {
-	UserID int32
-	Name   string
+	UserID     int32
+	Name       string
+	Page       string
+	SortBy     string
+	Descending bool
+	Limit      int32
+	Offset     int32
 }
 
 func (q *Queries) SearchComicOfUserByName(ctx context.Context, arg SearchComicOfUserByNameParams) ([]Comic, error) {
-	rows, err := q.db.QueryContext(ctx, searchComicOfUserByName, arg.UserID, arg.Name)
+	rows, err := q.db.QueryContext(ctx, searchComicOfUserByName,
+		arg.UserID,
+		arg.Name,
+		arg.Page,
+		arg.SortBy,
+		arg.Descending,
+		arg.Limit,
+		arg.Offset,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -308,6 +548,12 @@ func (q *Queries) SearchComicOfUserByName(ctx context.Context, arg SearchComicOf
 			&i.LatestChap,
 			&i.ChapUrl,
 			&i.LastUpdate,
+			&i.NextCheckAt,
+			&i.SeriesID,
+			&i.CrawlFailures,
+			&i.LastSuccessAt,
+			&i.LastCrawlError,
+			&i.Disabled,
 		); err != nil {
 			return nil, err
 		}
@@ -327,7 +573,7 @@ const updateComic = `-- name: UpdateComic :one
 UPDATE comics 
 SET latest_chap=$2, chap_url=$3, img_url=$4, cloud_img_url=$5, last_update=$6
 WHERE id=$1
-RETURNING id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update
+RETURNING id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update, next_check_at, series_id, crawl_failures, last_success_at, last_crawl_error, disabled
 `
 
 type UpdateComicParams struct {
@@ -361,6 +607,96 @@ func (q *Queries) UpdateComic(ctx context.Context, arg UpdateComicParams) (Comic
 		&i.LatestChap,
 		&i.ChapUrl,
 		&i.LastUpdate,
+		&i.NextCheckAt,
+		&i.SeriesID,
+		&i.CrawlFailures,
+		&i.LastSuccessAt,
+		&i.LastCrawlError,
+		&i.Disabled,
 	)
 	return i, err
 }
+
+const updateComicDisabled = `-- name: UpdateComicDisabled :one
+UPDATE comics
+SET disabled=$2
+WHERE id=$1
+RETURNING id, page, name, url, img_url, cloud_img_url, latest_chap, chap_url, last_update, next_check_at, series_id, crawl_failures, last_success_at, last_crawl_error, disabled
+`
+
+type UpdateComicDisabledParams struct {
+	ID       int32
+	Disabled bool
+}
+
+func (q *Queries) UpdateComicDisabled(ctx context.Context, arg UpdateComicDisabledParams) (Comic, error) {
+	row := q.db.QueryRowContext(ctx, updateComicDisabled, arg.ID, arg.Disabled)
+	var i Comic
+	err := row.Scan(
+		&i.ID,
+		&i.Page,
+		&i.Name,
+		&i.Url,
+		&i.ImgUrl,
+		&i.CloudImgUrl,
+		&i.LatestChap,
+		&i.ChapUrl,
+		&i.LastUpdate,
+		&i.NextCheckAt,
+		&i.SeriesID,
+		&i.CrawlFailures,
+		&i.LastSuccessAt,
+		&i.LastCrawlError,
+		&i.Disabled,
+	)
+	return i, err
+}
+
+const updateComicLatestChapter = `-- name: UpdateComicLatestChapter :exec
+UPDATE comics
+SET latest_chap=chapters.name, chap_url=chapters.url
+FROM chapters
+WHERE comics.id=$1 AND chapters.id=(
+	SELECT released.id FROM chapters AS released
+	WHERE released.comic_id=$1 AND released.status='released'
+	ORDER BY released.id DESC
+	LIMIT 1
+)
+`
+
+func (q *Queries) UpdateComicLatestChapter(ctx context.Context, id int32) error {
+	_, err := q.db.ExecContext(ctx, updateComicLatestChapter, id)
+	return err
+}
+
+const updateComicNextCheck = `-- name: UpdateComicNextCheck :exec
+UPDATE comics
+SET next_check_at=$2
+WHERE id=$1
+`
+
+type UpdateComicNextCheckParams struct {
+	ID          int32
+	NextCheckAt time.Time
+}
+
+func (q *Queries) UpdateComicNextCheck(ctx context.Context, arg UpdateComicNextCheckParams) error {
+	_, err := q.db.ExecContext(ctx, updateComicNextCheck, arg.ID, arg.NextCheckAt)
+	return err
+}
+
+const updateComicSeries = `-- name: UpdateComicSeries :exec
+UPDATE comics
+SET series_id=$2
+WHERE id=$1
+`
+
+type UpdateComicSeriesParams struct {
+	ID       int32
+	SeriesID sql.NullInt32
+}
+
+func (q *Queries) UpdateComicSeries(ctx context.Context, arg UpdateComicSeriesParams) error {
+	_, err := q.db.ExecContext(ctx, updateComicSeries, arg.ID, arg.SeriesID)
+	return err
+}
@@ -2,11 +2,21 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 
-	_ "github.com/lib/pq" // don't use but still import for database/sql to init sql driver
+	"github.com/lib/pq" // also registers sql driver for database/sql
 	"github.com/tinoquang/comic-notifier/pkg/conf"
 )
 
+// uniqueViolation is Postgres error code of a duplicate key
+const uniqueViolation = "23505"
+
+// Unique constraints in schema.sql which callers check with IsUniqueViolation
+const (
+	SubscriberUniqueKey       = "subscribers_user_id_comic_id_key"
+	SeriesSubscriberUniqueKey = "series_subscribers_user_id_series_id_key"
+)
+
 // NewDBConn return new DB connection
 func NewDBConn() *sql.DB {
 
@@ -17,3 +27,9 @@ func NewDBConn() *sql.DB {
 
 	return Db
 }
+
+// IsUniqueViolation report whether err, or an error it wraps, is a duplicate key error of Postgres on constraint
+func IsUniqueViolation(err error, constraint string) bool {
+	var e *pq.Error
+	return errors.As(err, &e) && e.Code == uniqueViolation && e.Constraint == constraint
+}
@@ -0,0 +1,22 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsUniqueViolation(t *testing.T) {
+
+	err := errors.Wrap(&pq.Error{Code: uniqueViolation, Constraint: SubscriberUniqueKey}, "Can't subscribe")
+	require.True(t, IsUniqueViolation(err, SubscriberUniqueKey))
+
+	// Duplicate user isn't mistaken for duplicate subscription
+	err = &pq.Error{Code: uniqueViolation, Constraint: "users_appid_key"}
+	require.False(t, IsUniqueViolation(err, SubscriberUniqueKey))
+
+	require.False(t, IsUniqueViolation(&pq.Error{Code: "23503", Constraint: SubscriberUniqueKey}, SubscriberUniqueKey))
+	require.False(t, IsUniqueViolation(errors.New("Failed"), SubscriberUniqueKey))
+}
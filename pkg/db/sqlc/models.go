@@ -7,29 +7,112 @@ import (
 	"time"
 )
 
+type Chapter struct {
+	ID            int32
+	ComicID       int32
+	Name          string
+	Url           string
+	PublishedDate sql.NullTime
+	CreatedAt     time.Time
+	ImageCount    int32
+	PageSize      int32
+	Status        string
+	Number        sql.NullFloat64
+}
+
 type Comic struct {
-	ID          int32
-	Page        string
+	ID             int32
+	Page           string
+	Name           string
+	Url            string
+	ImgUrl         string
+	CloudImgUrl    string
+	LatestChap     string
+	ChapUrl        string
+	LastUpdate     time.Time
+	NextCheckAt    time.Time
+	SeriesID       sql.NullInt32
+	CrawlFailures  int32
+	LastSuccessAt  sql.NullTime
+	LastCrawlError string
+	Disabled       bool
+}
+
+type MessengerPage struct {
+	ID          string
 	Name        string
-	Url         string
-	ImgUrl      string
-	CloudImgUrl string
-	LatestChap  string
-	ChapUrl     string
-	LastUpdate  time.Time
+	AccessToken string
+	Greeting    string
+	Disabled    bool
+	CreatedAt   time.Time
 }
 
-type Subscriber struct {
+type Notification struct {
+	ID            int32
+	UserID        int32
+	ComicID       int32
+	ChapterID     int32
+	Status        string
+	Attempts      int32
+	NextAttemptAt time.Time
+	LastError     sql.NullString
+	CreatedAt     time.Time
+}
+
+type PageCache struct {
+	ComicID      int32
+	Etag         string
+	LastModified string
+	BodyHash     string
+	UpdatedAt    time.Time
+}
+
+type Series struct {
+	ID             int32
+	Name           string
+	NormalizedName string
+	CreatedAt      time.Time
+}
+
+type SeriesSubscriber struct {
 	ID        int32
 	UserID    int32
-	ComicID   int32
+	SeriesID  int32
 	CreatedAt time.Time
 }
 
+type SiteHealth struct {
+	Page                string
+	Crawls              int32
+	Failures            int32
+	ConsecutiveFailures int32
+	LastSuccessAt       sql.NullTime
+	LastError           string
+	LastErrorAt         sql.NullTime
+	Broken              bool
+	BrokenSince         sql.NullTime
+	Disabled            bool
+	UpdatedAt           time.Time
+}
+
+type Subscriber struct {
+	ID                int32
+	UserID            int32
+	ComicID           int32
+	LastReadChapterID sql.NullInt32
+	CreatedAt         time.Time
+}
+
 type User struct {
-	ID         int32
-	Name       string
-	Psid       sql.NullString
-	Appid      sql.NullString
-	ProfilePic sql.NullString
+	ID              int32
+	Name            string
+	Psid            sql.NullString
+	Appid           sql.NullString
+	ProfilePic      sql.NullString
+	NotifyChannel   string
+	NotifyTarget    sql.NullString
+	MessengerPageID sql.NullString
+	DigestMode      string
+	DigestHour      int32
+	DigestWeekday   int32
 }
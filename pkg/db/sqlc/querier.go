@@ -8,27 +8,77 @@ import (
 )
 
 type Querier interface {
+	CountComics(ctx context.Context, page string) (int64, error)
+	CountComicsOfUserByName(ctx context.Context, arg CountComicsOfUserByNameParams) (int64, error)
+	CountSeriesSubscribers(ctx context.Context, seriesID int32) (int64, error)
+	CreateChapter(ctx context.Context, arg CreateChapterParams) error
 	CreateComic(ctx context.Context, arg CreateComicParams) (Comic, error)
+	CreateNotifications(ctx context.Context, arg CreateNotificationsParams) error
+	CreateSeriesNotifications(ctx context.Context, arg CreateSeriesNotificationsParams) error
+	CreateSeriesSubscriber(ctx context.Context, arg CreateSeriesSubscriberParams) (SeriesSubscriber, error)
 	CreateSubscriber(ctx context.Context, arg CreateSubscriberParams) (Subscriber, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	DeferNotification(ctx context.Context, arg DeferNotificationParams) error
 	DeleteComic(ctx context.Context, id int32) error
+	DeletePageCache(ctx context.Context, comicID int32) error
+	DeleteSeriesSubscriber(ctx context.Context, arg DeleteSeriesSubscriberParams) error
+	DeleteSeriesSubscribersPerUser(ctx context.Context, userID int32) error
 	DeleteSubscriber(ctx context.Context, arg DeleteSubscriberParams) error
+	DeleteSubscribersPerComic(ctx context.Context, comicID int32) error
+	DeleteSubscribersPerUser(ctx context.Context, userID int32) error
 	DeleteUser(ctx context.Context, psid sql.NullString) error
+	GetChapter(ctx context.Context, id int32) (Chapter, error)
+	GetChapterByURL(ctx context.Context, arg GetChapterByURLParams) (Chapter, error)
 	GetComic(ctx context.Context, id int32) (Comic, error)
 	GetComicByPSIDAndComicID(ctx context.Context, arg GetComicByPSIDAndComicIDParams) (Comic, error)
 	GetComicByPageAndComicName(ctx context.Context, arg GetComicByPageAndComicNameParams) (Comic, error)
 	GetComicByURL(ctx context.Context, url string) (Comic, error)
 	GetComicForUpdate(ctx context.Context, id int32) (Comic, error)
+	GetLatestChapter(ctx context.Context, comicID int32) (Chapter, error)
+	GetMessengerPage(ctx context.Context, id string) (MessengerPage, error)
+	GetPageCache(ctx context.Context, comicID int32) (PageCache, error)
+	GetSeries(ctx context.Context, id int32) (Series, error)
+	GetSiteHealth(ctx context.Context, page string) (SiteHealth, error)
 	GetSubscriber(ctx context.Context, arg GetSubscriberParams) (Subscriber, error)
+	GetUser(ctx context.Context, id int32) (User, error)
 	GetUserByAppID(ctx context.Context, appid sql.NullString) (User, error)
 	GetUserByPSID(ctx context.Context, psid sql.NullString) (User, error)
-	ListComics(ctx context.Context) ([]Comic, error)
+	InitLastReadChapters(ctx context.Context, arg InitLastReadChaptersParams) error
+	ListChaptersPerComic(ctx context.Context, comicID int32) ([]Chapter, error)
+	ListComics(ctx context.Context, arg ListComicsParams) ([]Comic, error)
+	ListComicsPerSeries(ctx context.Context, seriesID sql.NullInt32) ([]Comic, error)
 	ListComicsPerUser(ctx context.Context, userID int32) ([]Comic, error)
+	ListDueComics(ctx context.Context) ([]Comic, error)
+	ListDueNotifications(ctx context.Context, limit int32) ([]Notification, error)
+	ListMessengerPages(ctx context.Context) ([]MessengerPage, error)
+	ListNotificationsByStatus(ctx context.Context, arg ListNotificationsByStatusParams) ([]Notification, error)
+	ListSiteHealth(ctx context.Context) ([]SiteHealth, error)
+	ListUnreadChaptersPerUser(ctx context.Context, userID int32) ([]ListUnreadChaptersPerUserRow, error)
 	ListUsers(ctx context.Context) ([]User, error)
 	ListUsersPerComic(ctx context.Context, comicID int32) ([]User, error)
+	MoveSubscribers(ctx context.Context, arg MoveSubscribersParams) error
+	RecordComicCrawlFailure(ctx context.Context, arg RecordComicCrawlFailureParams) (int32, error)
+	RecordComicCrawlSuccess(ctx context.Context, id int32) error
+	ResumeDeferredNotifications(ctx context.Context, userID int32) error
+	RetryFailedNotifications(ctx context.Context) (int64, error)
+	RetryNotification(ctx context.Context, id int32) (Notification, error)
 	SearchComicOfUserByName(ctx context.Context, arg SearchComicOfUserByNameParams) ([]Comic, error)
+	UpdateChapterInspection(ctx context.Context, arg UpdateChapterInspectionParams) error
 	UpdateComic(ctx context.Context, arg UpdateComicParams) (Comic, error)
+	UpdateComicDisabled(ctx context.Context, arg UpdateComicDisabledParams) (Comic, error)
+	UpdateComicLatestChapter(ctx context.Context, id int32) error
+	UpdateComicNextCheck(ctx context.Context, arg UpdateComicNextCheckParams) error
+	UpdateComicSeries(ctx context.Context, arg UpdateComicSeriesParams) error
+	UpdateLastReadChapter(ctx context.Context, arg UpdateLastReadChapterParams) (Subscriber, error)
+	UpdateNotificationStatus(ctx context.Context, arg UpdateNotificationStatusParams) error
+	UpdateSiteDisabled(ctx context.Context, arg UpdateSiteDisabledParams) (SiteHealth, error)
 	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
+	UpdateUserDigest(ctx context.Context, arg UpdateUserDigestParams) (User, error)
+	UpdateUserNotifyChannel(ctx context.Context, arg UpdateUserNotifyChannelParams) (User, error)
+	UpsertMessengerPage(ctx context.Context, arg UpsertMessengerPageParams) (MessengerPage, error)
+	UpsertPageCache(ctx context.Context, arg UpsertPageCacheParams) error
+	UpsertSeries(ctx context.Context, arg UpsertSeriesParams) (Series, error)
+	UpsertSiteHealth(ctx context.Context, arg UpsertSiteHealthParams) error
 }
 
 var _ Querier = (*Queries)(nil)
@@ -10,12 +10,43 @@ import (
 	"github.com/tinoquang/comic-notifier/pkg/util"
 )
 
+// Notification status in outbox
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+)
+
+// Chapter status, subscribers are notified only about released chapters
+const (
+	ChapterReleased    = "released"
+	ChapterQuarantined = "quarantined"
+)
+
+// Digest mode of user, notifications of daily and weekly digest wait until user's delivery time
+const (
+	DigestInstant = "instant"
+	DigestDaily   = "daily"
+	DigestWeekly  = "weekly"
+)
+
+// FailoverCrawlFailures is number of consecutive crawl failures after which a comic's subscribers
+// are notified from other sources of its series
+const FailoverCrawlFailures = 3
+
 type Store interface {
 	Querier
-	SubscribeComic(ctx context.Context, comic *Comic, user *User) error
-	UpdateNewChapter(ctx context.Context, comic *Comic, oldImgURL string) (err error)
+	SubscribeComic(ctx context.Context, comic *Comic, chapters []Chapter, user *User) error
+	AddSeriesSource(ctx context.Context, comic *Comic, chapters []Chapter, seriesID int32) error
+	UnsubscribeComic(ctx context.Context, userID, comicID int32) error
+	UnsubscribeUser(ctx context.Context, userID int32) error
+	UpdateDigestSettings(ctx context.Context, arg UpdateUserDigestParams) (User, error)
+	UpdateNewChapter(ctx context.Context, comic *Comic, chapters, newChapters []Chapter, oldImgURL string) (err error)
+	ReleaseChapter(ctx context.Context, chap Chapter) error
+	UpdateReadProgress(ctx context.Context, userID, comicID int32, chapURL string) (Chapter, error)
 	SyncComicImage(comic *Comic) error
 	RemoveComic(ctx context.Context, comicID int32) error
+	MergeComics(ctx context.Context, targetID, duplicateID int32) (Comic, error)
 }
 
 type cloudConnector interface {
@@ -43,7 +74,7 @@ func (s *store) execTx(ctx context.Context, fn func(Querier) error) error {
 
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		logging.Danger(err)
+		logging.Ctx(ctx).Danger(err)
 		return err
 	}
 
@@ -51,8 +82,8 @@ func (s *store) execTx(ctx context.Context, fn func(Querier) error) error {
 	err = fn(q)
 	if err != nil {
 		if rbErr := tx.Rollback(); rbErr != nil {
-			logging.Danger(rbErr)
-			return fmt.Errorf("tx err: %v, rb err: %v", err, rbErr)
+			logging.Ctx(ctx).Danger(rbErr)
+			return fmt.Errorf("tx err: %w, rb err: %v", err, rbErr)
 		}
 		return err
 	}
@@ -60,8 +91,8 @@ func (s *store) execTx(ctx context.Context, fn func(Querier) error) error {
 	return tx.Commit()
 }
 
-// SubscribeComic subscribe and return comic info to user
-func (s *store) SubscribeComic(ctx context.Context, comic *Comic, user *User) error {
+// SubscribeComic subscribe and return comic info to user, chapters are saved when comic is newly created
+func (s *store) SubscribeComic(ctx context.Context, comic *Comic, chapters []Chapter, user *User) error {
 
 	c := Comic{ID: comic.ID}
 	u := User{ID: user.ID}
@@ -81,21 +112,48 @@ func (s *store) SubscribeComic(ctx context.Context, comic *Comic, user *User) er
 				LastUpdate:  comic.LastUpdate,
 			})
 			if txErr != nil && txErr != sql.ErrNoRows {
-				logging.Danger(txErr)
+				logging.Ctx(ctx).Danger(txErr)
 				return
 			}
 			comic.ID = c.ID
+
+			if c.ID != 0 {
+				txErr = createChapters(ctx, q, c.ID, chapters)
+				if txErr != nil {
+					logging.Ctx(ctx).Danger(txErr)
+					return
+				}
+			}
 		}
 
-		if user.ID == 0 {
+		// Comic is grouped with same comic on other sites, including comics saved before series existed
+		if comic.ID != 0 && !comic.SeriesID.Valid {
+			txErr = assignSeries(ctx, q, comic)
+			if txErr != nil {
+				logging.Ctx(ctx).Danger(txErr)
+				return
+			}
+		}
+
+		// Same person messaging another page has a new PSID but the same app ID, they keep their account
+		if user.ID == 0 && user.Appid.Valid {
+			u, txErr = q.GetUserByAppID(ctx, user.Appid)
+			if txErr != nil && txErr != sql.ErrNoRows {
+				logging.Ctx(ctx).Danger(txErr)
+				return
+			}
+		}
+
+		if u.ID == 0 {
 			u, txErr = q.CreateUser(ctx, CreateUserParams{
-				Name:       user.Name,
-				Psid:       user.Psid,
-				Appid:      user.Appid,
-				ProfilePic: user.ProfilePic,
+				Name:            user.Name,
+				Psid:            user.Psid,
+				Appid:           user.Appid,
+				ProfilePic:      user.ProfilePic,
+				MessengerPageID: user.MessengerPageID,
 			})
 			if txErr != nil && txErr != sql.ErrNoRows {
-				logging.Danger(txErr)
+				logging.Ctx(ctx).Danger(txErr)
 				return
 			}
 		}
@@ -105,7 +163,7 @@ func (s *store) SubscribeComic(ctx context.Context, comic *Comic, user *User) er
 			ComicID: c.ID,
 		})
 		if txErr != nil {
-			logging.Danger(txErr)
+			logging.Ctx(ctx).Danger(txErr)
 			return
 		}
 
@@ -115,11 +173,11 @@ func (s *store) SubscribeComic(ctx context.Context, comic *Comic, user *User) er
 			if strings.Contains(txErr.Error(), "object doesn't exist") {
 				txErr = s.cloud.UploadImg(comic.Page, comic.Name, comic.ImgUrl)
 				if txErr != nil {
-					logging.Danger(txErr)
+					logging.Ctx(ctx).Danger(txErr)
 					return
 				}
 			} else {
-				logging.Danger(txErr)
+				logging.Ctx(ctx).Danger(txErr)
 				return
 			}
 
@@ -127,38 +185,321 @@ func (s *store) SubscribeComic(ctx context.Context, comic *Comic, user *User) er
 		return nil
 	})
 
-	if err != nil && strings.Contains(err.Error(), "duplicate") {
+	if IsUniqueViolation(err, SubscriberUniqueKey) {
 		err = util.ErrAlreadySubscribed
 	}
 
 	return err
 }
 
-// UpdateNewChapter get comic info and compare to current comic in DB to verify new chapter release
-func (s *store) UpdateNewChapter(ctx context.Context, comic *Comic, oldImgURL string) (err error) {
+// UpdateNewChapter save comic's latest chapter and its newly found chapters, ordered oldest first
+func (s *store) UpdateNewChapter(ctx context.Context, comic *Comic, chapters, newChapters []Chapter, oldImgURL string) (err error) {
 
 	if oldImgURL != comic.ImgUrl {
 		err = s.cloud.UploadImg(comic.Page, comic.Name, comic.ImgUrl)
 		if err != nil {
-			logging.Danger(err)
+			logging.Ctx(ctx).Danger(err)
+		}
+	}
+
+	return s.execTx(ctx, func(q Querier) error {
+
+		_, err := q.UpdateComic(ctx, UpdateComicParams{
+			ID:          comic.ID,
+			LatestChap:  comic.LatestChap,
+			ChapUrl:     comic.ChapUrl,
+			ImgUrl:      comic.ImgUrl,
+			CloudImgUrl: comic.CloudImgUrl,
+			LastUpdate:  comic.LastUpdate,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = createChapters(ctx, q, comic.ID, chapters)
+		if err != nil {
+			return err
+		}
+
+		for _, chap := range newChapters {
+			err = notifyChapter(ctx, q, comic.ID, chap.Url)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// AddSeriesSource save comic found on another site as a source of series, comic has no subscriber of its own
+// and is crawled so series subscribers are notified from whichever source publishes first
+func (s *store) AddSeriesSource(ctx context.Context, comic *Comic, chapters []Chapter, seriesID int32) error {
+
+	return s.execTx(ctx, func(q Querier) error {
+
+		c, err := q.CreateComic(ctx, CreateComicParams{
+			Page:        comic.Page,
+			Name:        comic.Name,
+			Url:         comic.Url,
+			ImgUrl:      comic.ImgUrl,
+			CloudImgUrl: comic.CloudImgUrl,
+			LatestChap:  comic.LatestChap,
+			ChapUrl:     comic.ChapUrl,
+			LastUpdate:  comic.LastUpdate,
+		})
+		if err == sql.ErrNoRows {
+			// Comic is already saved, it's grouped into series when it's subscribed
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		comic.ID = c.ID
+		comic.SeriesID = sql.NullInt32{Int32: seriesID, Valid: true}
+
+		err = createChapters(ctx, q, c.ID, chapters)
+		if err != nil {
+			return err
+		}
+
+		return q.UpdateComicSeries(ctx, UpdateComicSeriesParams{
+			ID:       c.ID,
+			SeriesID: comic.SeriesID,
+		})
+	})
+}
+
+// UnsubscribeComic stop notifying user of comic and of every source of comic's series, comic and sources which
+// nobody subscribes anymore are removed
+func (s *store) UnsubscribeComic(ctx context.Context, userID, comicID int32) error {
+
+	comic, err := s.GetComic(ctx, comicID)
+	if err != nil {
+		return err
+	}
+
+	err = s.execTx(ctx, func(q Querier) error {
+
+		err := q.DeleteSubscriber(ctx, DeleteSubscriberParams{
+			UserID:  userID,
+			ComicID: comicID,
+		})
+		if err != nil || !comic.SeriesID.Valid {
+			return err
+		}
+
+		return q.DeleteSeriesSubscriber(ctx, DeleteSeriesSubscriberParams{
+			UserID:   userID,
+			SeriesID: comic.SeriesID.Int32,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	comics := []Comic{comic}
+	if comic.SeriesID.Valid {
+		comics, err = s.ListComicsPerSeries(ctx, comic.SeriesID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range comics {
+		users, err := s.ListUsersPerComic(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+
+		if len(users) == 0 {
+			err = s.RemoveComic(ctx, c.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// UnsubscribeUser remove all comics and series subscribed by user and drop user's pending notifications,
+// comics which nobody subscribes anymore are removed
+func (s *store) UnsubscribeUser(ctx context.Context, userID int32) error {
+
+	comics, err := s.ListComicsPerUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.execTx(ctx, func(q Querier) error {
+
+		if err := q.DeleteSeriesSubscribersPerUser(ctx, userID); err != nil {
+			return err
+		}
+
+		return q.DeleteSubscribersPerUser(ctx, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range comics {
+		users, err := s.ListUsersPerComic(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+
+		if len(users) == 0 {
+			err = s.RemoveComic(ctx, c.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// UpdateDigestSettings save user's digest settings, notifications waiting for the old delivery time are due again
+// so they're rescheduled with the new settings
+func (s *store) UpdateDigestSettings(ctx context.Context, arg UpdateUserDigestParams) (user User, err error) {
+
+	err = s.execTx(ctx, func(q Querier) error {
+
+		user, err = q.UpdateUserDigest(ctx, arg)
+		if err != nil {
+			return err
 		}
+
+		return q.ResumeDeferredNotifications(ctx, user.ID)
+	})
+
+	return
+}
+
+// assignSeries group comic into series of its normalized name, series is created when it doesn't exist
+func assignSeries(ctx context.Context, q Querier, comic *Comic) error {
+
+	series, err := q.UpsertSeries(ctx, UpsertSeriesParams{
+		Name:           comic.Name,
+		NormalizedName: util.NormalizeTitle(comic.Name),
+	})
+	if err != nil {
+		return err
 	}
 
-	_, err = s.UpdateComic(ctx, UpdateComicParams{
-		ID:          comic.ID,
-		LatestChap:  comic.LatestChap,
-		ChapUrl:     comic.ChapUrl,
-		ImgUrl:      comic.ImgUrl,
-		CloudImgUrl: comic.CloudImgUrl,
-		LastUpdate:  comic.LastUpdate,
+	comic.SeriesID = sql.NullInt32{Int32: series.ID, Valid: true}
+	return q.UpdateComicSeries(ctx, UpdateComicSeriesParams{
+		ID:       comic.ID,
+		SeriesID: comic.SeriesID,
+	})
+}
+
+// notifyChapter queue chapter for comic's subscribers and subscribers of its series,
+// a chapter number already notified from another source of the series is skipped
+func notifyChapter(ctx context.Context, q Querier, comicID int32, chapURL string) error {
+
+	err := q.CreateNotifications(ctx, CreateNotificationsParams{
+		ComicID: comicID,
+		Url:     chapURL,
 	})
 	if err != nil {
 		return err
 	}
 
+	return q.CreateSeriesNotifications(ctx, CreateSeriesNotificationsParams{
+		ComicID:       comicID,
+		Url:           chapURL,
+		CrawlFailures: FailoverCrawlFailures,
+	})
+}
+
+// ReleaseChapter mark quarantined chapter as released and notify its comic's subscribers
+func (s *store) ReleaseChapter(ctx context.Context, chap Chapter) error {
+
+	return s.execTx(ctx, func(q Querier) error {
+
+		err := q.UpdateChapterInspection(ctx, UpdateChapterInspectionParams{
+			ID:         chap.ID,
+			ImageCount: chap.ImageCount,
+			PageSize:   chap.PageSize,
+			Status:     ChapterReleased,
+		})
+		if err != nil {
+			return err
+		}
+
+		// Comic's latest chapter is its newest released one, which may be this chapter
+		err = q.UpdateComicLatestChapter(ctx, chap.ComicID)
+		if err != nil {
+			return err
+		}
+
+		return notifyChapter(ctx, q, chap.ComicID, chap.Url)
+	})
+}
+
+// createChapters insert chapters in given order, so chapter's ID increases with its release order.
+// Chapter without status is saved as released, chapter number is parsed from its name
+func createChapters(ctx context.Context, q Querier, comicID int32, chapters []Chapter) error {
+
+	for _, chap := range chapters {
+		status := chap.Status
+		if status == "" {
+			status = ChapterReleased
+		}
+
+		number, ok := util.ChapterNumber(chap.Name)
+
+		err := q.CreateChapter(ctx, CreateChapterParams{
+			ComicID:       comicID,
+			Name:          chap.Name,
+			Url:           chap.Url,
+			PublishedDate: chap.PublishedDate,
+			ImageCount:    chap.ImageCount,
+			PageSize:      chap.PageSize,
+			Status:        status,
+			Number:        sql.NullFloat64{Float64: number, Valid: ok},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// UpdateReadProgress mark chapter with chapURL as user's last read chapter, empty chapURL means latest chapter
+func (s *store) UpdateReadProgress(ctx context.Context, userID, comicID int32, chapURL string) (chap Chapter, err error) {
+
+	if chapURL == "" {
+		chap, err = s.GetLatestChapter(ctx, comicID)
+	} else {
+		chap, err = s.GetChapterByURL(ctx, GetChapterByURLParams{
+			ComicID: comicID,
+			Url:     chapURL,
+		})
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = util.ErrNotFound
+		}
+		return
+	}
+
+	_, err = s.UpdateLastReadChapter(ctx, UpdateLastReadChapterParams{
+		UserID:            userID,
+		ComicID:           comicID,
+		LastReadChapterID: sql.NullInt32{Int32: chap.ID, Valid: true},
+	})
+	if err == sql.ErrNoRows {
+		err = util.ErrNotFound
+	}
+
+	return
+}
+
 // SyncComicImage check comic's image exists in Firebase and sync with comic in DB
 func (s *store) SyncComicImage(comic *Comic) error {
 
@@ -177,7 +518,7 @@ func (s *store) SyncComicImage(comic *Comic) error {
 	return err
 }
 
-// RemoveComic delete comic in DB and image in firebase
+// RemoveComic delete comic in DB and image in firebase, comic is kept while it's a source of a subscribed series
 func (s *store) RemoveComic(ctx context.Context, comicID int32) error {
 
 	comic, err := s.GetComic(ctx, comicID)
@@ -185,21 +526,93 @@ func (s *store) RemoveComic(ctx context.Context, comicID int32) error {
 		if err == sql.ErrNoRows {
 			return nil
 		}
-		logging.Danger(err)
+		logging.Ctx(ctx).Danger(err)
 		return err
 	}
 
+	if comic.SeriesID.Valid {
+		count, err := s.CountSeriesSubscribers(ctx, comic.SeriesID.Int32)
+		if err != nil {
+			logging.Ctx(ctx).Danger(err)
+			return err
+		}
+		if count != 0 {
+			return nil
+		}
+	}
+
 	err = s.DeleteComic(ctx, comicID)
 	if err != nil {
-		logging.Danger(err)
+		logging.Ctx(ctx).Danger(err)
 		return err
 	}
 
 	err = s.cloud.DeleteImg(comic.Page, comic.Name)
 	if err != nil {
-		logging.Danger(err)
+		logging.Ctx(ctx).Danger(err)
 		return err
 	}
 
 	return nil
 }
+
+// MergeComics move subscribers of duplicate comic to target comic and delete duplicate, read progress is kept
+// when target has the same chapter. Target joins duplicate's series if it has none
+func (s *store) MergeComics(ctx context.Context, targetID, duplicateID int32) (target Comic, err error) {
+
+	var dup Comic
+	err = s.execTx(ctx, func(q Querier) (txErr error) {
+
+		target, txErr = q.GetComicForUpdate(ctx, targetID)
+		if txErr != nil {
+			return
+		}
+
+		dup, txErr = q.GetComicForUpdate(ctx, duplicateID)
+		if txErr != nil {
+			return
+		}
+
+		txErr = q.MoveSubscribers(ctx, MoveSubscribersParams{
+			TargetID:    targetID,
+			DuplicateID: duplicateID,
+		})
+		if txErr != nil {
+			return
+		}
+
+		txErr = q.DeleteSubscribersPerComic(ctx, duplicateID)
+		if txErr != nil {
+			return
+		}
+
+		if !target.SeriesID.Valid && dup.SeriesID.Valid {
+			target.SeriesID = dup.SeriesID
+			txErr = q.UpdateComicSeries(ctx, UpdateComicSeriesParams{
+				ID:       targetID,
+				SeriesID: target.SeriesID,
+			})
+			if txErr != nil {
+				return
+			}
+		}
+
+		return q.DeleteComic(ctx, duplicateID)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = util.ErrNotFound
+		}
+		return
+	}
+
+	// Image is stored by page and comic name, duplicate with the same name shares it with target
+	if dup.Page != target.Page || dup.Name != target.Name {
+		err = s.cloud.DeleteImg(dup.Page, dup.Name)
+		if err != nil {
+			logging.Ctx(ctx).Danger(err)
+		}
+	}
+
+	return target, nil
+}
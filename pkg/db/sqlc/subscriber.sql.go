@@ -5,14 +5,16 @@ package db
 
 import (
 	"context"
+	"database/sql"
 )
 
 const createSubscriber = `-- name: CreateSubscriber :one
 INSERT INTO subscribers
 	(user_id,
-	comic_id) 
-	VALUES ($1,$2)
-	RETURNING id, user_id, comic_id, created_at
+	comic_id,
+	last_read_chapter_id) 
+	VALUES ($1,$2,(SELECT MAX(id) FROM chapters WHERE chapters.comic_id=$2))
+	RETURNING id, user_id, comic_id, last_read_chapter_id, created_at
 `
 
 type CreateSubscriberParams struct {
@@ -27,12 +29,17 @@ func (q *Queries) CreateSubscriber(ctx context.Context, arg CreateSubscriberPara
 		&i.ID,
 		&i.UserID,
 		&i.ComicID,
+		&i.LastReadChapterID,
 		&i.CreatedAt,
 	)
 	return i, err
 }
 
 const deleteSubscriber = `-- name: DeleteSubscriber :exec
+WITH pending AS (
+	DELETE FROM notifications
+	WHERE notifications.user_id=$1 AND notifications.comic_id=$2 AND notifications.status='pending'
+)
 DELETE FROM subscribers
 WHERE user_id=$1 AND comic_id=$2
 `
@@ -47,8 +54,32 @@ func (q *Queries) DeleteSubscriber(ctx context.Context, arg DeleteSubscriberPara
 	return err
 }
 
+const deleteSubscribersPerComic = `-- name: DeleteSubscribersPerComic :exec
+DELETE FROM subscribers
+WHERE comic_id=$1
+`
+
+func (q *Queries) DeleteSubscribersPerComic(ctx context.Context, comicID int32) error {
+	_, err := q.db.ExecContext(ctx, deleteSubscribersPerComic, comicID)
+	return err
+}
+
+const deleteSubscribersPerUser = `-- name: DeleteSubscribersPerUser :exec
+WITH pending AS (
+	DELETE FROM notifications
+	WHERE notifications.user_id=$1 AND notifications.status='pending'
+)
+DELETE FROM subscribers
+WHERE user_id=$1
+`
+
+func (q *Queries) DeleteSubscribersPerUser(ctx context.Context, userID int32) error {
+	_, err := q.db.ExecContext(ctx, deleteSubscribersPerUser, userID)
+	return err
+}
+
 const getSubscriber = `-- name: GetSubscriber :one
-SELECT id, user_id, comic_id, created_at FROM subscribers
+SELECT id, user_id, comic_id, last_read_chapter_id, created_at FROM subscribers
 WHERE user_id=$1 AND comic_id=$2
 `
 
@@ -64,6 +95,116 @@ func (q *Queries) GetSubscriber(ctx context.Context, arg GetSubscriberParams) (S
 		&i.ID,
 		&i.UserID,
 		&i.ComicID,
+		&i.LastReadChapterID,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const initLastReadChapters = `-- name: InitLastReadChapters :exec
+UPDATE subscribers
+SET last_read_chapter_id=(SELECT chapters.id FROM chapters WHERE chapters.comic_id=$1 AND chapters.url=$2)
+WHERE subscribers.comic_id=$1 AND subscribers.last_read_chapter_id IS NULL
+`
+
+type InitLastReadChaptersParams struct {
+	ComicID int32
+	Url     string
+}
+
+func (q *Queries) InitLastReadChapters(ctx context.Context, arg InitLastReadChaptersParams) error {
+	_, err := q.db.ExecContext(ctx, initLastReadChapters, arg.ComicID, arg.Url)
+	return err
+}
+
+const listUnreadChaptersPerUser = `-- name: ListUnreadChaptersPerUser :many
+SELECT subscribers.comic_id, COUNT(chapters.id) AS unread FROM subscribers
+JOIN chapters ON chapters.comic_id=subscribers.comic_id
+WHERE subscribers.user_id=$1 AND chapters.id > subscribers.last_read_chapter_id AND chapters.status='released'
+GROUP BY subscribers.comic_id
+`
+
+type ListUnreadChaptersPerUserRow struct {
+	ComicID int32
+	Unread  int64
+}
+
+func (q *Queries) ListUnreadChaptersPerUser(ctx context.Context, userID int32) ([]ListUnreadChaptersPerUserRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUnreadChaptersPerUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListUnreadChaptersPerUserRow{}
+	for rows.Next() {
+		var i ListUnreadChaptersPerUserRow
+		if err := rows.Scan(&i.ComicID, &i.Unread); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const moveSubscribers = `-- name: MoveSubscribers :exec
+INSERT INTO subscribers
+	(user_id,
+	comic_id,
+	last_read_chapter_id,
+	created_at)
+	SELECT dup.user_id, $1, COALESCE(
+		(SELECT target_chap.id FROM chapters AS target_chap
+		JOIN chapters AS read_chap ON read_chap.id=dup.last_read_chapter_id
+		WHERE target_chap.comic_id=$1
+		AND (target_chap.url=read_chap.url OR target_chap.number=read_chap.number)
+		ORDER BY target_chap.id DESC
+		LIMIT 1),
+		(SELECT MAX(id) FROM chapters WHERE chapters.comic_id=$1)), dup.created_at
+	FROM subscribers AS dup
+	WHERE dup.comic_id=$2
+	AND NOT EXISTS (
+		SELECT 1 FROM subscribers AS existing
+		WHERE existing.user_id=dup.user_id AND existing.comic_id=$1
+	)
+`
+
+type MoveSubscribersParams struct {
+	TargetID    int32
+	DuplicateID int32
+}
+
+func (q *Queries) MoveSubscribers(ctx context.Context, arg MoveSubscribersParams) error {
+	_, err := q.db.ExecContext(ctx, moveSubscribers, arg.TargetID, arg.DuplicateID)
+	return err
+}
+
+const updateLastReadChapter = `-- name: UpdateLastReadChapter :one
+UPDATE subscribers
+SET last_read_chapter_id=$3
+WHERE user_id=$1 AND comic_id=$2
+RETURNING id, user_id, comic_id, last_read_chapter_id, created_at
+`
+
+type UpdateLastReadChapterParams struct {
+	UserID            int32
+	ComicID           int32
+	LastReadChapterID sql.NullInt32
+}
+
+func (q *Queries) UpdateLastReadChapter(ctx context.Context, arg UpdateLastReadChapterParams) (Subscriber, error) {
+	row := q.db.QueryRowContext(ctx, updateLastReadChapter, arg.UserID, arg.ComicID, arg.LastReadChapterID)
+	var i Subscriber
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.ComicID,
+		&i.LastReadChapterID,
 		&i.CreatedAt,
 	)
 	return i, err
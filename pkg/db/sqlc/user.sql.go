@@ -13,17 +13,19 @@ INSERT INTO users
 	(name,
 	psid,
 	appid,
-	profile_pic) 
-	VALUES ($1, $2, $3, $4)
+	profile_pic,
+	messenger_page_id) 
+	VALUES ($1, $2, $3, $4, $5)
 	ON CONFLICT (psid) DO NOTHING
-	RETURNING id, name, psid, appid, profile_pic
+	RETURNING id, name, psid, appid, profile_pic, notify_channel, notify_target, messenger_page_id, digest_mode, digest_hour, digest_weekday
 `
 
 type CreateUserParams struct {
-	Name       string
-	Psid       sql.NullString
-	Appid      sql.NullString
-	ProfilePic sql.NullString
+	Name            string
+	Psid            sql.NullString
+	Appid           sql.NullString
+	ProfilePic      sql.NullString
+	MessengerPageID sql.NullString
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
@@ -32,6 +34,7 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		arg.Psid,
 		arg.Appid,
 		arg.ProfilePic,
+		arg.MessengerPageID,
 	)
 	var i User
 	err := row.Scan(
@@ -40,6 +43,12 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Psid,
 		&i.Appid,
 		&i.ProfilePic,
+		&i.NotifyChannel,
+		&i.NotifyTarget,
+		&i.MessengerPageID,
+		&i.DigestMode,
+		&i.DigestHour,
+		&i.DigestWeekday,
 	)
 	return i, err
 }
@@ -54,8 +63,32 @@ func (q *Queries) DeleteUser(ctx context.Context, psid sql.NullString) error {
 	return err
 }
 
+const getUser = `-- name: GetUser :one
+SELECT id, name, psid, appid, profile_pic, notify_channel, notify_target, messenger_page_id, digest_mode, digest_hour, digest_weekday FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id int32) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Psid,
+		&i.Appid,
+		&i.ProfilePic,
+		&i.NotifyChannel,
+		&i.NotifyTarget,
+		&i.MessengerPageID,
+		&i.DigestMode,
+		&i.DigestHour,
+		&i.DigestWeekday,
+	)
+	return i, err
+}
+
 const getUserByAppID = `-- name: GetUserByAppID :one
-SELECT id, name, psid, appid, profile_pic FROM users
+SELECT id, name, psid, appid, profile_pic, notify_channel, notify_target, messenger_page_id, digest_mode, digest_hour, digest_weekday FROM users
 WHERE appid = $1
 `
 
@@ -68,12 +101,18 @@ func (q *Queries) GetUserByAppID(ctx context.Context, appid sql.NullString) (Use
 		&i.Psid,
 		&i.Appid,
 		&i.ProfilePic,
+		&i.NotifyChannel,
+		&i.NotifyTarget,
+		&i.MessengerPageID,
+		&i.DigestMode,
+		&i.DigestHour,
+		&i.DigestWeekday,
 	)
 	return i, err
 }
 
 const getUserByPSID = `-- name: GetUserByPSID :one
-SELECT id, name, psid, appid, profile_pic FROM users
+SELECT id, name, psid, appid, profile_pic, notify_channel, notify_target, messenger_page_id, digest_mode, digest_hour, digest_weekday FROM users
 WHERE psid = $1
 `
 
@@ -86,12 +125,18 @@ func (q *Queries) GetUserByPSID(ctx context.Context, psid sql.NullString) (User,
 		&i.Psid,
 		&i.Appid,
 		&i.ProfilePic,
+		&i.NotifyChannel,
+		&i.NotifyTarget,
+		&i.MessengerPageID,
+		&i.DigestMode,
+		&i.DigestHour,
+		&i.DigestWeekday,
 	)
 	return i, err
 }
 
 const listUsers = `-- name: ListUsers :many
-SELECT id, name, psid, appid, profile_pic FROM users
+SELECT id, name, psid, appid, profile_pic, notify_channel, notify_target, messenger_page_id, digest_mode, digest_hour, digest_weekday FROM users
 ORDER BY id
 `
 
@@ -110,6 +155,12 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 			&i.Psid,
 			&i.Appid,
 			&i.ProfilePic,
+			&i.NotifyChannel,
+			&i.NotifyTarget,
+			&i.MessengerPageID,
+			&i.DigestMode,
+			&i.DigestHour,
+			&i.DigestWeekday,
 		); err != nil {
 			return nil, err
 		}
@@ -125,7 +176,7 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 }
 
 const listUsersPerComic = `-- name: ListUsersPerComic :many
-SELECT users.id, users.name, users.psid, users.appid, users.profile_pic FROM users
+SELECT users.id, users.name, users.psid, users.appid, users.profile_pic, users.notify_channel, users.notify_target, users.messenger_page_id, users.digest_mode, users.digest_hour, users.digest_weekday FROM users
 LEFT JOIN subscribers ON users.id=subscribers.user_id
 WHERE subscribers.comic_id=$1 ORDER BY users.id DESC
 `
@@ -145,6 +196,12 @@ func (q *Queries) ListUsersPerComic(ctx context.Context, comicID int32) ([]User,
 			&i.Psid,
 			&i.Appid,
 			&i.ProfilePic,
+			&i.NotifyChannel,
+			&i.NotifyTarget,
+			&i.MessengerPageID,
+			&i.DigestMode,
+			&i.DigestHour,
+			&i.DigestWeekday,
 		); err != nil {
 			return nil, err
 		}
@@ -163,7 +220,7 @@ const updateUser = `-- name: UpdateUser :one
 UPDATE users
 SET appid=$1
 WHERE psid=$2
-RETURNING id, name, psid, appid, profile_pic
+RETURNING id, name, psid, appid, profile_pic, notify_channel, notify_target, messenger_page_id, digest_mode, digest_hour, digest_weekday
 `
 
 type UpdateUserParams struct {
@@ -180,6 +237,82 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Psid,
 		&i.Appid,
 		&i.ProfilePic,
+		&i.NotifyChannel,
+		&i.NotifyTarget,
+		&i.MessengerPageID,
+		&i.DigestMode,
+		&i.DigestHour,
+		&i.DigestWeekday,
+	)
+	return i, err
+}
+
+const updateUserDigest = `-- name: UpdateUserDigest :one
+UPDATE users
+SET digest_mode=$2, digest_hour=$3, digest_weekday=$4
+WHERE appid=$1
+RETURNING id, name, psid, appid, profile_pic, notify_channel, notify_target, messenger_page_id, digest_mode, digest_hour, digest_weekday
+`
+
+type UpdateUserDigestParams struct {
+	Appid         sql.NullString
+	DigestMode    string
+	DigestHour    int32
+	DigestWeekday int32
+}
+
+func (q *Queries) UpdateUserDigest(ctx context.Context, arg UpdateUserDigestParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserDigest,
+		arg.Appid,
+		arg.DigestMode,
+		arg.DigestHour,
+		arg.DigestWeekday,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Psid,
+		&i.Appid,
+		&i.ProfilePic,
+		&i.NotifyChannel,
+		&i.NotifyTarget,
+		&i.MessengerPageID,
+		&i.DigestMode,
+		&i.DigestHour,
+		&i.DigestWeekday,
+	)
+	return i, err
+}
+
+const updateUserNotifyChannel = `-- name: UpdateUserNotifyChannel :one
+UPDATE users
+SET notify_channel=$2, notify_target=$3
+WHERE appid=$1
+RETURNING id, name, psid, appid, profile_pic, notify_channel, notify_target, messenger_page_id, digest_mode, digest_hour, digest_weekday
+`
+
+type UpdateUserNotifyChannelParams struct {
+	Appid         sql.NullString
+	NotifyChannel string
+	NotifyTarget  sql.NullString
+}
+
+func (q *Queries) UpdateUserNotifyChannel(ctx context.Context, arg UpdateUserNotifyChannelParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserNotifyChannel, arg.Appid, arg.NotifyChannel, arg.NotifyTarget)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Psid,
+		&i.Appid,
+		&i.ProfilePic,
+		&i.NotifyChannel,
+		&i.NotifyTarget,
+		&i.MessengerPageID,
+		&i.DigestMode,
+		&i.DigestHour,
+		&i.DigestWeekday,
 	)
 	return i, err
 }
@@ -1,37 +1,293 @@
+// Package logging is a leveled structured logger. Each entry is written as one logfmt or JSON line,
+// fields attached to a context are added to every entry logged with that context, so a webhook message
+// or an update job can be followed across server, crawler and store.
 package logging
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Level of log entry, entries below configured level are dropped
+type Level int
+
+// Log levels
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
 )
 
+var levelNames = []string{"debug", "info", "warning", "error"}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parse level name, "warn" and "danger" are accepted as aliases
+func ParseLevel(name string) (Level, error) {
+
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warning", "warn":
+		return LevelWarning, nil
+	case "error", "danger":
+		return LevelError, nil
+	}
+
+	return LevelInfo, errors.Errorf("Unknown log level %s", name)
+}
+
+// Output formats
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+// Field names shared by all packages
+const (
+	FieldComicID   = "comic_id"
+	FieldUserID    = "user_id"
+	FieldNotifyID  = "notification_id"
+	FieldPSID      = "psid"
+	FieldPageID    = "page_id"
+	FieldSite      = "site"
+	FieldMessageID = "mid"
+	FieldJob       = "job"
+)
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+type logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  Level
+	format string
+}
+
+var std = &logger{out: os.Stderr, level: LevelInfo, format: FormatLogfmt}
+
+// Init set minimum level and output format, it's called once at startup before services are started
+func Init(level, format string) error {
+
+	l, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	if format == "" {
+		format = FormatLogfmt
+	}
+	if format != FormatLogfmt && format != FormatJSON {
+		return errors.Errorf("Unknown log format %s", format)
+	}
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+
+	std.level = l
+	std.format = format
+	return nil
+}
+
+// SetOutput change where entries are written, default is stderr
+func SetOutput(w io.Writer) {
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+
+	std.out = w
+}
+
+// Entry is a logger carrying fields which are added to each of its log lines
+type Entry struct {
+	fields []field
+}
+
+var root = &Entry{}
+
+type ctxKey struct{}
+
+// With return copy of ctx carrying fields, keysAndValues are pairs of field name and value
+func With(ctx context.Context, keysAndValues ...interface{}) context.Context {
+	return context.WithValue(ctx, ctxKey{}, Ctx(ctx).With(keysAndValues...))
+}
+
+// Ctx return entry carrying fields of ctx
+func Ctx(ctx context.Context) *Entry {
+
+	if e, ok := ctx.Value(ctxKey{}).(*Entry); ok {
+		return e
+	}
+
+	return root
+}
+
+// With return copy of entry with more fields, a field which is already set is replaced
+func (e *Entry) With(keysAndValues ...interface{}) *Entry {
+
+	fields := make([]field, len(e.fields), len(e.fields)+len(keysAndValues)/2)
+	copy(fields, e.fields)
+
+next:
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		f := field{key: fmt.Sprint(keysAndValues[i]), value: keysAndValues[i+1]}
+		for j := range fields {
+			if fields[j].key == f.key {
+				fields[j] = f
+				continue next
+			}
+		}
+		fields = append(fields, f)
+	}
+
+	return &Entry{fields: fields}
+}
+
+// Debug logging
+func (e *Entry) Debug(args ...interface{}) {
+	e.log(LevelDebug, false, args)
+}
+
 // Info logging
-func Info(args ...interface{}) {
-	log.SetPrefix("[INFO] ")
-	log.Println(args...)
+func (e *Entry) Info(args ...interface{}) {
+	e.log(LevelInfo, false, args)
 }
 
-// Danger for error logging
-func Danger(args ...interface{}) {
-	log.SetPrefix("[ERROR] ")
-	_, fn, line, _ := runtime.Caller(1)
-	funcLine := fmt.Sprintf("%s:%d: ", fn, line)
-	args = append([]interface{}{funcLine}, args...)
-	log.Println(args...)
+// Warning logging, caller is added to entry
+func (e *Entry) Warning(args ...interface{}) {
+	e.log(LevelWarning, true, args)
 }
 
-// Warning logging
-func Warning(args ...interface{}) {
-	log.SetPrefix("[WARNING] ")
-	_, fn, line, _ := runtime.Caller(1)
-	funcLine := fmt.Sprintf("%s:%d: ", fn, line)
-	args = append([]interface{}{funcLine}, args...)
-	log.Println(args...)
+// Danger for error logging, caller is added to entry
+func (e *Entry) Danger(args ...interface{}) {
+	e.log(LevelError, true, args)
 }
 
 // Debug logging
 func Debug(args ...interface{}) {
-	log.SetPrefix("[DEBUG] ")
-	log.Println(args...)
+	root.log(LevelDebug, false, args)
+}
+
+// Info logging
+func Info(args ...interface{}) {
+	root.log(LevelInfo, false, args)
+}
+
+// Warning logging, caller is added to entry
+func Warning(args ...interface{}) {
+	root.log(LevelWarning, true, args)
+}
+
+// Danger for error logging, caller is added to entry
+func Danger(args ...interface{}) {
+	root.log(LevelError, true, args)
+}
+
+// log write one entry, it must be called directly by the exported logging functions so caller is found
+func (e *Entry) log(level Level, withCaller bool, args []interface{}) {
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+
+	if level < std.level {
+		return
+	}
+
+	fields := make([]field, 0, len(e.fields)+4)
+	fields = append(fields,
+		field{"time", time.Now().Format(time.RFC3339)},
+		field{"level", level.String()},
+		field{"msg", strings.TrimSuffix(fmt.Sprintln(args...), "\n")},
+	)
+	fields = append(fields, e.fields...)
+
+	if withCaller {
+		if _, file, line, ok := runtime.Caller(2); ok {
+			fields = append(fields, field{"caller", fmt.Sprintf("%s/%s:%d", filepath.Base(filepath.Dir(file)), filepath.Base(file), line)})
+		}
+	}
+
+	if std.format == FormatJSON {
+		std.out.Write(encodeJSON(fields))
+	} else {
+		std.out.Write(encodeLogfmt(fields))
+	}
+}
+
+func fieldValue(v interface{}) interface{} {
+
+	switch x := v.(type) {
+	case error:
+		return x.Error()
+	case fmt.Stringer:
+		return x.String()
+	}
+
+	return v
+}
+
+func encodeLogfmt(fields []field) []byte {
+
+	var buf bytes.Buffer
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+
+		val := fmt.Sprint(fieldValue(f.value))
+		if val == "" || strings.ContainsAny(val, " =\"\t\n") {
+			val = strconv.Quote(val)
+		}
+
+		buf.WriteString(f.key)
+		buf.WriteByte('=')
+		buf.WriteString(val)
+	}
+	buf.WriteByte('\n')
+
+	return buf.Bytes()
+}
+
+func encodeJSON(fields []field) []byte {
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(f.key)
+		val, err := json.Marshal(fieldValue(f.value))
+		if err != nil {
+			val, _ = json.Marshal(fmt.Sprint(f.value))
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteString("}\n")
+
+	return buf.Bytes()
 }
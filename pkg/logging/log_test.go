@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func capture(t *testing.T, level, format string) *bytes.Buffer {
+
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	require.Nil(t, Init(level, format))
+
+	t.Cleanup(func() {
+		SetOutput(os.Stderr)
+		Init("info", FormatLogfmt)
+	})
+
+	return &buf
+}
+
+func TestLogfmt(t *testing.T) {
+
+	buf := capture(t, "info", FormatLogfmt)
+
+	ctx := With(context.Background(), FieldComicID, 12, FieldSite, "beeng.net")
+	Ctx(ctx).Danger("Crawl failed")
+
+	line := buf.String()
+	require.Contains(t, line, `level=error msg="Crawl failed" comic_id=12 site=beeng.net caller=logging/log_test.go:`)
+	require.True(t, strings.HasPrefix(line, "time="))
+	require.True(t, strings.HasSuffix(line, "\n"))
+}
+
+func TestJSON(t *testing.T) {
+
+	buf := capture(t, "debug", FormatJSON)
+
+	ctx := With(context.Background(), FieldPSID, "123", FieldMessageID, "m_1")
+	ctx = With(ctx, FieldPSID, "456")
+	Ctx(ctx).With(FieldComicID, 1).Debug("Comic", 1, "is updated", errors.New("err"))
+
+	entry := map[string]interface{}{}
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+	require.Equal(t, "debug", entry["level"])
+	require.Equal(t, "Comic 1 is updated err", entry["msg"])
+	require.Equal(t, "456", entry[FieldPSID])
+	require.Equal(t, "m_1", entry[FieldMessageID])
+	require.Equal(t, 1.0, entry[FieldComicID])
+	require.NotContains(t, entry, "caller")
+
+	// Fields added to an entry don't leak into context
+	buf.Reset()
+	Ctx(ctx).Info("Done")
+	require.NotContains(t, buf.String(), FieldComicID)
+}
+
+func TestLevel(t *testing.T) {
+
+	buf := capture(t, "warning", FormatLogfmt)
+
+	Info("Dropped")
+	Debug("Dropped")
+	require.Empty(t, buf.String())
+
+	Warning("Kept")
+	require.Contains(t, buf.String(), "level=warning")
+
+	_, err := ParseLevel("verbose")
+	require.NotNil(t, err)
+	require.NotNil(t, Init("info", "xml"))
+}
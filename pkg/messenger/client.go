@@ -0,0 +1,138 @@
+// Package messenger is a client of Messenger Send API. It classifies Graph API errors and holds back pages which are
+// rate limited, so callers can handle blocked users and throttling without parsing responses
+package messenger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tinoquang/comic-notifier/pkg/metrics"
+)
+
+// ThrottleDelay is how long calls from a rate limited page are held back, Send API doesn't tell when limit is lifted
+const ThrottleDelay = time.Minute
+
+// TokenHoldDelay is how long calls with an expired token are held back, a new token is a different key so page
+// sends again as soon as admin updates it
+const TokenHoldDelay = 10 * time.Minute
+
+// Client send messages through Send API, it's safe for concurrent use and connections are reused across calls
+type Client struct {
+	endpoint string
+	http     *http.Client
+
+	mu   sync.Mutex
+	held map[string]hold // access token -> why and until when page can't send
+}
+
+type hold struct {
+	kind  error
+	until time.Time
+}
+
+// NewClient return client posting messages to endpoint, usually <graph endpoint>/me/messages
+func NewClient(endpoint string) *Client {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 32 // all calls go to one host, default of 2 idle connections would be re-dialed on every burst
+
+	return &Client{
+		endpoint: endpoint,
+		http:     &http.Client{Timeout: 20 * time.Second, Transport: transport},
+		held:     map[string]hold{},
+	}
+}
+
+// Send post message from page of token. Error is an *Error when Send API rejects the call, calls from a page which is
+// rate limited fail with ErrRateLimited until ThrottleDelay passes, and calls with an expired token fail with
+// ErrTokenExpired until TokenHoldDelay passes, both without reaching Send API
+func (c *Client) Send(ctx context.Context, token string, message interface{}) error {
+
+	if kind := c.heldKind(token, time.Now()); kind != nil {
+		status := http.StatusTooManyRequests
+		if kind == ErrTokenExpired {
+			status = http.StatusUnauthorized
+		}
+		return &Error{Status: status, kind: kind}
+	}
+
+	body := new(bytes.Buffer)
+	if err := json.NewEncoder(body).Encode(message); err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, body)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Add("Content-Type", "application/json")
+	q := request.URL.Query()
+	q.Add("access_token", token)
+	request.URL.RawQuery = q.Encode()
+
+	// Page token is in request URL, so it's dropped from transport error which is logged and saved in outbox
+	resp, err := c.http.Do(request)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return errors.Wrap(urlErr.Err, "Can't call Send API")
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		// Body is drained so connection goes back to the pool
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	e := parseError(resp.StatusCode, respBody)
+	metrics.GraphAPIErrors.WithLabelValues(e.Label()).Inc()
+
+	switch e.Cause() {
+	case ErrRateLimited:
+		c.hold(token, ErrRateLimited, time.Now().Add(ThrottleDelay))
+	case ErrTokenExpired:
+		c.hold(token, ErrTokenExpired, time.Now().Add(TokenHoldDelay))
+	}
+
+	return e
+}
+
+// heldKind return why calls with token are held back, nil if they aren't
+func (c *Client) heldKind(token string, now time.Time) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.held[token]
+	if !ok {
+		return nil
+	}
+
+	if now.After(h.until) {
+		delete(c.held, token)
+		return nil
+	}
+
+	return h.kind
+}
+
+func (c *Client) hold(token string, kind error, until time.Time) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.held[token] = hold{kind: kind, until: until}
+}
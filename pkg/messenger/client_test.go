@@ -0,0 +1,91 @@
+package messenger
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseError(t *testing.T) {
+
+	cases := []struct {
+		body string
+		kind error
+	}{
+		{`{"error":{"message":"This person isn't available right now.","type":"OAuthException","code":551,"error_subcode":1545041}}`, ErrUserUnavailable},
+		{`{"error":{"message":"(#10) This message is sent outside of allowed window.","code":10,"error_subcode":2018278}}`, ErrOutsideWindow},
+		{`{"error":{"message":"(#613) Calls to this api have exceeded the rate limit.","code":613}}`, ErrRateLimited},
+		{`{"error":{"message":"Error validating access token","type":"OAuthException","code":190,"error_subcode":463}}`, ErrTokenExpired},
+		{`{"error":{"message":"Invalid parameter","code":100}}`, ErrSendFailed},
+		{"Bad Gateway", ErrSendFailed},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.kind, errors.Cause(parseError(http.StatusBadRequest, []byte(c.body))), c.body)
+	}
+
+	require.Equal(t, "551", parseError(http.StatusBadRequest, []byte(cases[0].body)).Label())
+	require.Equal(t, "unknown", parseError(http.StatusBadGateway, []byte("Bad Gateway")).Label())
+}
+
+func TestSend(t *testing.T) {
+
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		require.JSONEq(t, `{"text":"hello"}`, string(body))
+
+		switch r.URL.Query().Get("access_token") {
+		case "throttled":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"(#613) Calls to this api have exceeded the rate limit.","code":613}}`))
+			return
+		case "expired":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"Error validating access token","code":190,"error_subcode":463}}`))
+			return
+		}
+		w.Write([]byte(`{"recipient_id":"1","message_id":"m1"}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+	message := map[string]string{"text": "hello"}
+
+	require.Nil(t, c.Send(context.Background(), "token", message))
+
+	err := c.Send(context.Background(), "throttled", message)
+	require.Equal(t, ErrRateLimited, errors.Cause(err))
+	require.Equal(t, 613, err.(*Error).Code)
+
+	// Rate limited page is held back without calling Send API, other pages still send
+	require.Equal(t, ErrRateLimited, errors.Cause(c.Send(context.Background(), "throttled", message)))
+	require.Nil(t, c.Send(context.Background(), "token", message))
+	require.Equal(t, 3, calls)
+
+	// Expired token is held back too, until admin replaces it
+	err = c.Send(context.Background(), "expired", message)
+	require.Equal(t, ErrTokenExpired, errors.Cause(err))
+	require.False(t, err.(*Error).Held())
+
+	err = c.Send(context.Background(), "expired", message)
+	require.Equal(t, ErrTokenExpired, errors.Cause(err))
+	require.True(t, err.(*Error).Held())
+	require.Equal(t, 4, calls)
+}
+
+func TestSendErrorHidesToken(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Close()
+
+	err := NewClient(srv.URL).Send(context.Background(), "secret", map[string]string{"text": "hello"})
+	require.NotNil(t, err)
+	require.NotContains(t, err.Error(), "secret")
+}
@@ -0,0 +1,108 @@
+package messenger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Kinds of Send API errors, errors.Cause of an *Error returns one of them
+var (
+	ErrUserUnavailable = errors.New("User blocked page or can't receive messages")
+	ErrOutsideWindow   = errors.New("Message is sent outside of allowed messaging window")
+	ErrRateLimited     = errors.New("Page is rate limited by Send API")
+	ErrTokenExpired    = errors.New("Page access token is expired or invalid")
+	ErrSendFailed      = errors.New("Send API call failed")
+)
+
+// Error is a failed Send API call, Code and Subcode are Graph API error code and subcode, zero if body isn't a Graph API error
+type Error struct {
+	Status  int
+	Code    int
+	Subcode int
+	Message string
+	kind    error // set when call is rejected by client without reaching Send API
+}
+
+func (e *Error) Error() string {
+
+	if e.Code == 0 {
+		return fmt.Sprintf("Error call send API, resp status %d", e.Status)
+	}
+
+	return fmt.Sprintf("Error call send API, code %d, subcode %d: %s", e.Code, e.Subcode, e.Message)
+}
+
+// Cause return kind of e, so callers handle it with errors.Cause
+func (e *Error) Cause() error {
+
+	if e.kind != nil {
+		return e.kind
+	}
+
+	return errorKind(e.Code, e.Subcode)
+}
+
+// Unwrap return kind of e, so callers can also use errors.Is of standard library
+func (e *Error) Unwrap() error {
+	return e.Cause()
+}
+
+// Held report whether call is rejected by client without reaching Send API, because page is held back after an
+// earlier error of the same kind
+func (e *Error) Held() bool {
+	return e.kind != nil
+}
+
+// Label return Graph API error code of e, "unknown" if response isn't a Graph API error
+func (e *Error) Label() string {
+
+	if e.Code == 0 {
+		return "unknown"
+	}
+
+	return strconv.Itoa(e.Code)
+}
+
+// graphError is error body returned by Graph API
+type graphError struct {
+	Error struct {
+		Message string `json:"message"`
+		Code    int    `json:"code"`
+		Subcode int    `json:"error_subcode"`
+	} `json:"error"`
+}
+
+// parseError return error of Send API response with status and body
+func parseError(status int, body []byte) *Error {
+
+	e := &Error{Status: status}
+
+	var g graphError
+	if err := json.Unmarshal(body, &g); err == nil {
+		e.Code = g.Error.Code
+		e.Subcode = g.Error.Subcode
+		e.Message = g.Error.Message
+	}
+
+	return e
+}
+
+// errorKind classify Graph API error, see https://developers.facebook.com/docs/messenger-platform/reference/send-api/error-codes
+func errorKind(code, subcode int) error {
+
+	switch {
+	case code == 551, subcode == 1545041, subcode == 2018108:
+		return ErrUserUnavailable
+	case subcode == 2018278, subcode == 2018065:
+		return ErrOutsideWindow
+	case code == 4, code == 17, code == 32, code == 613, subcode == 2018022:
+		return ErrRateLimited
+	case code == 190:
+		return ErrTokenExpired
+	}
+
+	return ErrSendFailed
+}
@@ -0,0 +1,157 @@
+// Package metrics contains Prometheus collectors of update and notify services, Messenger Send API and REST routes.
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cominify"
+
+// Results of one notification attempt
+const (
+	NotificationSent   = "sent"
+	NotificationRetry  = "retry"
+	NotificationFailed = "failed"
+)
+
+// Results of webhook events
+const (
+	WebhookAccepted         = "accepted"
+	WebhookDuplicate        = "duplicate"
+	WebhookDropped          = "dropped"
+	WebhookInvalidSignature = "invalid_signature"
+)
+
+// Queues of notify pipeline
+const (
+	QueueDue      = "due"       // due notifications of current batch waiting for a worker
+	QueueInFlight = "in_flight" // notifications being sent by workers
+)
+
+var (
+	// CrawlDuration observe each comic update crawl, outcome is "success", "not_modified" or crawl error kind
+	CrawlDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "crawl_duration_seconds",
+		Help:      "Duration of comic crawls by site and outcome.",
+		Buckets:   []float64{0.25, 0.5, 1, 2.5, 5, 10, 15, 30},
+	}, []string{"site", "outcome"})
+
+	// SweepDuration observe each update sweep over due comics
+	SweepDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "update_sweep_duration_seconds",
+		Help:      "Duration of update sweeps over due comics.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	// Notifications count notification attempts by channel and result
+	Notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_total",
+		Help:      "Notification attempts by channel and result.",
+	}, []string{"channel", "result"})
+
+	// NotificationRetries count attempts of notifications which failed before
+	NotificationRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notification_retries_total",
+		Help:      "Retried notification attempts by channel.",
+	}, []string{"channel"})
+
+	// NotifyQueueDepth is number of notifications in each queue of notify pipeline
+	NotifyQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "notify_queue_depth",
+		Help:      "Notifications waiting in each queue of notify pipeline.",
+	}, []string{"queue"})
+
+	// WebhookEvents count Messenger webhook requests rejected by signature check and events accepted or dropped as redelivery
+	WebhookEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_total",
+		Help:      "Messenger webhook events by result.",
+	}, []string{"result"})
+
+	// WebhookQueueDepth is number of webhook events waiting for a dispatcher worker
+	WebhookQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "webhook_queue_depth",
+		Help:      "Webhook events waiting for a worker.",
+	})
+
+	// WebhookQueueWait observe time webhook events wait in queue before a worker handles them
+	WebhookQueueWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "webhook_queue_wait_seconds",
+		Help:      "Time webhook events wait for a worker.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30},
+	})
+
+	// WebhookQueueFull count webhook events which found their sender's queue full, so webhook request waited for a worker
+	WebhookQueueFull = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_queue_full_total",
+		Help:      "Webhook events which waited for room in a full queue.",
+	})
+
+	// GraphAPIErrors count failed calls to Messenger Send API by Graph API error code
+	GraphAPIErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "graph_api_errors_total",
+		Help:      "Failed Messenger Send API calls by Graph API error code.",
+	}, []string{"code"})
+
+	// HTTPDuration observe each HTTP request by route
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// Handler serve metrics in Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Authenticator return basic auth validator of metrics endpoint, credentials are compared in constant time
+func Authenticator(user, password string) func(username, pass string, c echo.Context) (bool, error) {
+
+	return func(username, pass string, c echo.Context) (bool, error) {
+		userOK := subtle.ConstantTimeCompare([]byte(username), []byte(user)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+		return userOK && passOK, nil
+	}
+}
+
+// Middleware observe latency of echo routes, requests are labelled by route pattern to keep labels bounded.
+// Response isn't written yet when handler returns an error, so status is the one echo's error handler will send
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+
+	return func(c echo.Context) error {
+
+		start := time.Now()
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			status = http.StatusInternalServerError
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			}
+		}
+
+		HTTPDuration.WithLabelValues(c.Request().Method, c.Path(), strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
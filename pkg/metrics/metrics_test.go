@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+
+	e := echo.New()
+	e.Use(Middleware)
+	e.GET("/comics/:id", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/sites", func(c echo.Context) error {
+		return errors.New("DB is down")
+	})
+
+	for _, path := range []string{"/comics/1", "/comics/2", "/users"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// Requests are labelled by route, so both comics share one series
+	require.Equal(t, 2, testutil.CollectAndCount(HTTPDuration))
+
+	// Errors which aren't HTTP errors are sent as 500
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/sites", nil))
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Contains(t, rec.Body.String(), `cominify_http_request_duration_seconds_count{method="GET",route="/comics/:id",status="200"} 2`)
+	require.Contains(t, rec.Body.String(), `status="404"`)
+	require.Contains(t, rec.Body.String(), `route="/sites",status="500"`)
+}
+
+func TestAuthenticator(t *testing.T) {
+
+	e := echo.New()
+	e.GET("/metrics", echo.WrapHandler(Handler()), middleware.BasicAuth(Authenticator("prometheus", "secret")))
+
+	for _, c := range []struct {
+		user, password string
+		status         int
+	}{
+		{"prometheus", "secret", http.StatusOK},
+		{"prometheus", "wrong", http.StatusUnauthorized},
+		{"admin", "secret", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.SetBasicAuth(c.user, c.password)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		require.Equal(t, c.status, rec.Code, c.user+":"+c.password)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
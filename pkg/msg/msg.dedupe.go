@@ -0,0 +1,73 @@
+package msg
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// eventTTL is how long handled events are remembered, Messenger redelivers webhooks it considers failed for hours
+const eventTTL = 24 * time.Hour
+
+// dedupe remember keys of handled events, so redelivered webhooks don't subscribe or reply twice. Keys are kept in memory
+// only: they're lost on restart and not shared between instances, so an event redelivered after a restart or to another
+// instance is handled again. Subscribing twice is rejected by DB, user then gets one more reply
+type dedupe struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newDedupe(ttl time.Duration) *dedupe {
+	return &dedupe{ttl: ttl, seen: map[string]time.Time{}, lastPrune: time.Now()}
+}
+
+// firstSeen record key at now, return false if key is already recorded within ttl
+func (d *dedupe) firstSeen(key string, now time.Time) bool {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Expired keys are dropped once per ttl, so map size is bounded by events of two ttl windows
+	if now.Sub(d.lastPrune) >= d.ttl {
+		for k, at := range d.seen {
+			if now.Sub(at) >= d.ttl {
+				delete(d.seen, k)
+			}
+		}
+		d.lastPrune = now
+	}
+
+	if at, ok := d.seen[key]; ok && now.Sub(at) < d.ttl {
+		return false
+	}
+
+	d.seen[key] = now
+	return true
+}
+
+// forget drop key, so event is handled again when it's redelivered
+func (d *dedupe) forget(key string) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.seen, key)
+}
+
+// eventKey identify msg by its mid, postbacks without mid are identified by sender and timestamp.
+// Empty key means msg can't be identified and it's always handled
+func eventKey(msg Messaging) string {
+
+	switch {
+	case msg.Message != nil && msg.Message.Mid != "":
+		return "mid:" + msg.Message.Mid
+	case msg.PostBack != nil && msg.PostBack.Mid != "":
+		return "mid:" + msg.PostBack.Mid
+	case msg.PostBack != nil && msg.Timestamp != 0:
+		return "postback:" + msg.Sender.ID + ":" + strconv.Itoa(msg.Timestamp)
+	}
+
+	return ""
+}
@@ -0,0 +1,123 @@
+package msg
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/tinoquang/comic-notifier/pkg/metrics"
+)
+
+// job is a webhook event waiting for a worker
+type job struct {
+	pageID     string
+	msg        Messaging
+	enqueuedAt time.Time
+}
+
+// dispatcher handle webhook events with a fixed number of workers, each has a bounded queue.
+// Events of one sender always go to the same worker so they're handled in order, events of different senders in parallel
+type dispatcher struct {
+	handle  func(pageID string, msg Messaging)
+	queues  []chan job
+	workers sync.WaitGroup
+
+	mu     sync.RWMutex // closed is set with write lock, so no event is queued after queues are closed
+	closed bool
+}
+
+// newDispatcher start workers, each one handles events of its queue until dispatcher is drained
+func newDispatcher(workers, queueSize int, handle func(pageID string, msg Messaging)) *dispatcher {
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	d := &dispatcher{handle: handle}
+	for i := 0; i < workers; i++ {
+		q := make(chan job, queueSize)
+		d.queues = append(d.queues, q)
+
+		d.workers.Add(1)
+		go d.work(q)
+	}
+
+	return d
+}
+
+func (d *dispatcher) work(q chan job) {
+
+	defer d.workers.Done()
+
+	for j := range q {
+		metrics.WebhookQueueDepth.Dec()
+		metrics.WebhookQueueWait.Observe(time.Since(j.enqueuedAt).Seconds())
+		d.handle(j.pageID, j.msg)
+	}
+}
+
+// queue return queue of senderID
+func (d *dispatcher) queue(senderID string) chan job {
+
+	h := fnv.New32a()
+	h.Write([]byte(senderID))
+	return d.queues[h.Sum32()%uint32(len(d.queues))]
+}
+
+// enqueue add msg to its sender's queue. While queue is full, enqueue blocks until a worker takes an event or ctx is done.
+// Return false if msg isn't queued because ctx is done or dispatcher is drained
+func (d *dispatcher) enqueue(ctx context.Context, pageID string, msg Messaging) bool {
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return false
+	}
+
+	q := d.queue(msg.Sender.ID)
+	j := job{pageID: pageID, msg: msg, enqueuedAt: time.Now()}
+
+	metrics.WebhookQueueDepth.Inc()
+	select {
+	case q <- j:
+		return true
+	default:
+	}
+
+	metrics.WebhookQueueFull.Inc()
+	select {
+	case q <- j:
+		return true
+	case <-ctx.Done():
+		metrics.WebhookQueueDepth.Dec()
+		return false
+	}
+}
+
+// drain stop accepting events and wait until queued ones are handled, or ctx is done
+func (d *dispatcher) drain(ctx context.Context) error {
+
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, q := range d.queues {
+			close(q)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
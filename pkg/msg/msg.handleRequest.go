@@ -3,6 +3,8 @@ package msg
 import (
 	"context"
 	"time"
+
+	"github.com/tinoquang/comic-notifier/pkg/logging"
 )
 
 // UserMessage general message form
@@ -42,6 +44,7 @@ type Reply struct {
 
 // RecvPostBack --> message when user press button
 type RecvPostBack struct {
+	Mid     string `json:"mid,omitempty"`
 	Title   string `json:"title,omitempty"`
 	Payload string `json:"payload,omitempty"`
 }
@@ -53,29 +56,56 @@ type User struct {
 
 /*---------Request message method------------*/
 
+type pageIDKey struct{}
+
+// WithPageID return copy of ctx carrying ID of Messenger page which user talks to
+func WithPageID(ctx context.Context, pageID string) context.Context {
+	return context.WithValue(ctx, pageIDKey{}, pageID)
+}
+
+// PageID return ID of Messenger page carried by ctx, empty if ctx doesn't come from a webhook message
+func PageID(ctx context.Context) string {
+	id, _ := ctx.Value(pageIDKey{}).(string)
+	return id
+}
+
+// msgContext return context for handling msg sent to page, log entries of the handler are tagged with page, sender and message ID
+func msgContext(pageID string, msg Messaging, timeout int) (context.Context, context.CancelFunc) {
+
+	mid := ""
+	if msg.Message != nil {
+		mid = msg.Message.Mid
+	} else if msg.PostBack != nil {
+		mid = msg.PostBack.Mid
+	}
+
+	ctx := logging.With(context.Background(), logging.FieldPageID, pageID, logging.FieldPSID, msg.Sender.ID, logging.FieldMessageID, mid)
+	return context.WithTimeout(WithPageID(ctx, pageID), time.Duration(timeout)*time.Second)
+}
+
 // Handle text message from user
 // Only handle comic page link, other message type is discarded
-func (h *Handler) handleText(msg Messaging, timeout int) {
+func (h *Handler) handleText(pageID string, msg Messaging, timeout int) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	ctx, cancel := msgContext(pageID, msg, timeout)
 	defer cancel()
 
 	h.svi.HandleTxtMsg(ctx, msg.Sender.ID, msg.Message.Text)
 
 }
 
-func (h *Handler) handlePostback(msg Messaging, timeout int) {
+func (h *Handler) handlePostback(pageID string, msg Messaging, timeout int) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	ctx, cancel := msgContext(pageID, msg, timeout)
 	defer cancel()
 
 	h.svi.HandlePostback(ctx, msg.Sender.ID, msg.PostBack.Payload)
 	return
 }
 
-func (h *Handler) handleQuickReply(msg Messaging, timeout int) {
+func (h *Handler) handleQuickReply(pageID string, msg Messaging, timeout int) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	ctx, cancel := msgContext(pageID, msg, timeout)
 	defer cancel()
 
 	h.svi.HandleQuickReply(ctx, msg.Sender.ID, msg.Message.QuickReply.Payload)
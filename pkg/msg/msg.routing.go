@@ -3,10 +3,12 @@ package msg
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/tinoquang/comic-notifier/pkg/conf"
 	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/metrics"
 )
 
 var webhookToken string
@@ -22,24 +24,42 @@ type ServerInterface interface {
 
 // Handler main handler for incoming HTTP request
 type Handler struct {
-	svi ServerInterface
+	svi    ServerInterface
+	events *dedupe
+	queue  *dispatcher
 }
 
-// RegisterHandler : register webhook handler
-func RegisterHandler(g *echo.Group, svi ServerInterface) {
+// enqueueTimeout bound time a webhook request waits for room in a full queue, Messenger gives up on webhooks after 20s
+const enqueueTimeout = 10 * time.Second
+
+func newHandler(svi ServerInterface, workers, queueSize int) *Handler {
+
+	h := &Handler{svi: svi, events: newDedupe(eventTTL)}
+	h.queue = newDispatcher(workers, queueSize, h.handle)
+	return h
+}
+
+// RegisterHandler : register webhook handler, returned handler must be shut down to finish queued messages
+func RegisterHandler(g *echo.Group, svi ServerInterface) *Handler {
 
 	webhookToken = conf.Cfg.Webhook.WebhookToken
 
 	// Create main handler
-	h := Handler{svi: svi}
+	h := newHandler(svi, conf.Cfg.Webhook.Workers, conf.Cfg.Webhook.QueueSize)
 
 	// Register endpoint to handler
 	// Webhook verify message
 	g.GET("", h.verifyWebhook)
 
 	// Handle user message
-	g.POST("", h.parseUserMsg)
+	g.POST("", h.parseUserMsg, verifySignature(conf.Cfg.FBSecret.AppSecret))
 
+	return h
+}
+
+// Shutdown stop queueing webhook messages and wait until queued ones are handled or ctx is done
+func (h *Handler) Shutdown(ctx context.Context) error {
+	return h.queue.drain(ctx)
 }
 
 func (h *Handler) verifyWebhook(c echo.Context) error {
@@ -70,26 +90,84 @@ func (h *Handler) parseUserMsg(c echo.Context) error {
 		return c.NoContent(http.StatusBadRequest)
 	}
 
+	ctx, cancel := context.WithTimeout(c.Request().Context(), enqueueTimeout)
+	defer cancel()
+
+	dropped := false
 	if m.Object == "page" {
 		for _, entry := range m.Entries {
-			if len(entry.Messaging) != 0 {
-				switch {
-				case entry.Messaging[0].PostBack != nil:
-					go h.handlePostback(entry.Messaging[0], conf.Cfg.CtxTimeout)
-				case entry.Messaging[0].Message.QuickReply != nil:
-					go h.handleQuickReply(entry.Messaging[0], conf.Cfg.CtxTimeout)
-				case entry.Messaging[0].Message.Text != "":
-					go h.handleText(entry.Messaging[0], conf.Cfg.CtxTimeout)
-				default:
-					logging.Warning("Only support text, postback and quick-reply !!!")
-				}
-			} else {
+			if len(entry.Messaging) == 0 {
 				logging.Warning("Messge from messenger is empty !")
+				continue
+			}
+
+			// Facebook batches events, an entry may carry several messages of its page
+			for _, msg := range entry.Messaging {
+				if !h.dispatch(ctx, entry.ID, msg) {
+					dropped = true
+				}
 			}
 		}
 	} else {
 		logging.Warning("Message request unknown!!!")
 	}
 
+	// Messenger redelivers the batch, queued events are then discarded as duplicate
+	if dropped {
+		return c.NoContent(http.StatusServiceUnavailable)
+	}
+
 	return c.NoContent(http.StatusOK)
 }
+
+// dispatch queue msg sent to page pageID if it's a supported type, return false if msg is dropped because its queue is full
+func (h *Handler) dispatch(ctx context.Context, pageID string, msg Messaging) bool {
+
+	if msg.Sender == nil {
+		logging.Warning("Message without sender is discarded")
+		return true
+	}
+
+	switch {
+	case msg.PostBack != nil:
+	case msg.Message == nil:
+		// Delivery, read and other events which bot doesn't subscribe to
+		logging.Debug("Only support text, postback and quick-reply !!!")
+		return true
+	case msg.Message.QuickReply == nil && msg.Message.Text == "":
+		logging.Warning("Only support text, postback and quick-reply !!!")
+		return true
+	}
+
+	key := eventKey(msg)
+	if key != "" && !h.events.firstSeen(key, time.Now()) {
+		metrics.WebhookEvents.WithLabelValues(metrics.WebhookDuplicate).Inc()
+		logging.Info("Redelivered event", key, "is discarded")
+		return true
+	}
+
+	if !h.queue.enqueue(ctx, pageID, msg) {
+		if key != "" {
+			h.events.forget(key)
+		}
+		metrics.WebhookEvents.WithLabelValues(metrics.WebhookDropped).Inc()
+		logging.Ctx(ctx).With(logging.FieldPSID, msg.Sender.ID).Warning("Webhook event can't be queued, it's dropped")
+		return false
+	}
+
+	metrics.WebhookEvents.WithLabelValues(metrics.WebhookAccepted).Inc()
+	return true
+}
+
+// handle msg by its type, called by dispatcher workers
+func (h *Handler) handle(pageID string, msg Messaging) {
+
+	switch {
+	case msg.PostBack != nil:
+		h.handlePostback(pageID, msg, conf.Cfg.CtxTimeout)
+	case msg.Message.QuickReply != nil:
+		h.handleQuickReply(pageID, msg, conf.Cfg.CtxTimeout)
+	default:
+		h.handleText(pageID, msg, conf.Cfg.CtxTimeout)
+	}
+}
@@ -0,0 +1,62 @@
+package msg
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/metrics"
+)
+
+const (
+	signatureHeader = "X-Hub-Signature-256"
+	signaturePrefix = "sha256="
+	maxBodySize     = 1 << 20 // body is read before it's verified, webhook batches of Messenger are far smaller
+)
+
+// verifySignature reject webhook requests whose body isn't signed with app secret, so only Facebook can post messages to bot
+func verifySignature(appSecret string) echo.MiddlewareFunc {
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+
+			body, err := ioutil.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, maxBodySize))
+			if err != nil {
+				return c.NoContent(http.StatusRequestEntityTooLarge)
+			}
+
+			if !validSignature(appSecret, body, c.Request().Header.Get(signatureHeader)) {
+				metrics.WebhookEvents.WithLabelValues(metrics.WebhookInvalidSignature).Inc()
+				logging.Warning("Webhook request from", c.RealIP(), "has invalid signature")
+				return c.NoContent(http.StatusForbidden)
+			}
+
+			// Body is consumed by signature check, handler binds it again
+			c.Request().Body = ioutil.NopCloser(bytes.NewReader(body))
+			return next(c)
+		}
+	}
+}
+
+// validSignature report whether signature is "sha256=" followed by hex HMAC-SHA256 of body keyed with app secret
+func validSignature(appSecret string, body []byte, signature string) bool {
+
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+
+	sum, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write(body)
+	return hmac.Equal(sum, mac.Sum(nil))
+}
@@ -0,0 +1,185 @@
+package msg
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/tinoquang/comic-notifier/pkg/conf"
+)
+
+// recordServer save handled messages as pageID:senderID:payload
+type recordServer struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	received []string
+}
+
+func (s *recordServer) record(ctx context.Context, senderID, payload string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.wg.Done()
+
+	s.received = append(s.received, PageID(ctx)+":"+senderID+":"+payload)
+}
+
+func (s *recordServer) HandleTxtMsg(ctx context.Context, senderID, text string) {
+	s.record(ctx, senderID, text)
+}
+
+func (s *recordServer) HandlePostback(ctx context.Context, senderID, payload string) {
+	s.record(ctx, senderID, payload)
+}
+
+func (s *recordServer) HandleQuickReply(ctx context.Context, senderID, payload string) {
+	s.record(ctx, senderID, payload)
+}
+
+func TestParseUserMsg(t *testing.T) {
+
+	body := `{"object": "page", "entry": [
+		{"id": "page-1", "messaging": [
+			{"sender": {"id": "1"}, "message": {"mid": "m1", "text": "hello"}},
+			{"sender": {"id": "2"}, "timestamp": 100, "postback": {"payload": "/list"}},
+			{"sender": {"id": "3"}, "delivery": {"mids": ["m0"]}}
+		]},
+		{"id": "page-2", "messaging": [
+			{"sender": {"id": "4"}, "message": {"mid": "m2", "text": "/list", "quick_reply": {"payload": "/list"}}}
+		]}
+	]}`
+
+	conf.Cfg = &conf.Config{CtxTimeout: 10}
+	s := &recordServer{}
+	s.wg.Add(3)
+	h := newHandler(s, 2, 4)
+	defer h.Shutdown(context.Background())
+
+	// Redelivered events are handled once
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.Nil(t, h.parseUserMsg(echo.New().NewContext(req, rec)))
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	s.wg.Wait()
+	sort.Strings(s.received)
+	require.Equal(t, []string{"page-1:1:hello", "page-1:2:/list", "page-2:4:/list"}, s.received)
+}
+
+func TestVerifySignature(t *testing.T) {
+
+	body := `{"object": "page"}`
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+	valid := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	e := echo.New()
+	e.POST("/webhook", func(c echo.Context) error {
+		m := &UserMessage{}
+		if err := c.Bind(m); err != nil {
+			return c.NoContent(http.StatusBadRequest)
+		}
+		return c.String(http.StatusOK, m.Object)
+	}, verifySignature("secret"))
+
+	for signature, status := range map[string]int{
+		valid:               http.StatusOK,
+		"":                  http.StatusForbidden,
+		"sha256=zz":         http.StatusForbidden,
+		"sha1=" + valid[7:]: http.StatusForbidden,
+		"sha256=" + hex.EncodeToString([]byte("forged")): http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(signatureHeader, signature)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		require.Equal(t, status, rec.Code, signature)
+		if status == http.StatusOK {
+			require.Equal(t, "page", rec.Body.String())
+		}
+	}
+
+	// Oversized body is rejected before it's read whole
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(strings.Repeat(" ", maxBodySize+1)))
+	req.Header.Set(signatureHeader, valid)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+func TestDedupe(t *testing.T) {
+
+	now := time.Now()
+	d := newDedupe(time.Hour)
+
+	require.True(t, d.firstSeen("mid:1", now))
+	require.False(t, d.firstSeen("mid:1", now.Add(time.Minute)))
+	require.True(t, d.firstSeen("mid:2", now.Add(time.Minute)))
+
+	// Expired keys are handled again and pruned
+	require.True(t, d.firstSeen("mid:1", now.Add(2*time.Hour)))
+	require.Len(t, d.seen, 1)
+
+	sender := &User{ID: "1"}
+	require.Equal(t, "mid:m1", eventKey(Messaging{Sender: sender, Message: &RecvMsg{Mid: "m1"}}))
+	require.Equal(t, "postback:1:100", eventKey(Messaging{Sender: sender, Timestamp: 100, PostBack: &RecvPostBack{Payload: "/list"}}))
+	require.Empty(t, eventKey(Messaging{Sender: sender, PostBack: &RecvPostBack{Payload: "/list"}}))
+}
+
+func TestDispatcher(t *testing.T) {
+
+	var mu sync.Mutex
+	handled := map[string][]string{}
+	release := make(chan struct{})
+
+	d := newDispatcher(4, 1, func(pageID string, msg Messaging) {
+		if msg.Message.Text == "block" {
+			<-release
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		handled[msg.Sender.ID] = append(handled[msg.Sender.ID], msg.Message.Text)
+	})
+
+	event := func(senderID, text string) Messaging {
+		return Messaging{Sender: &User{ID: senderID}, Message: &RecvMsg{Text: text}}
+	}
+
+	// Worker of sender 1 is busy and its queue is full, next event waits until ctx is done
+	require.True(t, d.enqueue(context.Background(), "page", event("1", "block")))
+	require.Eventually(t, func() bool { return len(d.queue("1")) == 0 }, time.Second, time.Millisecond)
+	require.True(t, d.enqueue(context.Background(), "page", event("1", "a")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.False(t, d.enqueue(ctx, "page", event("1", "b")))
+
+	close(release)
+	for i := 0; i < 10; i++ {
+		require.True(t, d.enqueue(context.Background(), "page", event("1", strconv.Itoa(i))))
+		require.True(t, d.enqueue(context.Background(), "page", event("2", strconv.Itoa(i))))
+	}
+
+	// Drain finishes queued events and rejects new ones
+	require.Nil(t, d.drain(context.Background()))
+	require.False(t, d.enqueue(context.Background(), "page", event("1", "late")))
+
+	require.Equal(t, []string{"block", "a", "0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, handled["1"])
+	require.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, handled["2"])
+}
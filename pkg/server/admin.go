@@ -0,0 +1,433 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/tinoquang/comic-notifier/pkg/api"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/messenger"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+// broadcastInterval space Send API calls of a broadcast, so page stays under Messenger rate limit
+const broadcastInterval = 100 * time.Millisecond
+
+// broadcastThrottleDelay is how long broadcast waits when page is rate limited, page's sends are held back until then
+var broadcastThrottleDelay = messenger.ThrottleDelay
+
+/* ===================== Admin ============================ */
+
+// AdminCrawlComic (POST /admin/comics/{id}/crawl)
+func (a *API) AdminCrawlComic(ctx echo.Context, id int) error {
+
+	if !isAdmin(ctx) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	c, err := a.store.GetComic(ctx.Request().Context(), int32(id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.String(http.StatusNotFound, "404 - Not found")
+		}
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	// Page validators are dropped, so comic page is parsed even if site reports it unchanged
+	err = a.store.DeletePageCache(ctx.Request().Context(), c.ID)
+	if err != nil {
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	// Like update workers, crawl is finished even if admin leaves, so new chapters and notifications are saved together
+	crawlCtx := logging.With(context.Background(), logging.FieldJob, "admin_crawl", logging.FieldComicID, c.ID, logging.FieldSite, c.Page)
+	crawlCtx, cancel := context.WithTimeout(crawlCtx, 15*time.Second)
+	defer cancel()
+
+	if checkComic(crawlCtx, a.store, a.crawler, c, a.interval, a.wake, newSiteOutcomes()) == nil {
+		return ctx.String(http.StatusBadGateway, "Comic can't be crawled")
+	}
+
+	c, err = a.store.GetComic(ctx.Request().Context(), c.ID)
+	if err != nil {
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	comic := createResponseComic(c)
+	return ctx.JSON(http.StatusOK, &comic)
+}
+
+// AdminUpdateComicStatus (PUT /admin/comics/{id}/status)
+func (a *API) AdminUpdateComicStatus(ctx echo.Context, id int) error {
+
+	if !isAdmin(ctx) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	status := api.DisabledStatus{}
+	if err := ctx.Bind(&status); err != nil {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	c, err := a.store.UpdateComicDisabled(ctx.Request().Context(), db.UpdateComicDisabledParams{
+		ID:       int32(id),
+		Disabled: status.Disabled,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.String(http.StatusNotFound, "404 - Not found")
+		}
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	comic := createResponseComic(c)
+	return ctx.JSON(http.StatusOK, &comic)
+}
+
+// AdminMergeComic (POST /admin/comics/{id}/merge)
+func (a *API) AdminMergeComic(ctx echo.Context, id int) error {
+
+	if !isAdmin(ctx) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	merge := api.MergeComic{}
+	if err := ctx.Bind(&merge); err != nil {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if merge.DuplicateID == id {
+		return ctx.String(http.StatusBadRequest, "Comic can't be merged into itself")
+	}
+
+	c, err := a.store.MergeComics(ctx.Request().Context(), int32(id), int32(merge.DuplicateID))
+	if err != nil {
+		if err == util.ErrNotFound {
+			return ctx.String(http.StatusNotFound, "404 - Not found")
+		}
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	logging.Info("Comic", merge.DuplicateID, "is merged into comic", id)
+	comic := createResponseComic(c)
+	return ctx.JSON(http.StatusOK, &comic)
+}
+
+// AdminUpdateSiteStatus (PUT /admin/sites/{page}/status)
+func (a *API) AdminUpdateSiteStatus(ctx echo.Context, page string) error {
+
+	if !isAdmin(ctx) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	status := api.DisabledStatus{}
+	if err := ctx.Bind(&status); err != nil {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	h, err := a.store.UpdateSiteDisabled(ctx.Request().Context(), db.UpdateSiteDisabledParams{
+		Page:     page,
+		Disabled: status.Disabled,
+	})
+	if err != nil {
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	site := createResponseSiteHealth(h)
+	return ctx.JSON(http.StatusOK, &site)
+}
+
+// AdminMessengerPages (GET /admin/messenger-pages)
+func (a *API) AdminMessengerPages(ctx echo.Context) error {
+
+	if !isAdmin(ctx) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	list, err := a.store.ListMessengerPages(ctx.Request().Context())
+	if err != nil {
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	response := []api.MessengerPage{}
+	for _, p := range list {
+		response = append(response, createResponseMessengerPage(p))
+	}
+	return ctx.JSON(http.StatusOK, &response)
+}
+
+// AdminUpdateMessengerPage (PUT /admin/messenger-pages/{id})
+func (a *API) AdminUpdateMessengerPage(ctx echo.Context, id string) error {
+
+	if !isAdmin(ctx) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	settings := api.MessengerPageSettings{}
+	if err := ctx.Bind(&settings); err != nil {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if settings.AccessToken == "" {
+		return ctx.String(http.StatusBadRequest, "Access token is empty")
+	}
+
+	arg := db.UpsertMessengerPageParams{
+		ID:          id,
+		AccessToken: settings.AccessToken,
+	}
+	if settings.Name != nil {
+		arg.Name = *settings.Name
+	}
+	if settings.Greeting != nil {
+		arg.Greeting = *settings.Greeting
+	}
+	if settings.Disabled != nil {
+		arg.Disabled = *settings.Disabled
+	}
+
+	p, err := a.store.UpsertMessengerPage(ctx.Request().Context(), arg)
+	if err != nil {
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	if pages != nil {
+		pages.forget(id)
+	}
+
+	page := createResponseMessengerPage(p)
+	return ctx.JSON(http.StatusOK, &page)
+}
+
+// AdminSweep (POST /admin/sweep)
+func (a *API) AdminSweep(ctx echo.Context) error {
+
+	if !isAdmin(ctx) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	// One pending trigger is enough, a sweep requested during a running sweep starts right after it
+	select {
+	case a.sweep <- struct{}{}:
+	default:
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// AdminNotifications (GET /admin/notifications)
+func (a *API) AdminNotifications(ctx echo.Context, params api.AdminNotificationsParams) error {
+
+	if !isAdmin(ctx) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	status := db.NotificationFailed
+	if params.Status != nil {
+		status = string(*params.Status)
+	}
+	_, limit, offset := listArgs(nil, params.Limit, params.Offset)
+
+	notifications, err := a.store.ListNotificationsByStatus(ctx.Request().Context(), db.ListNotificationsByStatusParams{
+		Status: status,
+		Limit:  int32(limit),
+		Offset: int32(offset),
+	})
+	if err != nil {
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	page := []api.Notification{}
+	for _, n := range notifications {
+		page = append(page, createResponseNotification(n))
+	}
+	return ctx.JSON(http.StatusOK, &page)
+}
+
+// AdminRetryNotification (POST /admin/notifications/{id}/retry)
+func (a *API) AdminRetryNotification(ctx echo.Context, id int) error {
+
+	if !isAdmin(ctx) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	n, err := a.store.RetryNotification(ctx.Request().Context(), int32(id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.String(http.StatusNotFound, "404 - Not found")
+		}
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+	wakeNotifyService(a.wake)
+
+	notification := createResponseNotification(n)
+	return ctx.JSON(http.StatusOK, &notification)
+}
+
+// AdminRetryFailedNotifications (POST /admin/notifications/retry)
+func (a *API) AdminRetryFailedNotifications(ctx echo.Context) error {
+
+	if !isAdmin(ctx) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	retried, err := a.store.RetryFailedNotifications(ctx.Request().Context())
+	if err != nil {
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	if retried != 0 {
+		wakeNotifyService(a.wake)
+	}
+
+	return ctx.JSON(http.StatusOK, &api.RetryResult{Retried: int(retried)})
+}
+
+// AdminBroadcast (POST /admin/broadcast)
+func (a *API) AdminBroadcast(ctx echo.Context) error {
+
+	if !isAdmin(ctx) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	msg := api.Broadcast{}
+	if err := ctx.Bind(&msg); err != nil {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	message := strings.TrimSpace(msg.Message)
+	if message == "" {
+		return ctx.String(http.StatusBadRequest, "Message is empty")
+	}
+
+	users, err := a.store.ListUsers(ctx.Request().Context())
+	if err != nil {
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	recipients := []db.User{}
+	for _, u := range users {
+		if u.Psid.Valid {
+			recipients = append(recipients, u)
+		}
+	}
+
+	send := func(ctx context.Context, user *db.User, message string) error {
+		err := sendUserText(ctx, user, message)
+		handleSendError(userContext(ctx, user), a.store, user.ID, err)
+		return err
+	}
+
+	// Sending is throttled, so it continues after response is returned until it's done or server shuts down
+	a.jobs.Add(1)
+	go func() {
+		defer a.jobs.Done()
+		broadcast(logging.With(a.ctx, logging.FieldJob, "broadcast"), recipients, message, broadcastInterval, send)
+	}()
+
+	return ctx.JSON(http.StatusAccepted, &api.BroadcastResult{Recipients: len(recipients)})
+}
+
+// broadcast send message to each user, one call every interval. When page is rate limited, the same user is retried after
+// broadcastThrottleDelay, other failed users are logged and skipped. Return number of users reached
+func broadcast(ctx context.Context, users []db.User, message string, interval time.Duration, send func(ctx context.Context, user *db.User, message string) error) int {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	sent := 0
+	for i := 0; i < len(users); {
+		wait := ticker.C
+
+		err := send(ctx, &users[i], message)
+		switch {
+		case errors.Cause(err) == messenger.ErrRateLimited:
+			logging.Ctx(ctx).Warning("Broadcast is throttled, retry in", broadcastThrottleDelay)
+			wait = time.After(broadcastThrottleDelay)
+		case err != nil:
+			logging.Ctx(ctx).With(logging.FieldPSID, users[i].Psid.String).Danger("Can't send broadcast, err", err)
+			i++
+		default:
+			sent++
+			i++
+		}
+
+		if i == len(users) {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			logging.Ctx(ctx).Warning("Broadcast is stopped after", sent, "of", len(users), "users")
+			return sent
+		case <-wait:
+		}
+	}
+
+	logging.Ctx(ctx).Info("Broadcast is sent to", sent, "of", len(users), "users")
+	return sent
+}
+
+func createResponseComic(c db.Comic) api.Comic {
+
+	id := int(c.ID)
+	return api.Comic{
+		Id:         &id,
+		Page:       &c.Page,
+		Name:       &c.Name,
+		Url:        &c.Url,
+		LatestChap: &c.LatestChap,
+		ImgURL:     &c.CloudImgUrl,
+		ChapURL:    &c.ChapUrl,
+		Disabled:   &c.Disabled,
+	}
+}
+
+func createResponseMessengerPage(p db.MessengerPage) api.MessengerPage {
+
+	return api.MessengerPage{
+		Id:        p.ID,
+		Name:      &p.Name,
+		Greeting:  &p.Greeting,
+		Disabled:  p.Disabled,
+		CreatedAt: &p.CreatedAt,
+	}
+}
+
+func createResponseNotification(n db.Notification) api.Notification {
+
+	notification := api.Notification{
+		Id:            int(n.ID),
+		UserID:        int(n.UserID),
+		ComicID:       int(n.ComicID),
+		ChapterID:     int(n.ChapterID),
+		Status:        api.NotificationStatus(n.Status),
+		Attempts:      int(n.Attempts),
+		NextAttemptAt: &n.NextAttemptAt,
+		CreatedAt:     &n.CreatedAt,
+	}
+
+	if n.LastError.Valid {
+		notification.LastError = &n.LastError.String
+	}
+
+	return notification
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/tinoquang/comic-notifier/pkg/auth"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/messenger"
+)
+
+func (s *fakeStore) DeletePageCache(ctx context.Context, comicID int32) error {
+	return nil
+}
+
+func (s *fakeStore) RetryNotification(ctx context.Context, id int32) (db.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if int(id) > len(s.outbox) || s.outbox[id-1].Status != db.NotificationFailed {
+		return db.Notification{}, sql.ErrNoRows
+	}
+
+	n := &s.outbox[id-1]
+	n.Status = db.NotificationPending
+	n.Attempts = 0
+	n.NextAttemptAt = time.Now()
+	return *n, nil
+}
+
+// testAdminID is app ID of the configured admin in tests
+const testAdminID = "admin"
+
+func init() {
+	adminAppIDs = []string{testAdminID}
+}
+
+// newAdminContext return echo context of request sent by user with role, admin is testAdminID and other users are user 1
+func newAdminContext(role string) (echo.Context, *httptest.ResponseRecorder) {
+
+	id := "1"
+	if role == auth.RoleAdmin {
+		id = testAdminID
+	}
+
+	rec := httptest.NewRecorder()
+	ctx := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec)
+	ctx.Set("user", &jwt.Token{Claims: &auth.Claims{Role: role, StandardClaims: jwt.StandardClaims{Id: id}}})
+	return ctx, rec
+}
+
+func TestIsAdmin(t *testing.T) {
+
+	ctx, _ := newAdminContext(auth.RoleAdmin)
+	require.True(t, isAdmin(ctx))
+
+	ctx, _ = newAdminContext("")
+	require.False(t, isAdmin(ctx))
+
+	// Admin role in token of user who isn't admin anymore is ignored
+	ctx.Set("user", &jwt.Token{Claims: &auth.Claims{Role: auth.RoleAdmin, StandardClaims: jwt.StandardClaims{Id: "1"}}})
+	require.False(t, isAdmin(ctx))
+
+	ctx = echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+	require.False(t, isAdmin(ctx))
+}
+
+func TestAdminCrawlComic(t *testing.T) {
+
+	s := newFakeStore(1, 2)
+	crwl := &fakeCrawler{crawls: map[string]int{}, maxChap: 2}
+	wake := make(chan struct{}, 1)
+	a := NewAPI(s, crwl, nil, time.Hour, wake, make(chan struct{}, 1))
+
+	ctx, rec := newAdminContext("")
+	require.Nil(t, a.AdminCrawlComic(ctx, 1))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Empty(t, crwl.crawls)
+
+	ctx, rec = newAdminContext(auth.RoleAdmin)
+	require.Nil(t, a.AdminCrawlComic(ctx, 1))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "https://test.vn/comic-1/2")
+	require.Len(t, s.outbox, 2)
+	require.Len(t, wake, 1)
+
+	ctx, rec = newAdminContext(auth.RoleAdmin)
+	require.Nil(t, a.AdminCrawlComic(ctx, 2))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	crwl.failing = true
+	ctx, rec = newAdminContext(auth.RoleAdmin)
+	require.Nil(t, a.AdminCrawlComic(ctx, 1))
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+}
+
+func TestAdminSweep(t *testing.T) {
+
+	sweep := make(chan struct{}, 1)
+	a := NewAPI(newFakeStore(0, 0), &fakeCrawler{}, nil, time.Hour, make(chan struct{}, 1), sweep)
+
+	// Sweeps requested while one is pending don't block
+	for i := 0; i < 2; i++ {
+		ctx, rec := newAdminContext(auth.RoleAdmin)
+		require.Nil(t, a.AdminSweep(ctx))
+		require.Equal(t, http.StatusAccepted, rec.Code)
+	}
+	require.Len(t, sweep, 1)
+}
+
+func TestAdminRetryNotification(t *testing.T) {
+
+	s := newFakeStore(1, 2)
+	s.outbox = []db.Notification{
+		{ID: 1, UserID: 1, ComicID: 1, ChapterID: 1, Status: db.NotificationFailed, Attempts: maxNotifyAttempts},
+		{ID: 2, UserID: 2, ComicID: 1, ChapterID: 1, Status: db.NotificationSent, Attempts: 1},
+	}
+	wake := make(chan struct{}, 1)
+	a := NewAPI(s, &fakeCrawler{}, nil, time.Hour, wake, make(chan struct{}, 1))
+
+	ctx, rec := newAdminContext(auth.RoleAdmin)
+	require.Nil(t, a.AdminRetryNotification(ctx, 1))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, db.NotificationPending, s.outbox[0].Status)
+	require.Zero(t, s.outbox[0].Attempts)
+	require.Len(t, wake, 1)
+
+	// Only failed notifications are retried
+	ctx, rec = newAdminContext(auth.RoleAdmin)
+	require.Nil(t, a.AdminRetryNotification(ctx, 2))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestBroadcast(t *testing.T) {
+
+	var mu sync.Mutex
+	received := []string{}
+	send := func(ctx context.Context, user *db.User, message string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if user.Psid.String == "blocked" {
+			return errors.New("User blocked page")
+		}
+		received = append(received, user.Psid.String+":"+message)
+		return nil
+	}
+
+	users := func(psids ...string) []db.User {
+		list := []db.User{}
+		for _, psid := range psids {
+			list = append(list, db.User{Psid: sql.NullString{String: psid, Valid: true}})
+		}
+		return list
+	}
+
+	sent := broadcast(context.Background(), users("1", "blocked", "2"), "Hello", time.Millisecond, send)
+	require.Equal(t, 2, sent)
+	require.Equal(t, []string{"1:Hello", "2:Hello"}, received)
+
+	// Cancelled broadcast stops before next user
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sent = broadcast(ctx, users("3", "4"), "Bye", time.Hour, send)
+	require.Equal(t, 1, sent)
+}
+
+func (s *fakeStore) ListUsers(ctx context.Context) ([]db.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]db.User{}, s.users...), nil
+}
+
+func TestAdminBroadcastStopsOnShutdown(t *testing.T) {
+
+	defer func(c *messenger.Client) { sendAPI = c }(sendAPI)
+
+	var mu sync.Mutex
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		w.Write([]byte(`{"recipient_id":"1","message_id":"m1"}`))
+	}))
+	defer srv.Close()
+	sendAPI = messenger.NewClient(srv.URL)
+
+	s := newFakeStore(0, 3)
+	for i := range s.users {
+		s.users[i].Psid = sql.NullString{String: fmt.Sprint(i + 1), Valid: true}
+	}
+
+	// Broadcast runs under server's ctx, so shutdown stops it and waits for it
+	serverCtx, cancel := context.WithCancel(context.Background())
+	a := NewAPI(s, &fakeCrawler{}, nil, time.Hour, nil, nil)
+	a.ctx = serverCtx
+
+	ctx, rec := newAdminContext(auth.RoleAdmin)
+	ctx.SetRequest(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"message": "Hello"}`)))
+	ctx.Request().Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	require.Nil(t, a.AdminBroadcast(ctx))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	cancel()
+	a.jobs.Wait()
+	require.LessOrEqual(t, calls, 1)
+}
+
+func TestBroadcastThrottled(t *testing.T) {
+
+	defer func(d time.Duration) { broadcastThrottleDelay = d }(broadcastThrottleDelay)
+	broadcastThrottleDelay = time.Millisecond
+
+	calls := []string{}
+	send := func(ctx context.Context, user *db.User, message string) error {
+		calls = append(calls, user.Psid.String)
+		if len(calls) == 2 {
+			return &messenger.Error{Code: 613}
+		}
+		return nil
+	}
+
+	// Throttled user is retried once throttle is lifted instead of being skipped
+	users := []db.User{{Psid: sql.NullString{String: "1", Valid: true}}, {Psid: sql.NullString{String: "2", Valid: true}}}
+	sent := broadcast(context.Background(), users, "Hello", time.Millisecond, send)
+	require.Equal(t, 2, sent)
+	require.Equal(t, []string{"1", "2", "2"}, calls)
+}
@@ -1,39 +1,77 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
 	"github.com/tinoquang/comic-notifier/pkg/api"
+	"github.com/tinoquang/comic-notifier/pkg/auth"
 	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
 	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/util"
 )
 
 // API -> server handler for api endpoint
 type API struct {
-	store db.Store
+	store      db.Store
+	crawler    infoCrawler
+	subscriber *SubscribeService
+	interval   time.Duration   // update interval, used to schedule comics crawled by admin
+	wake       chan<- struct{} // wake notify service up
+	sweep      chan<- struct{} // trigger update sweep
+
+	// Jobs which outlive their request, like broadcasts, run under server's ctx and are waited for on shutdown
+	ctx  context.Context
+	jobs *sync.WaitGroup
 }
 
 // NewAPI return new api interface
-func NewAPI(s db.Store) *API {
-	return &API{store: s}
+func NewAPI(s db.Store, crawler infoCrawler, subscriber *SubscribeService, interval time.Duration, wake, sweep chan<- struct{}) *API {
+	return &API{
+		store:      s,
+		crawler:    crawler,
+		subscriber: subscriber,
+		interval:   interval,
+		wake:       wake,
+		sweep:      sweep,
+		ctx:        context.Background(),
+		jobs:       &sync.WaitGroup{},
+	}
 }
 
 // Comics (GET /comics)
 func (a *API) Comics(ctx echo.Context, params api.ComicsParams) error {
 
-	comicPage := api.ComicPage{}
-	comics, err := a.store.ListComics(ctx.Request().Context())
+	_, limit, offset := listArgs(nil, params.Limit, params.Offset)
+	sortBy, descending := sortArgs(params.Sort, params.Order, "")
+	site := toEnum(params.Site)
+
+	comicPage := api.ComicPage{Comics: []api.Comic{}, Limit: limit, Offset: offset}
+	comics, err := a.store.ListComics(ctx.Request().Context(), db.ListComicsParams{
+		Page:       site,
+		SortBy:     sortBy,
+		Descending: descending,
+		Limit:      int32(limit),
+		Offset:     int32(offset),
+	})
+	if err != nil {
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
 
+	total, err := a.store.CountComics(ctx.Request().Context(), site)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return ctx.JSON(http.StatusOK, &comicPage)
-		}
 		logging.Danger(err)
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
+	comicPage.Total = int(total)
 
 	for i := range comics {
 		c := comics[i]
@@ -117,16 +155,98 @@ func (a *API) GetUser(ctx echo.Context, userAppID string) error {
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
 
-	user = createResponseUser(u)
+	user = createOwnResponseUser(u)
+	return ctx.JSON(http.StatusOK, &user)
+}
+
+// UpdateNotifySettings (PUT /users/{id})
+func (a *API) UpdateNotifySettings(ctx echo.Context, userAppID string) error {
+
+	if !userHasAccess(ctx, userAppID) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	settings := api.NotifySettings{}
+	if err := ctx.Bind(&settings); err != nil {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	target := ""
+	if settings.Target != nil {
+		target = strings.TrimSpace(*settings.Target)
+	}
+
+	if err := validateNotifySettings(string(settings.Channel), target); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
+	u, err := a.store.UpdateUserNotifyChannel(ctx.Request().Context(), db.UpdateUserNotifyChannelParams{
+		Appid:         sql.NullString{String: userAppID, Valid: true},
+		NotifyChannel: string(settings.Channel),
+		NotifyTarget:  sql.NullString{String: target, Valid: target != ""},
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.String(http.StatusNotFound, "404 - Not found")
+		}
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	user := createOwnResponseUser(u)
+	return ctx.JSON(http.StatusOK, &user)
+}
+
+// UpdateDigestSettings (PUT /users/{id}/digest)
+func (a *API) UpdateDigestSettings(ctx echo.Context, userAppID string) error {
+
+	if !userHasAccess(ctx, userAppID) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	settings := api.DigestSettings{}
+	if err := ctx.Bind(&settings); err != nil {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	hour, weekday := defaultDigestHour, 0
+	if settings.Hour != nil {
+		hour = *settings.Hour
+	}
+	if settings.Weekday != nil {
+		weekday = *settings.Weekday
+	}
+
+	if err := validateDigestSettings(string(settings.Mode), hour, weekday); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
+	u, err := a.store.UpdateDigestSettings(ctx.Request().Context(), db.UpdateUserDigestParams{
+		Appid:         sql.NullString{String: userAppID, Valid: true},
+		DigestMode:    string(settings.Mode),
+		DigestHour:    int32(hour),
+		DigestWeekday: int32(weekday),
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.String(http.StatusNotFound, "404 - Not found")
+		}
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	user := createOwnResponseUser(u)
 	return ctx.JSON(http.StatusOK, &user)
 }
 
 // GetUserComics (GET users/{id}/comics)
 func (a *API) GetUserComics(ctx echo.Context, userAppID string, params api.GetUserComicsParams) error {
 
-	comicPage := api.ComicPage{Comics: []api.Comic{}}
+	q, limit, offset := listArgs(params.Q, params.Limit, params.Offset)
+	sortBy, descending := sortArgs(params.Sort, params.Order, sortBySubscribed)
+	site := toEnum(params.Site)
 
-	q, _, _ := listArgs(params.Q, params.Limit, params.Offset)
+	comicPage := api.ComicPage{Comics: []api.Comic{}, Limit: limit, Offset: offset}
 
 	if !userHasAccess(ctx, userAppID) {
 		return ctx.NoContent(http.StatusForbidden)
@@ -141,85 +261,109 @@ func (a *API) GetUserComics(ctx echo.Context, userAppID string, params api.GetUs
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
 
-	comics, err := a.store.SearchComicOfUserByName(ctx.Request().Context(), db.SearchComicOfUserByNameParams{
+	total, err := a.store.CountComicsOfUserByName(ctx.Request().Context(), db.CountComicsOfUserByNameParams{
 		UserID: user.ID,
 		Name:   q,
+		Page:   site,
 	})
 	if err != nil {
-		// Return empty list if not found comic
-		if err == sql.ErrNoRows {
-			return ctx.JSON(http.StatusOK, &comicPage)
-		}
 		logging.Danger(err)
-		return ctx.NoContent(http.StatusNotFound)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+	comicPage.Total = int(total)
+
+	// UI pages user's comics by itself, so all of them are returned unless client asks for a page
+	if params.Limit == nil {
+		limit = int(total)
+		comicPage.Limit = limit
+	}
+
+	comics, err := a.store.SearchComicOfUserByName(ctx.Request().Context(), db.SearchComicOfUserByNameParams{
+		UserID:     user.ID,
+		Name:       q,
+		Page:       site,
+		SortBy:     sortBy,
+		Descending: descending,
+		Limit:      int32(limit),
+		Offset:     int32(offset),
+	})
+	if err != nil {
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	unread, err := a.store.ListUnreadChaptersPerUser(ctx.Request().Context(), user.ID)
+	if err != nil {
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	behind := make(map[int32]int, len(unread))
+	for _, u := range unread {
+		behind[u.ComicID] = int(u.Unread)
 	}
 
 	for i := range comics {
 		c := comics[i]
 		comicID := int(c.ID)
+		chaptersBehind := behind[c.ID]
 		comicPage.Comics = append(comicPage.Comics, api.Comic{
-			Id:         &comicID,
-			Page:       &c.Page,
-			Name:       &c.Name,
-			Url:        &c.Url,
-			LatestChap: &c.LatestChap,
-			ImgURL:     &c.CloudImgUrl,
-			ChapURL:    &c.ChapUrl,
+			Id:             &comicID,
+			Page:           &c.Page,
+			Name:           &c.Name,
+			Url:            &c.Url,
+			LatestChap:     &c.LatestChap,
+			ImgURL:         &c.CloudImgUrl,
+			ChapURL:        &c.ChapUrl,
+			ChaptersBehind: &chaptersBehind,
 		})
 	}
 	return ctx.JSON(http.StatusOK, &comicPage)
 }
 
 // SubscribeComic (POST /users/{id}/comics)
-// func (a *API) SubscribeComic(ctx echo.Context, userAppID string) error {
-
-// 	if !userHasAccess(ctx, userAppID) {
-// 		return ctx.NoContent(http.StatusForbidden)
-// 	}
-
-// 	user, err := a.store.GetUserByAppID(ctx.Request().Context(), sql.NullString{String: userAppID, Valid: true})
-// 	if err != nil {
-// 		if err == sql.ErrNoRows {
-// 			return ctx.String(http.StatusNotFound, "Not found")
-// 		}
-// 		logging.Danger(err)
-// 		return ctx.NoContent(http.StatusInternalServerError)
-// 	}
-
-// 	comicURL := ctx.FormValue("comic")
-// 	if comicURL == "" {
-// 		return ctx.NoContent(http.StatusBadRequest)
-// 	}
-
-// 	c, err := a.store.SubscribeComic(ctx.Request().Context(), u.PSID, comicURL)
-// 	if err != nil {
-// 		if err == util.ErrInvalidURL {
-// 			return ctx.NoContent(http.StatusBadRequest)
-// 		}
-// 		return ctx.NoContent(http.StatusInternalServerError)
-// 	}
-
-// 	comicID := int(c.ID)
-// 	comic := api.Comic{
-// 		Id:         &comicID,
-// 		Page:       &c.Page,
-// 		Name:       &c.Name,
-// 		Url:        &c.Url,
-// 		LatestChap: &c.LatestChap,
-// 		ImgURL:     &c.CloudImgUrl,
-// 		ChapURL:    &c.ChapUrl,
-// 	}
-
-// 	return ctx.JSON(http.StatusOK, &comic)
-// }
+func (a *API) SubscribeComic(ctx echo.Context, userAppID string) error {
 
-// UnsubscribeComic (DELETE /users/{user_id}/comics/{id})
-func (a *API) UnsubscribeComic(ctx echo.Context, userAppID string, comicID int) error {
+	if !userHasAccess(ctx, userAppID) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	subscription := api.Subscription{}
+	if err := ctx.Bind(&subscription); err != nil {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	reqCtx := logging.With(ctx.Request().Context(), logging.FieldJob, "subscribe")
+	c, err := a.subscriber.Subscribe(reqCtx, userFieldAppID, userAppID, strings.TrimSpace(subscription.Url))
+	if err != nil {
+		if status, apiErr := subscribeError(err); apiErr != nil {
+			return ctx.JSON(status, apiErr)
+		}
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	comic := createResponseComic(*c)
+	return ctx.JSON(http.StatusOK, &comic)
+}
+
+// UpdateReadProgress (PUT /users/{user_id}/comics/{id})
+func (a *API) UpdateReadProgress(ctx echo.Context, userAppID string, comicID int) error {
 
 	if !userHasAccess(ctx, userAppID) {
 		return ctx.NoContent(http.StatusForbidden)
 	}
 
+	progress := api.ReadProgress{}
+	if err := ctx.Bind(&progress); err != nil {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	chapURL := ""
+	if progress.ChapURL != nil {
+		chapURL = *progress.ChapURL
+	}
+
 	user, err := a.store.GetUserByAppID(ctx.Request().Context(), sql.NullString{String: userAppID, Valid: true})
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -229,36 +373,97 @@ func (a *API) UnsubscribeComic(ctx echo.Context, userAppID string, comicID int)
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
 
-	err = a.store.DeleteSubscriber(ctx.Request().Context(), db.DeleteSubscriberParams{
-		UserID:  user.ID,
-		ComicID: int32(comicID),
-	})
+	_, err = a.store.UpdateReadProgress(ctx.Request().Context(), user.ID, int32(comicID), chapURL)
+	if err != nil {
+		if err == util.ErrNotFound {
+			return ctx.String(http.StatusNotFound, "Not found")
+		}
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	unread, err := a.store.ListUnreadChaptersPerUser(ctx.Request().Context(), user.ID)
+	if err != nil {
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	chaptersBehind := 0
+	for _, u := range unread {
+		if u.ComicID == int32(comicID) {
+			chaptersBehind = int(u.Unread)
+		}
+	}
+
+	c, err := a.store.GetComic(ctx.Request().Context(), int32(comicID))
 	if err != nil {
 		logging.Danger(err)
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
 
-	// Check if no user subscribe to this comic --> remove this comic from DB
-	users, err := a.store.ListComicsPerUser(ctx.Request().Context(), user.ID)
+	comic := api.Comic{
+		Id:             &comicID,
+		Page:           &c.Page,
+		Name:           &c.Name,
+		Url:            &c.Url,
+		LatestChap:     &c.LatestChap,
+		ImgURL:         &c.CloudImgUrl,
+		ChapURL:        &c.ChapUrl,
+		ChaptersBehind: &chaptersBehind,
+	}
+	return ctx.JSON(http.StatusOK, &comic)
+}
+
+// UnsubscribeComic (DELETE /users/{user_id}/comics/{id})
+func (a *API) UnsubscribeComic(ctx echo.Context, userAppID string, comicID int) error {
+
+	if !userHasAccess(ctx, userAppID) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	user, err := a.store.GetUserByAppID(ctx.Request().Context(), sql.NullString{String: userAppID, Valid: true})
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.String(http.StatusNotFound, "Not found")
+		}
 		logging.Danger(err)
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
 
-	if len(users) == 0 {
-		err := a.store.RemoveComic(ctx.Request().Context(), int32(comicID))
-		if err != nil {
-			logging.Danger(err)
-			return ctx.NoContent(http.StatusInternalServerError)
+	// Unsubscribing a comic also stops following its series, comic is removed if nobody subscribes to it anymore
+	err = a.store.UnsubscribeComic(ctx.Request().Context(), user.ID, int32(comicID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.String(http.StatusNotFound, "Not found")
 		}
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
 	}
 
 	return ctx.NoContent(http.StatusOK)
 }
 
+/* ===================== Site ============================ */
+
+// Sites (GET /sites)
+func (a *API) Sites(ctx echo.Context) error {
+
+	sites := []api.SiteHealth{}
+	healths, err := a.store.ListSiteHealth(ctx.Request().Context())
+	if err != nil {
+		logging.Danger(err)
+		return ctx.NoContent(http.StatusInternalServerError)
+	}
+
+	for _, h := range healths {
+		sites = append(sites, createResponseSiteHealth(h))
+	}
+	return ctx.JSON(http.StatusOK, &sites)
+}
+
 func userHasAccess(ctx echo.Context, appID string) bool {
 	user := ctx.Get("user").(*jwt.Token)
-	claims := user.Claims.(*jwt.StandardClaims)
+	claims := user.Claims.(*auth.Claims)
 
 	if claims.Id != appID {
 		return false
@@ -267,6 +472,45 @@ func userHasAccess(ctx echo.Context, appID string) bool {
 	return true
 }
 
+// isAdmin check user is configured as admin. Role in JWT is only a hint for UI, it stays valid until token expires
+// while admins can be removed from config at any time
+func isAdmin(ctx echo.Context) bool {
+	user, ok := ctx.Get("user").(*jwt.Token)
+	if !ok {
+		return false
+	}
+
+	claims, ok := user.Claims.(*auth.Claims)
+	if !ok {
+		return false
+	}
+
+	for _, id := range adminAppIDs {
+		if id == claims.Id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subscribeError return HTTP status and error body of subscribe errors which client can handle, nil for internal errors
+func subscribeError(err error) (int, *api.Error) {
+
+	switch errors.Cause(err) {
+	case util.ErrInvalidURL:
+		return http.StatusBadRequest, &api.Error{Code: api.ErrorCodeInvalidUrl, Message: "Comic link is invalid"}
+	case util.ErrPageNotSupported:
+		return http.StatusUnprocessableEntity, &api.Error{Code: api.ErrorCodeUnsupportedPage, Message: "Comic page is not supported yet"}
+	case util.ErrCrawlTimeout, util.ErrThrottled:
+		return http.StatusGatewayTimeout, &api.Error{Code: api.ErrorCodeCrawlTimeout, Message: "Comic page is too slow, try again later"}
+	case util.ErrAlreadySubscribed:
+		return http.StatusConflict, &api.Error{Code: api.ErrorCodeAlreadySubscribed, Message: "Comic is already subscribed"}
+	}
+
+	return http.StatusInternalServerError, nil
+}
+
 func createResponseUser(u db.User) (responseUser api.User) {
 
 	if u.Psid.Valid {
@@ -288,6 +532,56 @@ func createResponseUser(u db.User) (responseUser api.User) {
 	}
 
 	responseUser.Name = &u.Name
+	responseUser.NotifyChannel = &u.NotifyChannel
+	responseUser.DigestMode = &u.DigestMode
+	digestHour, digestWeekday := int(u.DigestHour), int(u.DigestWeekday)
+	responseUser.DigestHour = &digestHour
+	responseUser.DigestWeekday = &digestWeekday
 	responseUser.Comics = nil
 	return
 }
+
+// createOwnResponseUser return user with notify target, which may be a secret like Discord webhook URL,
+// so it's only sent to its owner
+func createOwnResponseUser(u db.User) api.User {
+
+	responseUser := createResponseUser(u)
+	if u.NotifyTarget.Valid {
+		responseUser.NotifyTarget = &u.NotifyTarget.String
+	}
+
+	return responseUser
+}
+
+func createResponseSiteHealth(h db.SiteHealth) (site api.SiteHealth) {
+
+	crawls := int(h.Crawls)
+	failures := int(h.Failures)
+	consecutiveFailures := int(h.ConsecutiveFailures)
+
+	site.Page = h.Page
+	site.Broken = h.Broken
+	site.Crawls = &crawls
+	site.Failures = &failures
+	site.ConsecutiveFailures = &consecutiveFailures
+
+	if h.BrokenSince.Valid {
+		site.BrokenSince = &h.BrokenSince.Time
+	}
+
+	if h.LastSuccessAt.Valid {
+		site.LastSuccessAt = &h.LastSuccessAt.Time
+	}
+
+	if h.LastError != "" {
+		lastError := api.SiteHealthLastError(h.LastError)
+		site.LastError = &lastError
+	}
+
+	if h.LastErrorAt.Valid {
+		site.LastErrorAt = &h.LastErrorAt.Time
+	}
+
+	site.Disabled = &h.Disabled
+	return
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+)
+
+// defaultDigestHour is delivery hour of digest when user doesn't choose one
+const defaultDigestHour = 20
+
+// digestLocation is where users' digest hour is counted, it's UTC until server is created
+var digestLocation = time.UTC
+
+// nextDigest return first delivery time of user's digest after t
+func nextDigest(user *db.User, t time.Time, loc *time.Location) time.Time {
+
+	local := t.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), int(user.DigestHour), 0, 0, 0, loc)
+
+	days := 1
+	if user.DigestMode == db.DigestWeekly {
+		days = 7
+		next = next.AddDate(0, 0, (int(user.DigestWeekday)-int(next.Weekday())+7)%7)
+	}
+
+	if !next.After(t) {
+		next = next.AddDate(0, 0, days)
+	}
+
+	return next
+}
+
+// digestDelay return time notification is delivered at if user gets digest and it's not time yet. Chapters queued
+// before user's delivery time wait for it, so a digest collects every chapter since the previous one
+func digestDelay(user *db.User, n *db.Notification, now time.Time) (time.Time, bool) {
+
+	if user.DigestMode != db.DigestDaily && user.DigestMode != db.DigestWeekly {
+		return time.Time{}, false
+	}
+
+	at := nextDigest(user, n.CreatedAt, digestLocation)
+	return at, now.Before(at)
+}
+
+// validateDigestSettings verify mode is known, and delivery hour and weekday (0 is Sunday) are in range
+func validateDigestSettings(mode string, hour, weekday int) error {
+
+	switch mode {
+	case db.DigestInstant, db.DigestDaily, db.DigestWeekly:
+	default:
+		return fmt.Errorf("Digest mode %s is not supported", mode)
+	}
+
+	if hour < 0 || hour > 23 {
+		return fmt.Errorf("Digest hour must be from 0 to 23")
+	}
+
+	if weekday < 0 || weekday > 6 {
+		return fmt.Errorf("Digest weekday must be from 0 (Sunday) to 6")
+	}
+
+	return nil
+}
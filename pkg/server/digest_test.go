@@ -0,0 +1,55 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+)
+
+func TestNextDigest(t *testing.T) {
+
+	loc := time.FixedZone("ICT", 7*3600)
+	sunday := time.Date(2026, 10, 18, 10, 0, 0, 0, loc)
+
+	daily := &db.User{DigestMode: db.DigestDaily, DigestHour: 20}
+	require.Equal(t, time.Date(2026, 10, 18, 20, 0, 0, 0, loc), nextDigest(daily, sunday, loc))
+	require.Equal(t, time.Date(2026, 10, 19, 20, 0, 0, 0, loc), nextDigest(daily, sunday.Add(10*time.Hour), loc))
+
+	// Delivery hour is counted in loc, whatever location t is in
+	require.Equal(t, time.Date(2026, 10, 18, 20, 0, 0, 0, loc), nextDigest(daily, sunday.UTC(), loc))
+
+	weekly := &db.User{DigestMode: db.DigestWeekly, DigestHour: 8, DigestWeekday: int32(time.Wednesday)}
+	require.Equal(t, time.Date(2026, 10, 21, 8, 0, 0, 0, loc), nextDigest(weekly, sunday, loc))
+
+	weekly.DigestWeekday = int32(time.Sunday)
+	require.Equal(t, time.Date(2026, 10, 25, 8, 0, 0, 0, loc), nextDigest(weekly, sunday, loc))
+}
+
+func TestDigestDelay(t *testing.T) {
+
+	now := time.Now()
+	n := &db.Notification{CreatedAt: now}
+
+	_, wait := digestDelay(&db.User{DigestMode: db.DigestInstant}, n, now)
+	require.False(t, wait)
+
+	user := &db.User{DigestMode: db.DigestDaily, DigestHour: 20}
+	at, wait := digestDelay(user, n, now)
+	require.True(t, wait)
+	require.Equal(t, nextDigest(user, now, digestLocation), at)
+
+	// Chapters queued before the last delivery time are sent at once
+	_, wait = digestDelay(user, &db.Notification{CreatedAt: now.Add(-25 * time.Hour)}, now)
+	require.False(t, wait)
+}
+
+func TestValidateDigestSettings(t *testing.T) {
+
+	require.Nil(t, validateDigestSettings(db.DigestInstant, 0, 0))
+	require.Nil(t, validateDigestSettings(db.DigestWeekly, 23, 6))
+	require.EqualError(t, validateDigestSettings("monthly", 20, 0), "Digest mode monthly is not supported")
+	require.NotNil(t, validateDigestSettings(db.DigestDaily, 24, 0))
+	require.NotNil(t, validateDigestSettings(db.DigestWeekly, 20, 7))
+}
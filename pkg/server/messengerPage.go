@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/msg"
+)
+
+// messengerPageTTL is how long page settings are cached, so token or settings changed in DB are picked up without restart
+const messengerPageTTL = 5 * time.Minute
+
+// messengerPages cache settings of Messenger pages which bot serves, page missing in DB is served with default page token
+type messengerPages struct {
+	store db.Store
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	pages   map[string]cachedPage
+	forgets int // number of forget calls, settings looked up before a forget are not cached
+}
+
+type cachedPage struct {
+	page      db.MessengerPage
+	found     bool
+	fetchedAt time.Time
+}
+
+// pages is nil until server is created, every message is then served as default page
+var pages *messengerPages
+
+func newMessengerPages(s db.Store, ttl time.Duration) *messengerPages {
+	return &messengerPages{store: s, ttl: ttl, pages: map[string]cachedPage{}}
+}
+
+// get return settings of page id, false if page isn't saved in DB. DB is queried without holding the lock, so a slow
+// lookup of one page doesn't hold back messages of others
+func (p *messengerPages) get(ctx context.Context, id string) (db.MessengerPage, bool) {
+
+	p.mu.Lock()
+	cached, ok := p.pages[id]
+	ttl, forgets := p.ttl, p.forgets
+	p.mu.Unlock()
+
+	if ok && time.Since(cached.fetchedAt) < ttl {
+		return cached.page, cached.found
+	}
+
+	page, err := p.store.GetMessengerPage(ctx, id)
+	if err != nil && err != sql.ErrNoRows {
+		// Keep serving cached settings while DB is not available
+		logging.Ctx(ctx).Danger(err)
+		return cached.page, cached.found
+	}
+
+	p.mu.Lock()
+	if p.forgets == forgets {
+		p.pages[id] = cachedPage{page: page, found: err == nil, fetchedAt: time.Now()}
+	}
+	p.mu.Unlock()
+
+	return page, err == nil
+}
+
+// forget drop cached settings of page id, so its next message reads them from DB
+func (p *messengerPages) forget(id string) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.pages, id)
+	p.forgets++
+}
+
+// messengerPage return settings of page which ctx's message is sent to or received from, false for default page
+func messengerPage(ctx context.Context) (db.MessengerPage, bool) {
+
+	id := msg.PageID(ctx)
+	if id == "" || pages == nil {
+		return db.MessengerPage{}, false
+	}
+
+	return pages.get(ctx, id)
+}
+
+// pageAccessToken return Send API token of ctx's page
+func pageAccessToken(ctx context.Context) string {
+
+	if page, ok := messengerPage(ctx); ok {
+		return page.AccessToken
+	}
+
+	return pageToken
+}
+
+// pageDisabled report whether ctx's page is disabled, messages sent to a disabled page are ignored
+func pageDisabled(ctx context.Context) bool {
+	page, ok := messengerPage(ctx)
+	return ok && page.Disabled
+}
+
+// userContext return ctx for messages sent to user, they must come from the page user talks to since PSID is page-scoped
+func userContext(ctx context.Context, user *db.User) context.Context {
+
+	if !user.MessengerPageID.Valid {
+		return ctx
+	}
+
+	return logging.With(msg.WithPageID(ctx, user.MessengerPageID.String), logging.FieldPageID, user.MessengerPageID.String)
+}
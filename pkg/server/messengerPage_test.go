@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/msg"
+)
+
+// pageStore keep Messenger pages in memory and count DB lookups
+type pageStore struct {
+	db.Store
+	pages   map[string]db.MessengerPage
+	lookups int
+	failing bool
+}
+
+func (s *pageStore) GetMessengerPage(ctx context.Context, id string) (db.MessengerPage, error) {
+
+	s.lookups++
+	if s.failing {
+		return db.MessengerPage{}, errors.New("DB is down")
+	}
+
+	p, ok := s.pages[id]
+	if !ok {
+		return db.MessengerPage{}, sql.ErrNoRows
+	}
+	return p, nil
+}
+
+func TestMessengerPages(t *testing.T) {
+
+	s := &pageStore{pages: map[string]db.MessengerPage{"1": {ID: "1", AccessToken: "token-1"}}}
+	p := newMessengerPages(s, time.Hour)
+
+	page, ok := p.get(context.Background(), "1")
+	require.True(t, ok)
+	require.Equal(t, "token-1", page.AccessToken)
+
+	// Cached settings, including missing pages, are served without DB lookup
+	p.get(context.Background(), "1")
+	_, ok = p.get(context.Background(), "2")
+	require.False(t, ok)
+	p.get(context.Background(), "2")
+	require.Equal(t, 2, s.lookups)
+
+	// Cached settings are kept while DB is down
+	s.pages["1"] = db.MessengerPage{ID: "1", AccessToken: "token-2"}
+	p.ttl = 0
+	s.failing = true
+	page, ok = p.get(context.Background(), "1")
+	require.True(t, ok)
+	require.Equal(t, "token-1", page.AccessToken)
+
+	s.failing = false
+	p.ttl = time.Hour
+	p.forget("1")
+	page, _ = p.get(context.Background(), "1")
+	require.Equal(t, "token-2", page.AccessToken)
+}
+
+// blockingPageStore hold lookup of page "slow" until release is closed
+type blockingPageStore struct {
+	db.Store
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingPageStore) GetMessengerPage(ctx context.Context, id string) (db.MessengerPage, error) {
+	if id == "slow" {
+		close(s.started)
+		<-s.release
+	}
+	return db.MessengerPage{ID: id, AccessToken: "token-" + id}, nil
+}
+
+func TestMessengerPagesSlowLookup(t *testing.T) {
+
+	s := &blockingPageStore{started: make(chan struct{}), release: make(chan struct{})}
+	p := newMessengerPages(s, time.Hour)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.get(context.Background(), "slow")
+	}()
+	<-s.started
+
+	// Other pages are served while one is looked up
+	page, ok := p.get(context.Background(), "fast")
+	require.True(t, ok)
+	require.Equal(t, "token-fast", page.AccessToken)
+
+	// Settings looked up before they're forgotten are not cached
+	p.forget("slow")
+	close(s.release)
+	<-done
+	require.NotContains(t, p.pages, "slow")
+}
+
+func TestPageAccessToken(t *testing.T) {
+
+	defer func(p *messengerPages, token string) { pages, pageToken = p, token }(pages, pageToken)
+	pageToken = "default"
+	pages = newMessengerPages(&pageStore{pages: map[string]db.MessengerPage{
+		"1": {ID: "1", AccessToken: "token-1"},
+		"2": {ID: "2", AccessToken: "token-2", Disabled: true},
+	}}, time.Hour)
+
+	require.Equal(t, "default", pageAccessToken(context.Background()))
+	require.Equal(t, "default", pageAccessToken(msg.WithPageID(context.Background(), "3")))
+	require.Equal(t, "token-1", pageAccessToken(msg.WithPageID(context.Background(), "1")))
+	require.False(t, pageDisabled(msg.WithPageID(context.Background(), "1")))
+	require.True(t, pageDisabled(msg.WithPageID(context.Background(), "2")))
+
+	// Notifications are sent from the page user talks to
+	user := &db.User{MessengerPageID: sql.NullString{String: "1", Valid: true}}
+	require.Equal(t, "token-1", pageAccessToken(userContext(context.Background(), user)))
+	require.Equal(t, "default", pageAccessToken(userContext(context.Background(), &db.User{})))
+}
+
+func TestSubscribeFromPage(t *testing.T) {
+
+	s := newFakeStore(1, 0)
+	sv := NewSubscribeService(s, &fakeCrawler{crawls: map[string]int{}, maxChap: 2})
+
+	_, err := sv.Subscribe(msg.WithPageID(context.Background(), "1"), userFieldPSID, "psid-1", "https://test.vn/comic-1")
+	require.Nil(t, err)
+	require.Len(t, s.users, 1)
+	require.Equal(t, sql.NullString{String: "1", Valid: true}, s.users[0].MessengerPageID)
+}
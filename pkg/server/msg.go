@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/asaskevich/govalidator"
 	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
@@ -14,16 +13,21 @@ import (
 	"github.com/tinoquang/comic-notifier/pkg/util"
 )
 
+const (
+	maxUnreadLines   = 20
+	minSearchKeyword = 2 // shorter text is not searched
+)
+
 // MSG -> server handler for messenger endpoint
 type MSG struct {
-	sync.Mutex
-	store   db.Store
-	crawler infoCrawler
+	store      db.Store
+	crawler    infoCrawler
+	subscriber *SubscribeService
 }
 
 // NewMSG return new api interface
-func NewMSG(s db.Store, crwl infoCrawler) *MSG {
-	return &MSG{store: s, crawler: crwl}
+func NewMSG(s db.Store, crwl infoCrawler, subscriber *SubscribeService) *MSG {
+	return &MSG{store: s, crawler: crwl, subscriber: subscriber}
 }
 
 /* Message handler function */
@@ -31,9 +35,14 @@ func NewMSG(s db.Store, crwl infoCrawler) *MSG {
 // HandleTxtMsg handle text messages from facebook user
 func (m *MSG) HandleTxtMsg(ctx context.Context, senderID, text string) {
 
-	sendActionBack(senderID, "mark_seen")
-	sendActionBack(senderID, "typing_on")
-	defer sendActionBack(senderID, "typing_off")
+	if pageDisabled(ctx) {
+		logging.Ctx(ctx).Info("Page is disabled, message is ignored")
+		return
+	}
+
+	sendActionBack(ctx, senderID, "mark_seen")
+	sendActionBack(ctx, senderID, "typing_on")
+	defer sendActionBack(ctx, senderID, "typing_off")
 
 	if text[0] == '/' {
 		m.responseCommand(ctx, senderID, text)
@@ -41,43 +50,24 @@ func (m *MSG) HandleTxtMsg(ctx context.Context, senderID, text string) {
 	}
 
 	if valid := govalidator.IsURL(text); !valid {
-		sendTextBack(senderID, "Cú pháp chưa chính xác")
-		m.responseCommand(ctx, senderID, "")
+		m.responseSearch(ctx, senderID, text)
 		return
 	}
 
-	comic, err := m.SubscribeComic(ctx, senderID, text)
-	if err != nil {
-		if err == util.ErrAlreadySubscribed {
-			sendTextBack(senderID, fmt.Sprintf("%s đã được đăng ký, BOT sẽ thông báo cho bạn khi có chương mới", comic.Name))
-		} else if strings.Contains(err.Error(), "too fast") || err == util.ErrCrawlTimeout {
-			// Upload image API is busy
-			sendTextBack(senderID, "Đăng ký không thành công, hãy thử lại sau nhé!") // handle later: get time delay and send back to user
-		} else if err == util.ErrPageNotSupported {
-			sendTextBack(senderID, "Trang truyện này chưa được hỗ trợ, dùng lệnh /page để xem các trang tôi hỗ trợ")
-			m.responseCommand(ctx, senderID, "/page")
-		} else if err == util.ErrInvalidURL {
-			sendTextBack(senderID, "Đường dẫn chưa chính xác, hãy xem qua hướng dẫn bằng lệnh /tutor")
-		} else {
-			sendTextBack(senderID, "Đăng ký không thành công, hãy thử lại sau nhé")
-		}
-		return
-	}
-
-	// send back message in template with bDnDwauttons
-	sendTextBack(senderID, fmt.Sprintf("Đăng ký truyện %s thành công", comic.Name))
-	sendActionBack(senderID, "typing_on")
-	delayMS(500)
-	sendNormalReply(senderID, comic)
-
+	m.responseSubscribe(ctx, senderID, text)
 }
 
 // HandlePostback handle messages when user click "Unsubsribe button"
 func (m *MSG) HandlePostback(ctx context.Context, senderID, payload string) {
 
-	sendActionBack(senderID, "mark_seen")
-	sendActionBack(senderID, "typing_on")
-	defer sendActionBack(senderID, "typing_off")
+	if pageDisabled(ctx) {
+		logging.Ctx(ctx).Info("Page is disabled, message is ignored")
+		return
+	}
+
+	sendActionBack(ctx, senderID, "mark_seen")
+	sendActionBack(ctx, senderID, "typing_on")
+	defer sendActionBack(ctx, senderID, "typing_off")
 
 	if strings.Contains(payload, "get-started") {
 		m.reponseGetStarted(ctx, senderID)
@@ -89,6 +79,21 @@ func (m *MSG) HandlePostback(ctx context.Context, senderID, payload string) {
 		return
 	}
 
+	if strings.HasPrefix(payload, readPayloadPrefix) {
+		m.responseRead(ctx, senderID, payload)
+		return
+	}
+
+	if strings.HasPrefix(payload, subscribePayloadPrefix) {
+		m.responseSubscribe(ctx, senderID, strings.TrimPrefix(payload, subscribePayloadPrefix))
+		return
+	}
+
+	if strings.HasPrefix(payload, seriesPayloadPrefix) {
+		m.responseSubscribeSeries(ctx, senderID, strings.TrimPrefix(payload, seriesPayloadPrefix))
+		return
+	}
+
 	comicID, _ := strconv.Atoi(payload)
 	comic, err := m.store.GetComicByPSIDAndComicID(ctx, db.GetComicByPSIDAndComicIDParams{
 		Psid: sql.NullString{String: senderID, Valid: true},
@@ -96,27 +101,32 @@ func (m *MSG) HandlePostback(ctx context.Context, senderID, payload string) {
 	})
 	if err != nil {
 		if err == sql.ErrNoRows {
-			sendTextBack(senderID, "Truyện chưa được đăng ký")
+			sendTextBack(ctx, senderID, "Truyện chưa được đăng ký")
 			return
 		}
 
-		logging.Danger(err)
-		sendTextBack(senderID, "Hiện tại server đang busy, bạn hãy đợi một lát rồi thử lại nhé")
+		logging.Ctx(ctx).Danger(err)
+		sendTextBack(ctx, senderID, "Hiện tại server đang busy, bạn hãy đợi một lát rồi thử lại nhé")
 		return
 	}
 
-	sendQuickReplyChoice(senderID, comic)
+	sendQuickReplyChoice(ctx, senderID, comic)
 }
 
 // HandleQuickReply handle messages when user click "Yes" to confirm unsubscribe action
 func (m *MSG) HandleQuickReply(ctx context.Context, senderID, payload string) {
 
-	sendActionBack(senderID, "mark_seen")
-	sendActionBack(senderID, "typing_on")
-	defer sendActionBack(senderID, "typing_off")
+	if pageDisabled(ctx) {
+		logging.Ctx(ctx).Info("Page is disabled, message is ignored")
+		return
+	}
+
+	sendActionBack(ctx, senderID, "mark_seen")
+	sendActionBack(ctx, senderID, "typing_on")
+	defer sendActionBack(ctx, senderID, "typing_off")
 
 	if payload == "Not unsub" {
-		sendActionBack(senderID, "mark_seen")
+		sendActionBack(ctx, senderID, "mark_seen")
 		return
 	}
 
@@ -129,8 +139,8 @@ func (m *MSG) HandleQuickReply(ctx context.Context, senderID, payload string) {
 
 	user, err := m.store.GetUserByPSID(ctx, sql.NullString{String: senderID, Valid: true})
 	if err != nil {
-		logging.Danger(err)
-		sendTextBack(senderID, "Truyện chưa được đăng ký")
+		logging.Ctx(ctx).Danger(err)
+		sendTextBack(ctx, senderID, "Truyện chưa được đăng ký")
 		return
 	}
 
@@ -139,33 +149,20 @@ func (m *MSG) HandleQuickReply(ctx context.Context, senderID, payload string) {
 		ID:   int32(comicID),
 	})
 	if err != nil {
-		logging.Danger(err)
-		sendTextBack(senderID, "Truyện chưa được đăng ký")
+		logging.Ctx(ctx).Danger(err)
+		sendTextBack(ctx, senderID, "Truyện chưa được đăng ký")
 		return
 	}
 
-	err = m.store.DeleteSubscriber(ctx, db.DeleteSubscriberParams{
-		UserID:  user.ID,
-		ComicID: int32(comicID),
-	})
-
+	// Unsubscribing a comic also stops following its series, comic is removed if nobody subscribes to it anymore
+	err = m.store.UnsubscribeComic(ctx, user.ID, c.ID)
 	if err != nil {
-		sendTextBack(senderID, "Hiện tại server đang busy, bạn hãy đợi một lát rồi thử lại nhé")
+		logging.Ctx(ctx).Danger(err)
+		sendTextBack(ctx, senderID, "Hiện tại server đang busy, bạn hãy đợi một lát rồi thử lại nhé")
 		return
 	}
 
-	// Check if any user still subscribed to this comic, if not remove comic from DB
-	users, err := m.store.ListUsersPerComic(ctx, c.ID)
-	if err != nil {
-		logging.Danger(err)
-		sendTextBack(senderID, "Hiện tại server đang busy, bạn hãy đợi một lát rồi thử lại nhé")
-		return
-	}
-
-	if len(users) == 0 {
-		m.store.RemoveComic(ctx, c.ID)
-	}
-	sendTextBack(senderID, fmt.Sprintf("Hủy đăng ký %s thành công", c.Name))
+	sendTextBack(ctx, senderID, fmt.Sprintf("Hủy đăng ký %s thành công", c.Name))
 
 }
 
@@ -175,111 +172,291 @@ func (m *MSG) responseCommand(ctx context.Context, senderID, text string) {
 	case "/list":
 		user, err := m.store.GetUserByPSID(ctx, sql.NullString{String: senderID, Valid: true})
 		if err != nil {
-			sendTutor(senderID)
+			sendTutor(ctx, senderID)
 			return
 		}
 
 		comics, err := m.store.ListComicsPerUser(ctx, user.ID)
 		if err != nil || len(comics) == 0 {
-			sendTutor(senderID)
+			sendTutor(ctx, senderID)
 		} else {
-			sendTextBack(senderID, fmt.Sprintf("Bạn đã đăng ký nhận thông báo cho %d truyện", len(comics)))
-			sendTextBack(senderID, `Xem chi tiết tại
+			sendTextBack(ctx, senderID, fmt.Sprintf("Bạn đã đăng ký nhận thông báo cho %d truyện", len(comics)))
+			if unread := m.unreadSummary(ctx, user.ID, comics); unread != "" {
+				sendTextBack(ctx, senderID, unread)
+			}
+			sendTextBack(ctx, senderID, `Xem chi tiết tại
 www.cominify-bot.xyz`)
 		}
 	case "/page":
-		sendTextBack(senderID, `Các trạng hiện tại tôi hỗ trợ:
+		sendTextBack(ctx, senderID, `Các trạng hiện tại tôi hỗ trợ:
 beeng.net
 blogtruyen.vn
 truyentranhtuan.com
 truyenqq.com
 hocvientruyentranh.net`)
 	case "/tutor":
-		sendTextBack(senderID, "Để đăng kí, chỉ cần gởi cho BOT link truyện bạn muốn nhận thông báo")
-		sendTextBack(senderID, "Ví dụ bạn muốn đăng ký truyện One Piece ở trang blogtruyen.vn, hãy gởi cho BOT đường link sau:")
-		sendTextBack(senderID, "https://blogtruyen.vn/139/one-piece")
-		sendTextBack(senderID, `Hãy thử copy đường link trên và gởi cho BOT, nếu vẫn chưa rõ bạn có thể xem hướng dẫn tại
+		sendTextBack(ctx, senderID, "Để đăng kí, chỉ cần gởi cho BOT link truyện bạn muốn nhận thông báo")
+		sendTextBack(ctx, senderID, "Ví dụ bạn muốn đăng ký truyện One Piece ở trang blogtruyen.vn, hãy gởi cho BOT đường link sau:")
+		sendTextBack(ctx, senderID, "https://blogtruyen.vn/139/one-piece")
+		sendTextBack(ctx, senderID, `Hãy thử copy đường link trên và gởi cho BOT, nếu vẫn chưa rõ bạn có thể xem hướng dẫn tại
 www.cominify-bot.xyz/tutorial`)
+		sendTextBack(ctx, senderID, "Hoặc gởi tên truyện, BOT sẽ tìm truyện ở các trang được hỗ trợ để bạn chọn đăng ký")
 	default:
-		sendSupportCommand(senderID)
+		sendSupportCommand(ctx, senderID)
 	}
 
 	return
 }
 
+// responseSubscribe subscribe user to comic and reply the result
+func (m *MSG) responseSubscribe(ctx context.Context, senderID, comicURL string) {
+
+	comic, err := m.SubscribeComic(ctx, senderID, comicURL)
+	if err != nil {
+		m.responseSubscribeError(ctx, senderID, comic, err)
+		return
+	}
+
+	// send back message in template with bDnDwauttons
+	sendTextBack(ctx, senderID, fmt.Sprintf("Đăng ký truyện %s thành công", comic.Name))
+	sendActionBack(ctx, senderID, "typing_on")
+	delayMS(500)
+	sendNormalReply(ctx, senderID, comic)
+}
+
+// responseSubscribeSeries subscribe user to comic and its sources on other sites, then reply the result
+func (m *MSG) responseSubscribeSeries(ctx context.Context, senderID, comicURL string) {
+
+	series, sources, err := m.SubscribeSeries(ctx, senderID, comicURL)
+	if err == util.ErrAlreadySubscribed {
+		sendTextBack(ctx, senderID, fmt.Sprintf("Bạn đã theo dõi %s trên mọi trang", series.Name))
+		return
+	}
+	if err != nil {
+		m.responseSubscribeError(ctx, senderID, nil, err)
+		return
+	}
+
+	sendTextBack(ctx, senderID, fmt.Sprintf("Đã theo dõi %s trên %d trang, BOT sẽ thông báo chương mới từ trang đăng sớm nhất", series.Name, sources))
+}
+
+func (m *MSG) responseSubscribeError(ctx context.Context, senderID string, comic *db.Comic, err error) {
+
+	if err == util.ErrAlreadySubscribed && comic != nil {
+		sendTextBack(ctx, senderID, fmt.Sprintf("%s đã được đăng ký, BOT sẽ thông báo cho bạn khi có chương mới", comic.Name))
+	} else if strings.Contains(err.Error(), "too fast") || err == util.ErrCrawlTimeout || err == util.ErrThrottled {
+		// Upload image API is busy
+		sendTextBack(ctx, senderID, "Đăng ký không thành công, hãy thử lại sau nhé!") // handle later: get time delay and send back to user
+	} else if err == util.ErrPageNotSupported {
+		sendTextBack(ctx, senderID, "Trang truyện này chưa được hỗ trợ, dùng lệnh /page để xem các trang tôi hỗ trợ")
+		m.responseCommand(ctx, senderID, "/page")
+	} else if err == util.ErrInvalidURL {
+		sendTextBack(ctx, senderID, "Đường dẫn chưa chính xác, hãy xem qua hướng dẫn bằng lệnh /tutor")
+	} else {
+		sendTextBack(ctx, senderID, "Đăng ký không thành công, hãy thử lại sau nhé")
+	}
+}
+
+// responseSearch look up comic by name in supported sites and send matches back as a carousel,
+// each match has "Đăng ký" button to subscribe
+func (m *MSG) responseSearch(ctx context.Context, senderID, keyword string) {
+
+	keyword = strings.TrimSpace(keyword)
+	if len([]rune(keyword)) < minSearchKeyword {
+		sendTextBack(ctx, senderID, "Cú pháp chưa chính xác")
+		m.responseCommand(ctx, senderID, "")
+		return
+	}
+
+	comics, err := m.crawler.SearchComic(ctx, keyword)
+	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+		sendTextBack(ctx, senderID, "Tìm kiếm không thành công, hãy thử lại sau nhé")
+		return
+	}
+
+	if len(comics) == 0 {
+		sendTextBack(ctx, senderID, fmt.Sprintf("Không tìm thấy truyện %s, bạn có thể gởi link truyện để đăng ký", keyword))
+		return
+	}
+
+	sendSearchResults(ctx, senderID, comics)
+}
+
+// responseRead mark chapter in "Đã đọc" button's payload as user's last read chapter
+func (m *MSG) responseRead(ctx context.Context, senderID, payload string) {
+
+	fields := strings.SplitN(strings.TrimPrefix(payload, readPayloadPrefix), ":", 2)
+	if len(fields) != 2 {
+		logging.Ctx(ctx).Warning("Invalid read payload", payload)
+		return
+	}
+	comicID, _ := strconv.Atoi(fields[0])
+
+	user, err := m.store.GetUserByPSID(ctx, sql.NullString{String: senderID, Valid: true})
+	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+		sendTextBack(ctx, senderID, "Truyện chưa được đăng ký")
+		return
+	}
+
+	chap, err := m.store.UpdateReadProgress(ctx, user.ID, int32(comicID), fields[1])
+	if err != nil {
+		if err == util.ErrNotFound {
+			sendTextBack(ctx, senderID, "Truyện chưa được đăng ký")
+			return
+		}
+
+		logging.Ctx(ctx).Danger(err)
+		sendTextBack(ctx, senderID, "Hiện tại server đang busy, bạn hãy đợi một lát rồi thử lại nhé")
+		return
+	}
+
+	sendTextBack(ctx, senderID, fmt.Sprintf("Đã đánh dấu đọc %s", chap.Name))
+}
+
+// unreadSummary list comics which user has unread chapters
+func (m *MSG) unreadSummary(ctx context.Context, userID int32, comics []db.Comic) string {
+
+	unread, err := m.store.ListUnreadChaptersPerUser(ctx, userID)
+	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+		return ""
+	}
+
+	if len(unread) == 0 {
+		return "Bạn đã đọc hết các chương mới"
+	}
+
+	behind := make(map[int32]int64, len(unread))
+	for _, u := range unread {
+		behind[u.ComicID] = u.Unread
+	}
+
+	// Keep message short, Messenger limits text message to 2000 characters
+	lines := []string{"Các truyện bạn chưa đọc:"}
+	for _, c := range comics {
+		if len(lines) > maxUnreadLines {
+			lines = append(lines, "...")
+			break
+		}
+
+		if behind[c.ID] != 0 {
+			lines = append(lines, fmt.Sprintf("- %s: %d chương", c.Name, behind[c.ID]))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 func (m *MSG) reponseGetStarted(ctx context.Context, senderID string) {
 
-	sendTextBack(senderID, "Welcome to Comic Notify Bot!")
-	sendTextBack(senderID, "Tôi là chatbot giúp theo dõi truyện tranh và thông báo mỗi khi truyện có chapter mới")
-	sendSupportCommand(senderID)
+	greeting := "Welcome to Comic Notify Bot!"
+	if page, ok := messengerPage(ctx); ok && page.Greeting != "" {
+		greeting = page.Greeting
+	}
+
+	sendTextBack(ctx, senderID, greeting)
+	sendTextBack(ctx, senderID, "Tôi là chatbot giúp theo dõi truyện tranh và thông báo mỗi khi truyện có chapter mới")
+	sendSupportCommand(ctx, senderID)
 	return
 }
 
-// SubscribeComic add comic and user to DB
+// SubscribeComic add comic and Messenger user to DB
 func (m *MSG) SubscribeComic(ctx context.Context, userPSID, comicURL string) (*db.Comic, error) {
+	return m.subscriber.Subscribe(ctx, userFieldPSID, userPSID, comicURL)
+}
 
-	var (
-		err   error
-		comic db.Comic
-		user  db.User
-	)
+// SubscribeSeries subscribe user to comic and follow its series, comics with same title on other supported sites
+// are added as sources so user is notified from whichever source publishes a chapter first.
+// Return series and its number of sources
+func (m *MSG) SubscribeSeries(ctx context.Context, userPSID, comicURL string) (*db.Series, int, error) {
+
+	comic, err := m.SubscribeComic(ctx, userPSID, comicURL)
+	if err != nil && err != util.ErrAlreadySubscribed {
+		return nil, 0, err
+	}
 
-	m.Lock()
-	defer m.Unlock()
-	comic, err = m.store.GetComicByURL(ctx, comicURL)
+	// Series is assigned when comic is subscribed
+	c, err := m.store.GetComic(ctx, comic.ID)
 	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+		return nil, 0, err
+	}
+	if !c.SeriesID.Valid {
+		return nil, 0, util.ErrNotFound
+	}
 
-		if err != sql.ErrNoRows {
-			logging.Danger(err)
-			return nil, err
-		}
-		// Comic is not in DB, need to get it's info using crawler pkg
-		comic, err = m.crawler.GetComicInfo(ctx, comicURL, false)
-		if err != nil {
-			logging.Danger(err)
-			return nil, err
-		}
+	series, err := m.store.GetSeries(ctx, c.SeriesID.Int32)
+	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+		return nil, 0, err
 	}
 
-	// Verify comic again to avoid multiple URL represents same comic, by checking Page + Comic Name
-	c, err := m.store.GetComicByPageAndComicName(ctx, db.GetComicByPageAndComicNameParams{
-		Page: comic.Page,
-		Name: comic.Name,
-	})
+	user, err := m.store.GetUserByPSID(ctx, sql.NullString{String: userPSID, Valid: true})
+	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+		return nil, 0, err
+	}
 
-	if err == nil {
-		comic.ID = c.ID
+	_, err = m.store.CreateSeriesSubscriber(ctx, db.CreateSeriesSubscriberParams{
+		UserID:   user.ID,
+		SeriesID: series.ID,
+	})
+	if err != nil {
+		if db.IsUniqueViolation(err, db.SeriesSubscriberUniqueKey) {
+			return &series, 0, util.ErrAlreadySubscribed
+		}
+		logging.Ctx(ctx).Danger(err)
+		return nil, 0, err
 	}
 
-	user, err = m.store.GetUserByPSID(ctx, sql.NullString{String: userPSID, Valid: true})
+	return &series, m.addSeriesSources(ctx, &series), nil
+}
+
+// addSeriesSources search supported sites for comics with same normalized title as series and save them as its sources,
+// return number of series' sources
+func (m *MSG) addSeriesSources(ctx context.Context, series *db.Series) int {
+
+	results, err := m.crawler.SearchComic(ctx, series.Name)
 	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+	}
 
-		if err != sql.ErrNoRows {
-			logging.Danger(err)
-			return nil, err
+	for _, r := range results {
+		if util.NormalizeTitle(r.Name) != series.NormalizedName {
+			continue
 		}
 
-		user, err = m.crawler.GetUserInfoFromFacebook("psid", userPSID)
+		// Comic is already saved or DB is not available
+		if _, err := m.store.GetComicByURL(ctx, r.Url); err != sql.ErrNoRows {
+			continue
+		}
+
+		comic, chapters, err := m.crawler.GetComicInfo(ctx, r.Url)
 		if err != nil {
-			logging.Danger(err)
-			return nil, err
+			logging.Ctx(ctx).Danger(err)
+			continue
 		}
-	}
 
-	_, err = m.store.GetSubscriber(ctx, db.GetSubscriberParams{
-		UserID:  user.ID,
-		ComicID: comic.ID,
-	})
+		// Search result may show a title different from comic page
+		if util.NormalizeTitle(comic.Name) != series.NormalizedName {
+			continue
+		}
 
-	if err == nil {
-		return &comic, util.ErrAlreadySubscribed
+		err = m.store.AddSeriesSource(ctx, &comic, chapters, series.ID)
+		if err != nil {
+			logging.Ctx(ctx).Danger(err)
+			continue
+		}
+		logging.Ctx(ctx).Info("Comic", comic.ID, "-", comic.Page, "is added as a source of series", series.ID, "-", series.Name)
 	}
 
-	if err != sql.ErrNoRows {
-		logging.Danger(err)
-		return nil, err
+	comics, err := m.store.ListComicsPerSeries(ctx, sql.NullInt32{Int32: series.ID, Valid: true})
+	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+		return 1
 	}
 
-	err = m.store.SubscribeComic(ctx, &comic, &user)
-	return &comic, err
+	return len(comics)
 }
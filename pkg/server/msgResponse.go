@@ -1,21 +1,20 @@
 package server
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"strconv"
 	"time"
 
-	"github.com/pkg/errors"
 	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
 	"github.com/tinoquang/comic-notifier/pkg/logging"
 )
 
 /* -------------Message response format----------- */
 
+// maxTemplateElements is the most elements Messenger accepts in one generic template
+const maxTemplateElements = 10
+
 // Response : general response format
 type Response struct {
 	Type      string   `json:"messaging_type,omitempty"`
@@ -82,16 +81,37 @@ type User struct {
 	ID string `json:"id,omitempty"`
 }
 
+// readPayloadPrefix mark postback sent by "Đã đọc" button, payload format is read:<comicID>:<chapURL>
+const readPayloadPrefix = "read:"
+
+func readPayload(comic *db.Comic) string {
+	return fmt.Sprintf("%s%d:%s", readPayloadPrefix, comic.ID, comic.ChapUrl)
+}
+
+// subscribePayloadPrefix mark postback sent by "Đăng ký" button of search results, payload format is subscribe:<comicURL>
+const subscribePayloadPrefix = "subscribe:"
+
+func subscribePayload(comic *db.Comic) string {
+	return subscribePayloadPrefix + comic.Url
+}
+
+// seriesPayloadPrefix mark postback sent by "Theo dõi mọi trang" button of search results, payload format is series:<comicURL>
+const seriesPayloadPrefix = "series:"
+
+func seriesPayload(comic *db.Comic) string {
+	return seriesPayloadPrefix + comic.Url
+}
+
 func delayMS(second int) {
 	time.Sleep(time.Duration(second) * time.Millisecond)
 }
-func sendTextBack(senderID, message string) {
+func sendTextBack(ctx context.Context, senderID, message string) {
 
-	sendActionBack(senderID, "mark_seen")
-	sendActionBack(senderID, "typing_on")
+	sendActionBack(ctx, senderID, "mark_seen")
+	sendActionBack(ctx, senderID, "typing_on")
 	delayMS(1000)
 
-	defer sendActionBack(senderID, "typing_off")
+	defer sendActionBack(ctx, senderID, "typing_off")
 
 	res := &Response{
 		Type:      "RESPONSE",
@@ -99,10 +119,10 @@ func sendTextBack(senderID, message string) {
 		Message:   &RespMsg{Text: message},
 	}
 
-	callSendAPI(res)
+	callSendAPI(ctx, res)
 }
 
-func sendActionBack(senderID, action string) {
+func sendActionBack(ctx context.Context, senderID, action string) {
 
 	res := &Response{
 		Type:      "RESPONSE",
@@ -110,10 +130,10 @@ func sendActionBack(senderID, action string) {
 		Action:    action,
 	}
 
-	callSendAPI(res)
+	callSendAPI(ctx, res)
 }
 
-func sendTutor(senderID string) {
+func sendTutor(ctx context.Context, senderID string) {
 	response := &Response{
 		Recipient: &User{ID: senderID},
 		Type:      "RESPONSE",
@@ -128,10 +148,10 @@ func sendTutor(senderID string) {
 			},
 		},
 	}
-	callSendAPI(response)
+	callSendAPI(ctx, response)
 }
 
-func sendSupportCommand(senderID string) {
+func sendSupportCommand(ctx context.Context, senderID string) {
 
 	response := &Response{
 		Recipient: &User{ID: senderID},
@@ -161,11 +181,11 @@ func sendSupportCommand(senderID string) {
 			},
 		},
 	}
-	callSendAPI(response)
+	callSendAPI(ctx, response)
 }
 
 // Use to send message within 24-hour window of FACEBOOK policy
-func sendNormalReply(senderID string, comic *db.Comic) {
+func sendNormalReply(ctx context.Context, senderID string, comic *db.Comic) {
 
 	response := &Response{
 		Recipient: &User{ID: senderID},
@@ -189,6 +209,11 @@ func sendNormalReply(senderID string, comic *db.Comic) {
 									URL:   comic.ChapUrl,
 									Title: "Đọc chap mới",
 								},
+								{
+									Type:    "postback",
+									Title:   "Đã đọc",
+									Payload: readPayload(comic),
+								},
 								{
 									Type:    "postback",
 									Title:   "Hủy đăng ký",
@@ -202,41 +227,100 @@ func sendNormalReply(senderID string, comic *db.Comic) {
 		},
 	}
 
-	callSendAPI(response)
+	callSendAPI(ctx, response)
 }
 
-func sendMsgTagsReply(senderID string, comic *db.Comic) error {
+// sendSearchResults send comics found by search as a carousel, Messenger shows at most 10 elements
+func sendSearchResults(ctx context.Context, senderID string, comics []db.Comic) {
+
+	elements := []Element{}
+	for i := range comics {
+		elements = append(elements, Element{
+			Title:    comics[i].Name,
+			ImgURL:   comics[i].ImgUrl,
+			Subtitle: comics[i].Page,
+			DefaultAction: &Action{
+				Type: "web_url",
+				URL:  comics[i].Url,
+			},
+			Buttons: []Button{
+				{
+					Type:  "web_url",
+					URL:   comics[i].Url,
+					Title: "Xem truyện",
+				},
+				{
+					Type:    "postback",
+					Title:   "Đăng ký",
+					Payload: subscribePayload(&comics[i]),
+				},
+				{
+					Type:    "postback",
+					Title:   "Theo dõi mọi trang",
+					Payload: seriesPayload(&comics[i]),
+				},
+			},
+		})
+	}
 
 	response := &Response{
 		Recipient: &User{ID: senderID},
+		Type:      "RESPONSE",
 		Message: &RespMsg{
 			Template: &Attachment{
 				Type: "template",
 				Payloads: &Payload{
 					TemplateType: "generic",
-					Elements: []Element{
-						{
-							Title:    comic.Name + "\n" + comic.LatestChap,
-							ImgURL:   comic.CloudImgUrl,
-							Subtitle: comic.Page,
-							DefaultAction: &Action{
-								Type: "web_url",
-								URL:  comic.ChapUrl,
-							},
-							Buttons: []Button{
-								{
-									Type:  "web_url",
-									URL:   comic.ChapUrl,
-									Title: "Đọc chap mới",
-								},
-								{
-									Type:    "postback",
-									Title:   "Hủy đăng ký",
-									Payload: strconv.Itoa(int(comic.ID)),
-								},
-							},
-						},
-					},
+					Elements:     elements,
+				},
+			},
+		},
+	}
+
+	callSendAPI(ctx, response)
+}
+
+// sendMsgTagsReply send new chapters of comics as one carousel, caller keeps it within maxTemplateElements
+func sendMsgTagsReply(ctx context.Context, senderID string, comics []db.Comic) error {
+
+	elements := []Element{}
+	for i := range comics {
+		elements = append(elements, Element{
+			Title:    comics[i].Name + "\n" + comics[i].LatestChap,
+			ImgURL:   comics[i].CloudImgUrl,
+			Subtitle: comics[i].Page,
+			DefaultAction: &Action{
+				Type: "web_url",
+				URL:  comics[i].ChapUrl,
+			},
+			Buttons: []Button{
+				{
+					Type:  "web_url",
+					URL:   comics[i].ChapUrl,
+					Title: "Đọc chap mới",
+				},
+				{
+					Type:    "postback",
+					Title:   "Đã đọc",
+					Payload: readPayload(&comics[i]),
+				},
+				{
+					Type:    "postback",
+					Title:   "Hủy đăng ký",
+					Payload: strconv.Itoa(int(comics[i].ID)),
+				},
+			},
+		})
+	}
+
+	response := &Response{
+		Recipient: &User{ID: senderID},
+		Message: &RespMsg{
+			Template: &Attachment{
+				Type: "template",
+				Payloads: &Payload{
+					TemplateType: "generic",
+					Elements:     elements,
 				},
 			},
 		},
@@ -244,16 +328,32 @@ func sendMsgTagsReply(senderID string, comic *db.Comic) error {
 		Tag:  "CONFIRMED_EVENT_UPDATE",
 	}
 
-	err := callSendAPI(response)
+	err := callSendAPI(ctx, response)
 
 	if err != nil {
-		logging.Danger(fmt.Sprintf("Can't send update notify for comic %s to user %s", comic.Name, senderID))
+		logging.Ctx(ctx).Danger(fmt.Sprintf("Can't send update notify for %d comic(s) to user %s", len(comics), senderID))
 	}
 
 	return err
 }
 
-func sendQuickReplyChoice(senderID string, comic db.Comic) {
+// sendMsgTagsText send plain text outside of 24h messaging window, used for alerts which user didn't ask for
+func sendMsgTagsText(ctx context.Context, senderID, message string) error {
+
+	return callSendAPI(ctx, &Response{
+		Recipient: &User{ID: senderID},
+		Message:   &RespMsg{Text: message},
+		Type:      "MESSAGE_TAG",
+		Tag:       "ACCOUNT_UPDATE",
+	})
+}
+
+// sendUserText send message tagged text to user from the page user talks to
+func sendUserText(ctx context.Context, user *db.User, message string) error {
+	return sendMsgTagsText(userContext(ctx, user), user.Psid.String, message)
+}
+
+func sendQuickReplyChoice(ctx context.Context, senderID string, comic db.Comic) {
 
 	// send back quick reply "Are you sure ?" for user to confirm
 	response := &Response{
@@ -277,58 +377,16 @@ func sendQuickReplyChoice(senderID string, comic db.Comic) {
 			},
 		},
 	}
-	callSendAPI(response)
+	callSendAPI(ctx, response)
 }
 
-func callSendAPI(r *Response) error {
-
-	body := new(bytes.Buffer)
-	encoder := json.NewEncoder(body)
+// callSendAPI send r from page of ctx
+func callSendAPI(ctx context.Context, r *Response) error {
 
-	if err := encoder.Encode(&r); err != nil {
-		logging.Danger(err)
-		return err
-	}
-
-	request, err := http.NewRequest("POST", messengerEndpoint, body)
+	err := sendAPI.Send(ctx, pageAccessToken(ctx), r)
 	if err != nil {
-		logging.Danger(err)
-		return err
-	}
-
-	// Add header and query params for request
-	request.Header.Add("Content-Type", "application/json")
-	q := request.URL.Query()
-	q.Add("access_token", pageToken)
-	request.URL.RawQuery = q.Encode()
-
-	// Create client to send request
-	client := http.Client{
-		Timeout: 20 * time.Second,
+		logging.Ctx(ctx).Danger(err)
 	}
 
-	// Send POST message to FACEBOOK API
-	resp, err := client.Do(request)
-	if err != nil {
-		logging.Danger(err)
-		return err
-	}
-
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		respBody, er := ioutil.ReadAll(resp.Body)
-		if er == nil {
-			logging.Danger(string(respBody))
-		}
-
-		return errors.Errorf("Error call send API, resp status %s", resp.Status)
-	}
-	// defer resp.Body.Close()
-	// respBody, err := ioutil.ReadAll(resp.Body)
-	// if err != nil {
-	// 	logging.Danger(err)
-	// }
-
-	// fmt.Println(string(respBody))
-	return nil
+	return err
 }
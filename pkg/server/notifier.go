@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/pkg/errors"
+
+	"github.com/tinoquang/comic-notifier/pkg/conf"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+// Notification channels user can choose to receive new chapter
+const (
+	channelMessenger = "messenger"
+	channelTelegram  = "telegram"
+	channelDiscord   = "discord"
+	channelWebhook   = "webhook"
+	channelEmail     = "email"
+)
+
+// Notifier deliver new chapter notification to user through one channel
+type Notifier interface {
+	Notify(ctx context.Context, user *db.User, comic *db.Comic) error
+}
+
+// BatchNotifier deliver new chapters of several comics to user at once, channels which don't implement it are notified
+// per comic. Return number of comics delivered before err, in order
+type BatchNotifier interface {
+	NotifyBatch(ctx context.Context, user *db.User, comics []db.Comic) (sent int, err error)
+}
+
+// notifiers contains all configured channels, Messenger is always available
+var notifiers map[string]Notifier
+
+func initNotifiers() {
+
+	notifiers = map[string]Notifier{
+		channelMessenger: messengerNotifier{},
+		channelDiscord:   discordNotifier{},
+		channelWebhook:   webhookNotifier{},
+	}
+
+	if conf.Cfg.Notifier.TelegramToken != "" {
+		notifiers[channelTelegram] = telegramNotifier{token: conf.Cfg.Notifier.TelegramToken}
+	}
+
+	if conf.Cfg.Notifier.SMTP.Host != "" {
+		notifiers[channelEmail] = emailNotifier{cfg: conf.Cfg.Notifier.SMTP}
+	}
+}
+
+// notifyAll deliver comics to user through notifier, at once if it's a BatchNotifier.
+// Return number of comics delivered before err, in order
+func notifyAll(ctx context.Context, notifier Notifier, user *db.User, comics []db.Comic) (int, error) {
+
+	if len(comics) == 0 {
+		return 0, nil
+	}
+
+	if b, ok := notifier.(BatchNotifier); ok {
+		return b.NotifyBatch(ctx, user, comics)
+	}
+
+	for i := range comics {
+		if err := notifier.Notify(ctx, user, &comics[i]); err != nil {
+			return i, err
+		}
+	}
+
+	return len(comics), nil
+}
+
+// userChannel return user's preferred channel, fallback to Messenger if the channel is not usable
+func userChannel(user *db.User) string {
+
+	if _, ok := notifiers[user.NotifyChannel]; ok && (user.NotifyChannel == channelMessenger || user.NotifyTarget.String != "") {
+		return user.NotifyChannel
+	}
+
+	return channelMessenger
+}
+
+// validateNotifySettings verify channel is configured and target is valid for that channel
+func validateNotifySettings(channel, target string) error {
+
+	if _, ok := notifiers[channel]; !ok {
+		return errors.Errorf("Channel %s is not supported", channel)
+	}
+
+	switch channel {
+	case channelMessenger:
+		return nil
+	case channelDiscord:
+		if !isDiscordWebhook(target) {
+			return errors.New("Target must be a Discord webhook URL")
+		}
+	case channelWebhook:
+		if !govalidator.IsURL(target) {
+			return errors.New("Target must be a webhook URL")
+		}
+		// Address is checked again when notification is sent, host name may resolve to another address by then
+		if err := util.ValidatePublicURL(target); err != nil {
+			return errors.New("Target must be a public https URL")
+		}
+	case channelEmail:
+		if !govalidator.IsEmail(target) {
+			return errors.New("Target must be an email address")
+		}
+	case channelTelegram:
+		if target == "" {
+			return errors.New("Target must be a Telegram chat ID")
+		}
+	}
+
+	return nil
+}
+
+// notifyText plain text notification, used by channels which can't display Messenger template
+func notifyText(comic *db.Comic) string {
+	return fmt.Sprintf("%s có chương mới: %s\n%s", comic.Name, comic.LatestChap, comic.ChapUrl)
+}
+
+type messengerNotifier struct{}
+
+func (messengerNotifier) Notify(ctx context.Context, user *db.User, comic *db.Comic) error {
+	return sendMsgTagsReply(userContext(ctx, user), user.Psid.String, []db.Comic{*comic})
+}
+
+// NotifyBatch send comics as carousels of up to maxTemplateElements, later pages aren't sent once one fails
+func (messengerNotifier) NotifyBatch(ctx context.Context, user *db.User, comics []db.Comic) (int, error) {
+
+	ctx = userContext(ctx, user)
+	for sent := 0; sent < len(comics); sent += maxTemplateElements {
+		end := sent + maxTemplateElements
+		if end > len(comics) {
+			end = len(comics)
+		}
+
+		if err := sendMsgTagsReply(ctx, user.Psid.String, comics[sent:end]); err != nil {
+			return sent, err
+		}
+	}
+
+	return len(comics), nil
+}
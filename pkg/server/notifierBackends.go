@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tinoquang/comic-notifier/pkg/conf"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+var telegramEndpoint = "https://api.telegram.org"
+
+// smtpTimeout is the longest an email is sent in, from dialing SMTP server to quitting
+var smtpTimeout = 30 * time.Second
+
+// targetClient post to webhook URLs given by users, it can't reach hosts inside our network
+var targetClient = util.NewPublicClient(10 * time.Second)
+
+// discordHosts are hosts of Discord webhook URLs
+var discordHosts = map[string]bool{
+	"discord.com":        true,
+	"discordapp.com":     true,
+	"ptb.discord.com":    true,
+	"canary.discord.com": true,
+}
+
+// telegramNotifier send message through Telegram bot, user's target is chat ID
+type telegramNotifier struct {
+	token string
+}
+
+func (t telegramNotifier) Notify(ctx context.Context, user *db.User, comic *db.Comic) error {
+
+	_, err := util.MakePostRequest(ctx, fmt.Sprintf("%s/bot%s/sendMessage", telegramEndpoint, t.token), map[string]string{
+		"chat_id": user.NotifyTarget.String,
+		"text":    notifyText(comic),
+	})
+
+	// Bot token is in request URL, so it's dropped from transport error which is logged and saved in outbox
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return errors.Wrap(urlErr.Err, "Can't send Telegram message")
+	}
+	return err
+}
+
+// discordNotifier post message to Discord webhook, user's target is webhook URL
+type discordNotifier struct{}
+
+func (discordNotifier) Notify(ctx context.Context, user *db.User, comic *db.Comic) error {
+
+	_, err := util.PostJSON(ctx, targetClient, user.NotifyTarget.String, map[string]string{
+		"content": notifyText(comic),
+	})
+	return err
+}
+
+// isDiscordWebhook report whether target is a webhook URL of Discord
+func isDiscordWebhook(target string) bool {
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	return u.Scheme == "https" && discordHosts[strings.ToLower(u.Hostname())] && u.Port() == "" &&
+		strings.HasPrefix(u.Path, "/api/webhooks/")
+}
+
+// webhookNotifier post comic info as JSON to user's own endpoint, user's target is endpoint URL
+type webhookNotifier struct{}
+
+type webhookPayload struct {
+	ComicID    int32  `json:"comicID"`
+	Page       string `json:"page"`
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	ImgURL     string `json:"imgURL"`
+	LatestChap string `json:"latestChap"`
+	ChapURL    string `json:"chapURL"`
+}
+
+func (webhookNotifier) Notify(ctx context.Context, user *db.User, comic *db.Comic) error {
+
+	_, err := util.PostJSON(ctx, targetClient, user.NotifyTarget.String, webhookPayload{
+		ComicID:    comic.ID,
+		Page:       comic.Page,
+		Name:       comic.Name,
+		URL:        comic.Url,
+		ImgURL:     comic.CloudImgUrl,
+		LatestChap: comic.LatestChap,
+		ChapURL:    comic.ChapUrl,
+	})
+	return err
+}
+
+// emailNotifier send email through SMTP server, user's target is email address
+type emailNotifier struct {
+	cfg conf.SMTPCfg
+}
+
+func (e emailNotifier) Notify(ctx context.Context, user *db.User, comic *db.Comic) error {
+
+	msg := strings.Join([]string{
+		"From: " + e.cfg.From,
+		"To: " + user.NotifyTarget.String,
+		"Subject: " + mime.QEncoding.Encode("UTF-8", comic.Name+" - "+comic.LatestChap),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		notifyText(comic),
+	}, "\r\n")
+
+	return e.send(ctx, user.NotifyTarget.String, []byte(msg))
+}
+
+// send work like smtp.SendMail, but the whole session is bounded by smtpTimeout and stopped when ctx is done,
+// so an unresponsive SMTP server doesn't hold notify worker
+func (e emailNotifier) send(ctx context.Context, to string, msg []byte) error {
+
+	dialer := net.Dialer{Timeout: smtpTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(e.cfg.Host, e.cfg.Port))
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(smtpTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, e.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: e.cfg.Host}); err != nil {
+			return err
+		}
+	}
+
+	if ok, _ := c.Extension("AUTH"); ok && e.cfg.User != "" {
+		if err = c.Auth(smtp.PlainAuth("", e.cfg.User, e.cfg.Password, e.cfg.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err = c.Mail(e.cfg.From); err != nil {
+		return err
+	}
+
+	if err = c.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
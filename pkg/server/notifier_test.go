@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/tinoquang/comic-notifier/pkg/conf"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/messenger"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+func TestUserChannelFallback(t *testing.T) {
+
+	notifiers = map[string]Notifier{
+		channelMessenger: messengerNotifier{},
+		channelDiscord:   discordNotifier{},
+	}
+
+	user := db.User{NotifyChannel: channelDiscord, NotifyTarget: sql.NullString{String: "https://discord.test/hook", Valid: true}}
+	require.Equal(t, channelDiscord, userChannel(&user))
+
+	// Target is missing
+	user.NotifyTarget = sql.NullString{}
+	require.Equal(t, channelMessenger, userChannel(&user))
+
+	// Channel is not configured
+	user = db.User{NotifyChannel: channelTelegram, NotifyTarget: sql.NullString{String: "123", Valid: true}}
+	require.Equal(t, channelMessenger, userChannel(&user))
+}
+
+func TestNotifyTargetOnlyForOwner(t *testing.T) {
+
+	s := newFakeStore(0, 2)
+	for i := range s.users {
+		s.users[i].Appid = sql.NullString{String: fmt.Sprint(i + 1), Valid: true}
+		s.users[i].NotifyTarget = sql.NullString{String: fmt.Sprintf("https://discord.test/hook-%d", i+1), Valid: true}
+	}
+	a := NewAPI(s, &fakeCrawler{}, nil, 0, nil, nil)
+
+	ctx, rec := newAdminContext("")
+	require.Nil(t, a.Users(ctx))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotContains(t, rec.Body.String(), "hook")
+
+	ctx, rec = newAdminContext("")
+	require.Nil(t, a.GetUser(ctx, "1"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "https://discord.test/hook-1")
+
+	ctx, rec = newAdminContext("")
+	require.Nil(t, a.GetUser(ctx, "2"))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestValidateNotifySettings(t *testing.T) {
+
+	notifiers = map[string]Notifier{
+		channelMessenger: messengerNotifier{},
+		channelWebhook:   webhookNotifier{},
+		channelDiscord:   discordNotifier{},
+		channelEmail:     emailNotifier{},
+	}
+
+	require.Nil(t, validateNotifySettings(channelMessenger, ""))
+	require.Nil(t, validateNotifySettings(channelWebhook, "https://example.com/hook"))
+	require.NotNil(t, validateNotifySettings(channelWebhook, "not a url"))
+
+	// Webhook can't point to our own network
+	for _, target := range []string{
+		"http://example.com/hook",
+		"https://localhost/hook",
+		"https://127.0.0.1/hook",
+		"https://169.254.169.254/latest/meta-data",
+		"https://10.0.0.5:8080/hook",
+		"https://[::1]/hook",
+	} {
+		require.NotNil(t, validateNotifySettings(channelWebhook, target), target)
+	}
+
+	require.Nil(t, validateNotifySettings(channelDiscord, "https://discord.com/api/webhooks/1/token"))
+	require.Nil(t, validateNotifySettings(channelDiscord, "https://discordapp.com/api/webhooks/1/token"))
+	require.NotNil(t, validateNotifySettings(channelDiscord, "https://example.com/api/webhooks/1/token"))
+	require.NotNil(t, validateNotifySettings(channelDiscord, "http://discord.com/api/webhooks/1/token"))
+	require.NotNil(t, validateNotifySettings(channelDiscord, "https://discord.com/channels/1"))
+
+	require.Nil(t, validateNotifySettings(channelEmail, "user@example.com"))
+	require.NotNil(t, validateNotifySettings(channelEmail, "user"))
+	require.EqualError(t, validateNotifySettings(channelTelegram, "123"), "Channel telegram is not supported")
+}
+
+func TestTelegramNotifier(t *testing.T) {
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	body := map[string]string{}
+	httpmock.RegisterResponder("POST", telegramEndpoint+"/bottoken/sendMessage",
+		func(req *http.Request) (*http.Response, error) {
+			json.NewDecoder(req.Body).Decode(&body)
+			return httpmock.NewStringResponse(200, `{"ok":true}`), nil
+		},
+	)
+
+	user := db.User{NotifyTarget: sql.NullString{String: "42", Valid: true}}
+	comic := db.Comic{Name: "One Piece", LatestChap: "Chapter 1008", ChapUrl: "https://test.vn/1008"}
+
+	err := telegramNotifier{token: "token"}.Notify(context.Background(), &user, &comic)
+	require.Nil(t, err)
+	require.Equal(t, "42", body["chat_id"])
+	require.Contains(t, body["text"], "Chapter 1008")
+
+	// Token in request URL isn't leaked through transport error
+	httpmock.RegisterResponder("POST", telegramEndpoint+"/botsecret/sendMessage", httpmock.NewErrorResponder(errors.New("connection reset")))
+	err = telegramNotifier{token: "secret"}.Notify(context.Background(), &user, &comic)
+	require.NotNil(t, err)
+	require.NotContains(t, err.Error(), "secret")
+}
+
+func TestEmailNotifierUnresponsiveServer(t *testing.T) {
+
+	// Server accepts connection but never greets
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	user := db.User{NotifyTarget: sql.NullString{String: "user@example.com", Valid: true}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = emailNotifier{cfg: conf.SMTPCfg{Host: host, Port: port, From: "bot@example.com"}}.Notify(ctx, &user, &db.Comic{})
+	require.NotNil(t, err)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestWebhookNotifierFailed(t *testing.T) {
+
+	httpmock.ActivateNonDefault(targetClient)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("POST", "https://example.com/hook", httpmock.NewStringResponder(500, "error"))
+
+	user := db.User{NotifyTarget: sql.NullString{String: "https://example.com/hook", Valid: true}}
+	err := webhookNotifier{}.Notify(context.Background(), &user, &db.Comic{})
+	require.NotNil(t, err)
+}
+
+func TestMessengerNotifyBatch(t *testing.T) {
+
+	defer func(c *messenger.Client, token string) { sendAPI, pageToken = c, token }(sendAPI, pageToken)
+
+	pages := []int{}
+	failing := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := Response{}
+		json.NewDecoder(r.Body).Decode(&response)
+		pages = append(pages, len(response.Message.Template.Payloads.Elements))
+
+		if failing && len(pages) == 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":{"message":"An unknown error has occurred.","code":1}}`))
+			return
+		}
+		w.Write([]byte(`{"recipient_id":"1","message_id":"m1"}`))
+	}))
+	defer srv.Close()
+
+	sendAPI = messenger.NewClient(srv.URL)
+	pageToken = "token"
+
+	comics := []db.Comic{}
+	for i := 1; i <= 23; i++ {
+		comics = append(comics, db.Comic{ID: int32(i), Name: "One Piece", LatestChap: "Chapter 1008"})
+	}
+	user := &db.User{Psid: sql.NullString{String: "1", Valid: true}}
+
+	// Comics are paginated into carousels of at most maxTemplateElements
+	sent, err := messengerNotifier{}.NotifyBatch(context.Background(), user, comics)
+	require.Nil(t, err)
+	require.Equal(t, 23, sent)
+	require.Equal(t, []int{10, 10, 3}, pages)
+
+	// Pages after the failed one aren't sent
+	pages = nil
+	failing = true
+	sent, err = messengerNotifier{}.NotifyBatch(context.Background(), user, comics)
+	require.NotNil(t, err)
+	require.Equal(t, 10, sent)
+	require.Equal(t, []int{10, 10}, pages)
+}
+
+func TestNotifyAll(t *testing.T) {
+
+	rec := &recordNotifier{sent: map[string][]string{}}
+	comics := []db.Comic{{ID: 1, ChapUrl: "https://test.vn/1"}, {ID: 2, ChapUrl: "https://test.vn/2"}}
+
+	// Channels without batch support are notified per comic
+	sent, err := notifyAll(context.Background(), rec, &db.User{ID: 1}, comics)
+	require.Nil(t, err)
+	require.Equal(t, 2, sent)
+	require.Equal(t, map[string][]string{"1-1": {"https://test.vn/1"}, "1-2": {"https://test.vn/2"}}, rec.sent)
+
+	batch := &batchNotifier{batches: map[int32][]int{}}
+	sent, err = notifyAll(context.Background(), batch, &db.User{ID: 1}, comics)
+	require.Nil(t, err)
+	require.Equal(t, 2, sent)
+	require.Equal(t, map[int32][]int{1: {2}}, batch.batches)
+}
+
+func TestWebhookNotifierPrivateAddress(t *testing.T) {
+
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+
+	// Address is checked again when notification is sent, whatever target was saved
+	user := db.User{NotifyTarget: sql.NullString{String: srv.URL, Valid: true}}
+	err := webhookNotifier{}.Notify(context.Background(), &user, &db.Comic{})
+	require.True(t, errors.Is(err, util.ErrPrivateAddress))
+	require.Zero(t, calls)
+}
@@ -1,102 +1,380 @@
 package server
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/tinoquang/comic-notifier/pkg/conf"
+	"github.com/pkg/errors"
 	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
 	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/messenger"
+	"github.com/tinoquang/comic-notifier/pkg/metrics"
+	"github.com/tinoquang/comic-notifier/pkg/msg"
 )
 
-type notification struct {
-	userID string
-	comic  db.Comic
-	retry  int // number of attempts to send notification to user
-}
-
-var (
-	newNotification    chan notification
-	failedNotification chan notification
+const (
+	maxNotifyAttempts  = 5                // notification is marked failed after this number of attempts
+	notifyRetryBase    = 30 * time.Second // delay before first retry, doubled after each attempt
+	notifyRetryMax     = 2 * time.Hour
+	notifyPollInterval = time.Minute // check for due retries when there is no new chapter
 )
 
-func initNotifyService(updateLock *sync.Mutex) {
+// sweepRunning is 1 while updateComics sweeps comics, notifyService doesn't poll then
+var sweepRunning int32
 
-	newNotification = make(chan notification, conf.Cfg.WrkDat.NotifyWorkerNum)
-	failedNotification = make(chan notification, conf.Cfg.WrkDat.NotifyWorkerNum)
+// notifyService drain notifications outbox until ctx is cancelled. The outbox is filled by updateService in the same
+// transaction with new chapters, so both services run concurrently and wake only shortens the wait for new notifications
+func notifyService(ctx context.Context, s db.Store, workerNum int, wake <-chan struct{}) {
+
+	for {
+		drainNotifications(ctx, s, workerNum)
 
-	go notifyService(updateLock)
+		if !waitForNotifications(ctx, wake, notifyPollInterval) {
+			return
+		}
+	}
 }
 
-func notifyService(updateLock *sync.Mutex) {
+// waitForNotifications block until service is woken up or poll interval passes, false if ctx is cancelled. Polls are
+// skipped while a sweep runs: sweep wakes service up when it's done, so chapters it finds are sent together
+func waitForNotifications(ctx context.Context, wake <-chan struct{}, poll time.Duration) bool {
 
-	var wg sync.WaitGroup
 	for {
-
-		// Need to verify updateService is not running, to avoid missing notification
-		updateLock.Lock()
-		notificationPool := make(chan notification, conf.Cfg.WrkDat.NotifyWorkerNum)
-
-		// Resend all failNotification first
-	failed:
-		for {
-			select {
-			case n := <-failedNotification:
-				notificationPool <- n
-			default:
-				break failed
+		select {
+		case <-ctx.Done():
+			return false
+		case <-wake:
+			return true
+		case <-time.After(poll):
+			if atomic.LoadInt32(&sweepRunning) == 0 {
+				return true
 			}
 		}
+	}
+}
 
-		// Send all newNotification
-	new:
-		for {
-			select {
-			case n := <-newNotification:
-				notificationPool <- n
-			default:
-				break new
-			}
+// wakeNotifyService tell notifyService new notifications are queued, never block since one pending wake-up is enough
+func wakeNotifyService(wake chan<- struct{}) {
+
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// drainNotifications send all due notifications, chapters of a comic are held back while an earlier one waits for
+// its retry, so chapters are delivered in order. Due notifications of one user are sent together, so chapters found in the same
+// sweep reach user in as few messages as the channel allows. On cancel, the running batch is finished before returning
+func drainNotifications(ctx context.Context, s db.Store, workerNum int) {
+
+	ctx = logging.With(ctx, logging.FieldJob, "notify")
+	for ctx.Err() == nil {
+		listCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		due, err := s.ListDueNotifications(listCtx, int32(workerNum*10))
+		cancel()
+
+		if err != nil {
+			logging.Ctx(ctx).Danger("Get list of notification fails, err", err)
+			return
 		}
 
-		for i := 0; i < conf.Cfg.WrkDat.NotifyWorkerNum; i++ {
-			go notifyWorker(i, &wg, notificationPool)
+		if len(due) == 0 {
+			return
+		}
+
+		metrics.NotifyQueueDepth.WithLabelValues(metrics.QueueDue).Set(float64(len(due)))
+
+		var wg sync.WaitGroup
+		var updated int32
+		notificationPool := make(chan []db.Notification, workerNum)
+
+		for i := 0; i < workerNum; i++ {
+			go notifyWorker(i, s, &wg, &updated, notificationPool)
 			wg.Add(1)
 		}
 
+		for _, batch := range groupByUser(due) {
+			notificationPool <- batch
+		}
 		close(notificationPool)
 
 		wg.Wait()
-		updateLock.Unlock()
 
-		// logging.Info("Notifyservice wait")
-		select {
-		case <-time.After(20 * time.Minute):
-			// logging.Info("Notifywait timeout")
-		case <-updateDone:
-			// logging.Info("Received done signal from updateService")
+		// Status can't be saved, stop here to avoid resending the same notifications
+		if updated == 0 {
+			return
+		}
+	}
+}
+
+// groupByUser split notifications into batches per user, in order of user's first notification
+func groupByUser(notifications []db.Notification) [][]db.Notification {
+
+	batches := [][]db.Notification{}
+	index := map[int32]int{}
+	for _, n := range notifications {
+		i, ok := index[n.UserID]
+		if !ok {
+			i = len(batches)
+			index[n.UserID] = i
+			batches = append(batches, nil)
 		}
+		batches[i] = append(batches[i], n)
 	}
 
+	return batches
 }
 
-func notifyWorker(id int, wg *sync.WaitGroup, notify <-chan notification) {
+// notifyTimeout return how long a batch of n notifications is sent in, every carousel page is a Send API call
+func notifyTimeout(n int) time.Duration {
 
-	for n := range notify {
-		err := sendMsgTagsReply(n.userID, &n.comic)
-		if err != nil {
-			n.retry++
+	pages := (n + maxTemplateElements - 1) / maxTemplateElements
+	return time.Duration(pages) * 15 * time.Second
+}
+
+func notifyWorker(id int, s db.Store, wg *sync.WaitGroup, updated *int32, notify <-chan []db.Notification) {
+
+	for batch := range notify {
+		ctx := logging.With(context.Background(), logging.FieldJob, "notify", logging.FieldUserID, batch[0].UserID)
+
+		ctx, cancel := context.WithTimeout(ctx, notifyTimeout(len(batch)))
+		metrics.NotifyQueueDepth.WithLabelValues(metrics.QueueDue).Sub(float64(len(batch)))
+		metrics.NotifyQueueDepth.WithLabelValues(metrics.QueueInFlight).Add(float64(len(batch)))
+
+		atomic.AddInt32(updated, notifyUser(ctx, s, batch))
+
+		metrics.NotifyQueueDepth.WithLabelValues(metrics.QueueInFlight).Sub(float64(len(batch)))
+		cancel()
+	}
 
-			// Retry sending notification 5 times before consider this is an error
-			if n.retry < 5 {
-				failedNotification <- n
-			} else {
-				logging.Danger("Can't send notify for comic", n.comic.Name, "to user", n.userID, "err", err)
+	wg.Done()
+}
+
+// notifyUser send batch of one user's notifications and save their status, notifications waiting for user's digest
+// are deferred to its delivery time. Return number of notifications whose status is saved
+func notifyUser(ctx context.Context, s db.Store, batch []db.Notification) (updated int32) {
+
+	now := time.Now()
+	send := []db.Notification{}
+	errs := []error{}
+	channel := ""
+
+	user, err := s.GetUser(ctx, batch[0].UserID)
+	if err != nil {
+		send = batch
+		for range batch {
+			errs = append(errs, err)
+		}
+	} else {
+		channel = userChannel(&user)
+		for _, n := range batch {
+			at, wait := digestDelay(&user, &n, now)
+			if !wait {
+				send = append(send, n)
+				continue
+			}
 
+			err := s.DeferNotification(ctx, db.DeferNotificationParams{ID: n.ID, NextAttemptAt: at})
+			if err != nil {
+				logging.Ctx(ctx).With(logging.FieldNotifyID, n.ID).Danger(err)
+				continue
 			}
+			updated++
 		}
-		// logging.Info("Notify", id, " success for comic", n.comic.Name, "to user", n.userID, "retry time =", n.retry)
+		errs = sendNotifications(ctx, s, &user, channel, send)
 	}
 
-	wg.Done()
+	for i, n := range send {
+		nctx := logging.With(ctx, logging.FieldNotifyID, n.ID, logging.FieldComicID, n.ComicID)
+		if errs[i] != nil && n.Attempts+1 >= maxNotifyAttempts {
+			logging.Ctx(nctx).Danger("Can't send notification, err", errs[i])
+		}
+
+		status := nextNotificationStatus(&n, errs[i], now)
+		observeNotification(channel, n.Attempts, status.Status)
+
+		if err := s.UpdateNotificationStatus(nctx, status); err != nil {
+			logging.Ctx(nctx).Danger(err)
+			continue
+		}
+		updated++
+	}
+
+	for _, err := range errs {
+		if kind := errors.Cause(err); kind == messenger.ErrUserUnavailable || kind == messenger.ErrTokenExpired {
+			handleSendError(userContext(ctx, &user), s, user.ID, err)
+			break
+		}
+	}
+
+	return
+}
+
+// sendNotifications send notifications of user through channel in one go, return error of each notification
+func sendNotifications(ctx context.Context, s db.Store, user *db.User, channel string, notifications []db.Notification) []error {
+
+	errs := make([]error, len(notifications))
+	comics := []db.Comic{}
+	sending := []int{} // index of notification of each comic
+
+	for i := range notifications {
+		comic, err := notificationComic(ctx, s, &notifications[i])
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		comics = append(comics, comic)
+		sending = append(sending, i)
+	}
+
+	sent, err := notifyAll(ctx, notifiers[channel], user, comics)
+	for _, i := range sending[sent:] {
+		errs[i] = err
+	}
+
+	return errs
+}
+
+// notificationComic return comic of notification, with its latest chapter set to notification's chapter
+func notificationComic(ctx context.Context, s db.Store, n *db.Notification) (db.Comic, error) {
+
+	comic, err := s.GetComic(ctx, n.ComicID)
+	if err != nil {
+		return comic, err
+	}
+
+	chap, err := s.GetChapter(ctx, n.ChapterID)
+	if err != nil {
+		return comic, err
+	}
+
+	comic.LatestChap = chap.Name
+	comic.ChapUrl = chap.Url
+
+	return comic, nil
+}
+
+// observeNotification count result of a notification attempt, attempts is number of previous failed attempts
+func observeNotification(channel string, attempts int32, status string) {
+
+	if channel == "" {
+		channel = "unknown"
+	}
+
+	result := metrics.NotificationSent
+	switch status {
+	case db.NotificationPending:
+		result = metrics.NotificationRetry
+	case db.NotificationFailed:
+		result = metrics.NotificationFailed
+	}
+
+	metrics.Notifications.WithLabelValues(channel, result).Inc()
+	if attempts > 0 {
+		metrics.NotificationRetries.WithLabelValues(channel).Inc()
+	}
+}
+
+// handleSendError react to Send API errors of messages sent to user: users who blocked page are unsubscribed
+// from everything, so they aren't notified again, and admin is alerted when token of user's page is expired
+func handleSendError(ctx context.Context, s db.Store, userID int32, err error) {
+
+	switch errors.Cause(err) {
+	case messenger.ErrUserUnavailable:
+	case messenger.ErrTokenExpired:
+		alertExpiredToken(ctx, err)
+		return
+	default:
+		return
+	}
+
+	if err := s.UnsubscribeUser(ctx, userID); err != nil {
+		logging.Ctx(ctx).Danger("Can't unsubscribe user who blocked page, err", err)
+		return
+	}
+
+	logging.Ctx(ctx).With(logging.FieldUserID, userID).Info("User blocked page, all subscriptions are removed")
+}
+
+// alertExpiredToken alert admin that token of ctx's page is expired. Client holds the page's sends back after the
+// first expired call, so admin is alerted once per hold rather than once per message
+func alertExpiredToken(ctx context.Context, err error) {
+
+	var e *messenger.Error
+	if errors.As(err, &e) && e.Held() {
+		return
+	}
+
+	page := msg.PageID(ctx)
+	if page == "" {
+		page = "default"
+	}
+
+	alertAdmin(fmt.Sprintf("Access token of Messenger page %s is expired, its messages are held until token is updated", page))
+}
+
+// nextNotificationStatus return status of notification after an attempt, failed attempt is retried with exponential backoff.
+// Messenger errors which retrying can't fix fail notification at once, rate limited page is retried after its throttle delay
+// and page with expired token after its hold delay
+func nextNotificationStatus(n *db.Notification, sendErr error, now time.Time) db.UpdateNotificationStatusParams {
+
+	if sendErr == nil {
+		return db.UpdateNotificationStatusParams{
+			ID:            n.ID,
+			Status:        db.NotificationSent,
+			NextAttemptAt: now,
+		}
+	}
+
+	params := db.UpdateNotificationStatusParams{
+		ID:            n.ID,
+		Status:        db.NotificationPending,
+		NextAttemptAt: now.Add(notifyBackoff(n.Attempts)),
+		LastError:     sql.NullString{String: sendErr.Error(), Valid: true},
+	}
+
+	switch errors.Cause(sendErr) {
+	case messenger.ErrUserUnavailable, messenger.ErrOutsideWindow:
+		params.Status = db.NotificationFailed
+		params.NextAttemptAt = now
+		return params
+	case messenger.ErrRateLimited:
+		// Throttling isn't the notification's fault, it's not failed however many times page is throttled
+		if params.NextAttemptAt.Before(now.Add(messenger.ThrottleDelay)) {
+			params.NextAttemptAt = now.Add(messenger.ThrottleDelay)
+		}
+		return params
+	case messenger.ErrTokenExpired:
+		// Neither is expired token, notification waits until admin updates it
+		if params.NextAttemptAt.Before(now.Add(messenger.TokenHoldDelay)) {
+			params.NextAttemptAt = now.Add(messenger.TokenHoldDelay)
+		}
+		return params
+	}
+
+	if n.Attempts+1 >= maxNotifyAttempts {
+		params.Status = db.NotificationFailed
+		params.NextAttemptAt = now
+	}
+
+	return params
+}
+
+// notifyBackoff return delay before next attempt, attempts is number of previous failed attempts
+func notifyBackoff(attempts int32) time.Duration {
+
+	delay := notifyRetryBase
+	for i := int32(0); i < attempts; i++ {
+		delay *= 2
+		if delay >= notifyRetryMax {
+			return notifyRetryMax
+		}
+	}
+
+	return delay
 }
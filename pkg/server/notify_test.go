@@ -0,0 +1,284 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/messenger"
+	"github.com/tinoquang/comic-notifier/pkg/metrics"
+	"github.com/tinoquang/comic-notifier/pkg/msg"
+)
+
+func TestNotifyBackoff(t *testing.T) {
+
+	require.Equal(t, notifyRetryBase, notifyBackoff(0))
+	require.Equal(t, 4*notifyRetryBase, notifyBackoff(2))
+	require.Equal(t, notifyRetryMax, notifyBackoff(20))
+}
+
+func TestNextNotificationStatus(t *testing.T) {
+
+	now := time.Now()
+
+	params := nextNotificationStatus(&db.Notification{ID: 1}, nil, now)
+	require.Equal(t, db.NotificationSent, params.Status)
+	require.False(t, params.LastError.Valid)
+
+	params = nextNotificationStatus(&db.Notification{ID: 1, Attempts: 1}, errors.New("timeout"), now)
+	require.Equal(t, db.NotificationPending, params.Status)
+	require.Equal(t, now.Add(2*notifyRetryBase), params.NextAttemptAt)
+	require.Equal(t, "timeout", params.LastError.String)
+
+	params = nextNotificationStatus(&db.Notification{ID: 1, Attempts: maxNotifyAttempts - 1}, errors.New("timeout"), now)
+	require.Equal(t, db.NotificationFailed, params.Status)
+
+	// Users who blocked page are not retried, throttled page is retried after its delay even after max attempts
+	params = nextNotificationStatus(&db.Notification{ID: 1}, &messenger.Error{Code: 551}, now)
+	require.Equal(t, db.NotificationFailed, params.Status)
+
+	params = nextNotificationStatus(&db.Notification{ID: 1}, &messenger.Error{Code: 613}, now)
+	require.Equal(t, db.NotificationPending, params.Status)
+	require.Equal(t, now.Add(messenger.ThrottleDelay), params.NextAttemptAt)
+
+	params = nextNotificationStatus(&db.Notification{ID: 1, Attempts: maxNotifyAttempts - 1}, &messenger.Error{Code: 613}, now)
+	require.Equal(t, db.NotificationPending, params.Status)
+
+	// Expired page token waits for admin to update it
+	params = nextNotificationStatus(&db.Notification{ID: 1, Attempts: maxNotifyAttempts - 1}, &messenger.Error{Code: 190}, now)
+	require.Equal(t, db.NotificationPending, params.Status)
+	require.Equal(t, now.Add(messenger.TokenHoldDelay), params.NextAttemptAt)
+}
+
+func TestObserveNotification(t *testing.T) {
+
+	count := func(channel, result string) float64 {
+		return testutil.ToFloat64(metrics.Notifications.WithLabelValues(channel, result))
+	}
+	sent := count(channelTelegram, metrics.NotificationSent)
+	retry := count(channelTelegram, metrics.NotificationRetry)
+	failed := count(channelTelegram, metrics.NotificationFailed)
+	unknown := count("unknown", metrics.NotificationRetry)
+	retries := testutil.ToFloat64(metrics.NotificationRetries.WithLabelValues(channelTelegram))
+
+	observeNotification(channelTelegram, 0, db.NotificationSent)
+	observeNotification(channelTelegram, 1, db.NotificationPending)
+	observeNotification(channelTelegram, maxNotifyAttempts-1, db.NotificationFailed)
+	observeNotification("", 0, db.NotificationPending)
+
+	require.Equal(t, sent+1, count(channelTelegram, metrics.NotificationSent))
+	require.Equal(t, retry+1, count(channelTelegram, metrics.NotificationRetry))
+	require.Equal(t, failed+1, count(channelTelegram, metrics.NotificationFailed))
+	require.Equal(t, unknown+1, count("unknown", metrics.NotificationRetry))
+
+	// First attempt is not a retry
+	require.Equal(t, retries+2, testutil.ToFloat64(metrics.NotificationRetries.WithLabelValues(channelTelegram)))
+}
+
+func (s *fakeStore) UnsubscribeUser(ctx context.Context, userID int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscribers := []db.Subscriber{}
+	for _, sub := range s.subscribers {
+		if sub.UserID != userID {
+			subscribers = append(subscribers, sub)
+		}
+	}
+	s.subscribers = subscribers
+	return nil
+}
+
+func TestHandleSendError(t *testing.T) {
+
+	s := newFakeStore(1, 2)
+	s.subscribers = []db.Subscriber{{UserID: 1, ComicID: 1}, {UserID: 2, ComicID: 1}}
+
+	handleSendError(context.Background(), s, 1, nil)
+	handleSendError(context.Background(), s, 1, &messenger.Error{Code: 613})
+	require.Len(t, s.subscribers, 2)
+
+	handleSendError(context.Background(), s, 1, errors.Wrap(&messenger.Error{Code: 551, Subcode: 1545041}, "notify"))
+	require.Equal(t, []db.Subscriber{{UserID: 2, ComicID: 1}}, s.subscribers)
+}
+
+func TestAlertExpiredToken(t *testing.T) {
+
+	defer func(c *messenger.Client, token, psid string) { sendAPI, pageToken, adminPSID = c, token, psid }(sendAPI, pageToken, adminPSID)
+
+	alerts := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("access_token") == "expired" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"Error validating access token","code":190,"error_subcode":463}}`))
+			return
+		}
+
+		response := Response{}
+		json.NewDecoder(r.Body).Decode(&response)
+		alerts = append(alerts, response.Message.Text)
+		w.Write([]byte(`{"recipient_id":"admin","message_id":"m1"}`))
+	}))
+	defer srv.Close()
+
+	sendAPI = messenger.NewClient(srv.URL)
+	pageToken = "token"
+	adminPSID = "admin"
+
+	s := newFakeStore(1, 1)
+	s.subscribers = []db.Subscriber{{UserID: 1, ComicID: 1}}
+	ctx := msg.WithPageID(context.Background(), "page-1")
+
+	// Admin is alerted on the first expired call, held calls after it don't alert again
+	handleSendError(ctx, s, 1, sendAPI.Send(ctx, "expired", map[string]string{}))
+	handleSendError(ctx, s, 1, sendAPI.Send(ctx, "expired", map[string]string{}))
+	require.Len(t, alerts, 1)
+	require.Contains(t, alerts[0], "page-1")
+
+	// User isn't unsubscribed, page is at fault
+	require.Len(t, s.subscribers, 1)
+}
+
+func (s *fakeStore) DeferNotification(ctx context.Context, arg db.DeferNotificationParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outbox[arg.ID-1].NextAttemptAt = arg.NextAttemptAt
+	return nil
+}
+
+// batchNotifier record number of comics of every batch, only limit comics of a batch are delivered if it's not 0
+type batchNotifier struct {
+	mu      sync.Mutex
+	batches map[int32][]int
+	limit   int
+}
+
+func (b *batchNotifier) Notify(ctx context.Context, user *db.User, comic *db.Comic) error {
+	_, err := b.NotifyBatch(ctx, user, []db.Comic{*comic})
+	return err
+}
+
+func (b *batchNotifier) NotifyBatch(ctx context.Context, user *db.User, comics []db.Comic) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.batches[user.ID] = append(b.batches[user.ID], len(comics))
+	if b.limit != 0 && len(comics) > b.limit {
+		return b.limit, errors.New("timeout")
+	}
+	return len(comics), nil
+}
+
+func TestGroupByUser(t *testing.T) {
+
+	batches := groupByUser([]db.Notification{{ID: 1, UserID: 2}, {ID: 2, UserID: 1}, {ID: 3, UserID: 2}})
+	require.Equal(t, [][]db.Notification{
+		{{ID: 1, UserID: 2}, {ID: 3, UserID: 2}},
+		{{ID: 2, UserID: 1}},
+	}, batches)
+}
+
+func TestDrainNotificationsPerUser(t *testing.T) {
+
+	s := newFakeStore(12, 2)
+	for _, chap := range s.chapters {
+		s.queueNotifications(chap)
+	}
+
+	// User 2 get daily digest, chapters queued now wait for the delivery time
+	now := time.Now()
+	s.users[1].DigestMode = db.DigestDaily
+	s.users[1].DigestHour = 20
+	for i := range s.outbox {
+		s.outbox[i].CreatedAt = now
+	}
+
+	rec := &batchNotifier{batches: map[int32][]int{}, limit: 10}
+	notifiers = map[string]Notifier{channelMessenger: rec}
+
+	drainNotifications(context.Background(), s, 3)
+
+	// All chapters of user 1 are sent in one batch, the ones which aren't delivered are retried
+	require.Equal(t, map[int32][]int{1: {12}}, rec.batches)
+
+	status := map[int32]map[string]int{1: {}, 2: {}}
+	for _, n := range s.outbox {
+		status[n.UserID][n.Status]++
+		if n.UserID == 2 {
+			require.Equal(t, nextDigest(&s.users[1], now, digestLocation), n.NextAttemptAt)
+			require.Zero(t, n.Attempts)
+		} else if n.Status == db.NotificationPending {
+			require.Equal(t, "timeout", n.LastError.String)
+		}
+	}
+	require.Equal(t, map[int32]map[string]int{
+		1: {db.NotificationSent: 10, db.NotificationPending: 2},
+		2: {db.NotificationPending: 12},
+	}, status)
+}
+
+func TestDrainNotificationsSameComic(t *testing.T) {
+
+	s := newFakeStore(1, 1)
+	for i := 2; i <= 3; i++ {
+		url := fmt.Sprintf("https://test.vn/comic-1/%d", i)
+		s.chapters = append(s.chapters, db.Chapter{ID: int32(i), ComicID: 1, Name: fmt.Sprintf("Chapter %d", i), Url: url})
+	}
+	for _, chap := range s.chapters {
+		s.queueNotifications(chap)
+	}
+
+	rec := &batchNotifier{batches: map[int32][]int{}}
+	notifiers = map[string]Notifier{channelMessenger: rec}
+
+	// New chapters of the same comic are sent together
+	drainNotifications(context.Background(), s, 1)
+	require.Equal(t, map[int32][]int{1: {3}}, rec.batches)
+	require.Zero(t, s.pending())
+
+	// Chapter waiting for retry hold back later chapters of its comic
+	s.chapters = append(s.chapters, db.Chapter{ID: 4, ComicID: 1, Name: "Chapter 4", Url: "https://test.vn/comic-1/4"})
+	s.chapters = append(s.chapters, db.Chapter{ID: 5, ComicID: 1, Name: "Chapter 5", Url: "https://test.vn/comic-1/5"})
+	s.queueNotifications(s.chapters[3])
+	s.queueNotifications(s.chapters[4])
+	s.outbox[3].NextAttemptAt = time.Now().Add(time.Hour)
+
+	due, err := s.ListDueNotifications(context.Background(), 10)
+	require.Nil(t, err)
+	require.Empty(t, due)
+}
+
+func TestWaitForNotificationsDuringSweep(t *testing.T) {
+
+	defer atomic.StoreInt32(&sweepRunning, 0)
+	wake := make(chan struct{}, 1)
+
+	require.True(t, waitForNotifications(context.Background(), wake, time.Millisecond))
+
+	// Polls are skipped while sweep runs, only wake-up ends the wait
+	atomic.StoreInt32(&sweepRunning, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.False(t, waitForNotifications(ctx, wake, time.Millisecond))
+
+	wakeNotifyService(wake)
+	require.True(t, waitForNotifications(context.Background(), wake, time.Hour))
+}
+
+func TestNotifyTimeout(t *testing.T) {
+
+	require.Equal(t, 15*time.Second, notifyTimeout(1))
+	require.Equal(t, 15*time.Second, notifyTimeout(maxTemplateElements))
+	require.Equal(t, 30*time.Second, notifyTimeout(maxTemplateElements+1))
+}
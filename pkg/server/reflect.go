@@ -9,12 +9,60 @@ import (
 
 const (
 	defaultQuery    = ""
-	defaultPageSize = 0
+	defaultPageSize = 50
+	maxPageSize     = 100
 	defaultOffset   = 0
 )
 
+// Fields to sort comics by, see api.Sort
+const (
+	sortByName       = "name"
+	sortByLastUpdate = "last_update"
+	sortBySubscribed = "subscribed"
+)
+
 func listArgs(q *api.Q, limit *api.Limit, offset *api.Offset) (string, int, int) {
-	return toString(q, defaultQuery), toInt(limit, defaultPageSize), toInt(offset, defaultOffset)
+
+	pageSize := toInt(limit, defaultPageSize)
+	if pageSize < 0 || pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	start := toInt(offset, defaultOffset)
+	if start < 0 {
+		start = defaultOffset
+	}
+
+	return toString(q, defaultQuery), pageSize, start
+}
+
+// sortArgs return field to sort by and whether it's sorted descending, names are sorted ascending
+// and dates descending unless order is given
+func sortArgs(sort, order interface{}, defaultSort string) (string, bool) {
+
+	sortBy := toEnum(sort)
+	if sortBy == "" {
+		sortBy = defaultSort
+	}
+
+	switch toEnum(order) {
+	case string(api.Asc):
+		return sortBy, false
+	case string(api.Desc):
+		return sortBy, true
+	}
+
+	return sortBy, sortBy != sortByName
+}
+
+// toEnum return value of optional string parameter, empty if it's not set
+func toEnum(v interface{}) string {
+	drv, _, _ := derefPointersZero(reflect.ValueOf(v))
+	if drv.Kind() == reflect.String {
+		return drv.String()
+	}
+
+	return ""
 }
 
 func toString(v interface{}, defaultValue string) string {
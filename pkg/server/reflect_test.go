@@ -0,0 +1,55 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tinoquang/comic-notifier/pkg/api"
+)
+
+func TestListArgs(t *testing.T) {
+
+	q, limit, offset := listArgs(nil, nil, nil)
+	require.Equal(t, "%%", q)
+	require.Equal(t, defaultPageSize, limit)
+	require.Equal(t, defaultOffset, offset)
+
+	name := api.Q("one piece")
+	size, start := api.Limit(500), api.Offset(-1)
+	q, limit, offset = listArgs(&name, &size, &start)
+	require.Equal(t, "%one%piece%", q)
+	require.Equal(t, maxPageSize, limit)
+	require.Equal(t, defaultOffset, offset)
+
+	size, start = api.Limit(10), api.Offset(20)
+	_, limit, offset = listArgs(nil, &size, &start)
+	require.Equal(t, 10, limit)
+	require.Equal(t, 20, offset)
+
+	// Zero limit gets default page instead of an empty one
+	size = api.Limit(0)
+	_, limit, _ = listArgs(nil, &size, nil)
+	require.Equal(t, defaultPageSize, limit)
+}
+
+func TestSortArgs(t *testing.T) {
+
+	sortBy, descending := sortArgs((*api.GetUserComicsParamsSort)(nil), (*api.GetUserComicsParamsOrder)(nil), sortBySubscribed)
+	require.Equal(t, sortBySubscribed, sortBy)
+	require.True(t, descending)
+
+	name := api.ComicsParamsSort(api.Name)
+	sortBy, descending = sortArgs(&name, (*api.ComicsParamsOrder)(nil), "")
+	require.Equal(t, sortByName, sortBy)
+	require.False(t, descending)
+
+	desc := api.ComicsParamsOrder(api.Desc)
+	_, descending = sortArgs(&name, &desc, "")
+	require.True(t, descending)
+
+	lastUpdate := api.ComicsParamsSort(api.LastUpdate)
+	asc := api.ComicsParamsOrder(api.Asc)
+	sortBy, descending = sortArgs(&lastUpdate, &asc, "")
+	require.Equal(t, sortByLastUpdate, sortBy)
+	require.False(t, descending)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+)
+
+const (
+	scheduleHistory = 10             // number of latest releases used to estimate release cadence
+	releaseWindow   = 24 * time.Hour // comic is checked every interval from this long before its expected release
+	maxCheckDelay   = 24 * time.Hour // comic is checked at least daily, including dormant comics
+)
+
+// nextCheckAt estimate when comic should be crawled again from its chapter history, ordered oldest first.
+// Comic is checked every interval around its expected release day, otherwise it waits for the release window,
+// a dormant comic which misses its release for longer than its usual cadence is checked daily
+func nextCheckAt(chapters []db.Chapter, now time.Time, interval time.Duration) time.Time {
+
+	releases := releaseDays(chapters)
+	if len(releases) < 2 {
+		return now.Add(interval)
+	}
+
+	cadence := medianGap(releases)
+	expected := releases[len(releases)-1].Add(cadence)
+
+	switch {
+	case now.After(expected.Add(cadence + releaseWindow)):
+		return now.Add(maxCheckDelay)
+	case now.After(expected.Add(-releaseWindow)):
+		return now.Add(interval)
+	}
+
+	next := expected.Add(-releaseWindow)
+	if next.Sub(now) > maxCheckDelay {
+		next = now.Add(maxCheckDelay)
+	}
+	if next.Sub(now) < interval {
+		next = now.Add(interval)
+	}
+
+	return next
+}
+
+// releaseDays return distinct days on which chapters are released, ascending and limited to latest scheduleHistory+1 days.
+// Chapter without published date is considered released when it was found
+func releaseDays(chapters []db.Chapter) []time.Time {
+
+	days := []time.Time{}
+	seen := map[time.Time]bool{}
+	for _, chap := range chapters {
+		t := chap.CreatedAt
+		if chap.PublishedDate.Valid {
+			t = chap.PublishedDate.Time
+		}
+		if t.IsZero() {
+			continue
+		}
+
+		day := t.UTC().Truncate(24 * time.Hour)
+		if !seen[day] {
+			seen[day] = true
+			days = append(days, day)
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	if len(days) > scheduleHistory+1 {
+		days = days[len(days)-scheduleHistory-1:]
+	}
+
+	return days
+}
+
+// medianGap return median duration between consecutive releases, releases must be ascending
+func medianGap(releases []time.Time) time.Duration {
+
+	gaps := make([]time.Duration, 0, len(releases)-1)
+	for i := 1; i < len(releases); i++ {
+		gaps = append(gaps, releases[i].Sub(releases[i-1]))
+	}
+
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+
+	return gaps[len(gaps)/2]
+}
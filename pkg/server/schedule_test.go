@@ -0,0 +1,48 @@
+package server
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+)
+
+// releasedEvery return chapters released every gap, latest one is released at last
+func releasedEvery(gap time.Duration, last time.Time, count int) []db.Chapter {
+
+	chapters := []db.Chapter{}
+	for i := count - 1; i >= 0; i-- {
+		chapters = append(chapters, db.Chapter{PublishedDate: sql.NullTime{Time: last.Add(-time.Duration(i) * gap), Valid: true}})
+	}
+	return chapters
+}
+
+func TestNextCheckAtWithoutHistory(t *testing.T) {
+
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+	require.Equal(t, now.Add(30*time.Minute), nextCheckAt(nil, now, 30*time.Minute))
+	require.Equal(t, now.Add(30*time.Minute), nextCheckAt(releasedEvery(0, now, 3), now, 30*time.Minute))
+}
+
+func TestNextCheckAtWeekly(t *testing.T) {
+
+	week := 7 * 24 * time.Hour
+	interval := 30 * time.Minute
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC) }
+
+	// Next release is 5 days later, check daily until then
+	require.Equal(t, now.Add(maxCheckDelay), nextCheckAt(releasedEvery(week, day(8), 5), now, interval))
+
+	// Release window starts in 12 hours
+	require.Equal(t, day(11), nextCheckAt(releasedEvery(week, day(5), 5), now, interval))
+
+	// Release is expected tomorrow or is late
+	require.Equal(t, now.Add(interval), nextCheckAt(releasedEvery(week, day(4), 5), now, interval))
+	require.Equal(t, now.Add(interval), nextCheckAt(releasedEvery(week, day(2), 5), now, interval))
+
+	// Release is missed for more than a week, comic is dormant
+	require.Equal(t, now.Add(maxCheckDelay), nextCheckAt(releasedEvery(week, day(1).Add(-3*week), 5), now, interval))
+}
@@ -3,46 +3,107 @@ package server
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/tinoquang/comic-notifier/pkg/conf"
 	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/messenger"
 )
 
 // Server implement main business logic
 type Server struct {
 	API *API
 	Msg *MSG
+
+	services sync.WaitGroup // update and notify services, and API jobs
 }
 
 var (
+	sendAPI           *messenger.Client
 	messengerEndpoint string
 	pageToken         string
 	webhookToken      string
+	adminPSID         string
+	adminAppIDs       []string
 )
 
 // Crawler contain comic, user and image crawler
 type infoCrawler interface {
-	GetComicInfo(ctx context.Context, comicURL string, checkSpoiler bool) (comic db.Comic, err error)
-	GetUserInfoFromFacebook(field, id string) (user db.User, err error)
+	GetComicInfo(ctx context.Context, comicURL string) (comic db.Comic, chapters []db.Chapter, err error)
+	GetComicUpdate(ctx context.Context, comicURL string, cache db.PageCache) (comic db.Comic, chapters []db.Chapter, newCache db.PageCache, err error)
+	GetChapterStats(ctx context.Context, chapURL string) (images, pageSize int, err error)
+	SearchComic(ctx context.Context, keyword string) ([]db.Comic, error)
+	GetUserInfoFromFacebook(field, id, pageToken string) (user db.User, err error)
 }
 
-// New  create new server
-func New(store db.Store, crawler infoCrawler) *Server {
+// New  create new server, update and notify services run until ctx is cancelled
+func New(ctx context.Context, store db.Store, crawler infoCrawler) *Server {
 
 	// Get env config
 	messengerEndpoint = conf.Cfg.Webhook.GraphEndpoint + "/me/messages"
+	sendAPI = messenger.NewClient(messengerEndpoint)
 	webhookToken = conf.Cfg.Webhook.WebhookToken
 	pageToken = conf.Cfg.FBSecret.PakeToken
+	adminPSID = conf.Cfg.Admin.PSID
+	adminAppIDs = conf.Cfg.Admin.AppIDs
+
+	// Messages of pages saved in DB are answered with their own token, others with FBSECRET_PAGE_TOKEN
+	pages = newMessengerPages(store, messengerPageTTL)
+
+	initNotifiers()
+
+	loc, err := time.LoadLocation(conf.Cfg.Notifier.DigestTimezone)
+	if err != nil {
+		logging.Ctx(ctx).Warning("Can't load digest timezone, use UTC, err", err)
+	} else {
+		digestLocation = loc
+	}
+
+	// Update service wakes notify service up when new chapters are queued in notifications outbox,
+	// admin API also triggers update sweeps and wakes notify service up for retried notifications
+	wake := make(chan struct{}, 1)
+	sweep := make(chan struct{}, 1)
+	interval := time.Duration(conf.Cfg.WrkDat.Timeout) * time.Minute
+
+	// Messenger and REST API subscribe comics through the same service
+	subscriber := NewSubscribeService(store, crawler)
 
 	s := &Server{
-		API: NewAPI(store),
-		Msg: NewMSG(store, crawler),
+		API: NewAPI(store, crawler, subscriber, interval, wake, sweep),
+		Msg: NewMSG(store, crawler, subscriber),
 	}
 
-	// Start update-comic
-	updateLock := sync.Mutex{} // using lock to avoid updateService and notifyService run simuteneously
+	s.API.ctx, s.API.jobs = ctx, &s.services
+
+	s.services.Add(2)
+	go func() {
+		defer s.services.Done()
+		updateComicService(ctx, crawler, store, conf.Cfg.WrkDat.WorkerNum, interval, wake, sweep)
+	}()
+	go func() {
+		defer s.services.Done()
+		notifyService(ctx, store, conf.Cfg.WrkDat.NotifyWorkerNum, wake)
+	}()
 
-	initUpdateService(&updateLock, crawler, store, conf.Cfg.WrkDat.WorkerNum, conf.Cfg.WrkDat.Timeout)
-	initNotifyService(&updateLock)
 	return s
 }
+
+// Shutdown wait until update and notify services finish their running sweep and batch, and running broadcasts stop,
+// after context passed to New is cancelled, or ctx is done. Work which isn't finished stays in DB: due comics keep their next check and unsent
+// notifications stay pending in outbox, so they're picked up after restart
+func (s *Server) Shutdown(ctx context.Context) error {
+
+	done := make(chan struct{})
+	go func() {
+		s.services.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerShutdown(t *testing.T) {
+
+	s := &Server{}
+	s.services.Add(1)
+
+	// Services which don't finish in time are left behind
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+
+	go s.services.Done()
+	require.Nil(t, s.Shutdown(context.Background()))
+}
@@ -0,0 +1,561 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+// fakeStore keep comics, chapters and notifications outbox in memory,
+// only methods used by update and notify services are implemented
+type fakeStore struct {
+	db.Store
+	mu          sync.Mutex
+	comics      map[int32]db.Comic
+	users       []db.User
+	chapters    []db.Chapter
+	outbox      []db.Notification
+	sites       map[string]db.SiteHealth
+	subscribers []db.Subscriber
+}
+
+func newFakeStore(comicNum, userNum int) *fakeStore {
+
+	s := &fakeStore{comics: map[int32]db.Comic{}, sites: map[string]db.SiteHealth{}}
+	for i := 1; i <= comicNum; i++ {
+		url := fmt.Sprintf("https://test.vn/comic-%d", i)
+		s.comics[int32(i)] = db.Comic{ID: int32(i), Page: "test.vn", Name: url, Url: url, ChapUrl: url + "/1"}
+		s.chapters = append(s.chapters, db.Chapter{ID: int32(len(s.chapters) + 1), ComicID: int32(i), Name: "Chapter 1", Url: url + "/1"})
+	}
+
+	for i := 1; i <= userNum; i++ {
+		s.users = append(s.users, db.User{ID: int32(i), NotifyChannel: channelMessenger})
+	}
+
+	return s
+}
+
+// ListDueComics ignore comic's schedule, so every sweep crawl all comics
+func (s *fakeStore) ListDueComics(ctx context.Context) ([]db.Comic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comics := []db.Comic{}
+	for _, c := range s.comics {
+		comics = append(comics, c)
+	}
+	return comics, nil
+}
+
+func (s *fakeStore) UpdateComicNextCheck(ctx context.Context, arg db.UpdateComicNextCheckParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.comics[arg.ID]
+	c.NextCheckAt = arg.NextCheckAt
+	s.comics[arg.ID] = c
+	return nil
+}
+
+func (s *fakeStore) RecordComicCrawlFailure(ctx context.Context, arg db.RecordComicCrawlFailureParams) (int32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.comics[arg.ID]
+	c.CrawlFailures++
+	c.LastCrawlError = arg.LastCrawlError
+	s.comics[arg.ID] = c
+	return c.CrawlFailures, nil
+}
+
+func (s *fakeStore) RecordComicCrawlSuccess(ctx context.Context, id int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.comics[id]
+	c.CrawlFailures = 0
+	c.LastCrawlError = ""
+	c.LastSuccessAt = sql.NullTime{Time: time.Now(), Valid: true}
+	s.comics[id] = c
+	return nil
+}
+
+func (s *fakeStore) GetSiteHealth(ctx context.Context, page string) (db.SiteHealth, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	health, ok := s.sites[page]
+	if !ok {
+		return db.SiteHealth{}, sql.ErrNoRows
+	}
+	return health, nil
+}
+
+func (s *fakeStore) UpsertSiteHealth(ctx context.Context, arg db.UpsertSiteHealthParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sites[arg.Page] = db.SiteHealth{
+		Page:                arg.Page,
+		Crawls:              arg.Crawls,
+		Failures:            arg.Failures,
+		ConsecutiveFailures: arg.ConsecutiveFailures,
+		LastSuccessAt:       arg.LastSuccessAt,
+		LastError:           arg.LastError,
+		LastErrorAt:         arg.LastErrorAt,
+		Broken:              arg.Broken,
+		BrokenSince:         arg.BrokenSince,
+		UpdatedAt:           time.Now(),
+	}
+	return nil
+}
+
+func (s *fakeStore) GetPageCache(ctx context.Context, comicID int32) (db.PageCache, error) {
+	return db.PageCache{}, sql.ErrNoRows
+}
+
+func (s *fakeStore) UpsertPageCache(ctx context.Context, arg db.UpsertPageCacheParams) error {
+	return nil
+}
+
+func (s *fakeStore) SyncComicImage(comic *db.Comic) error {
+	return nil
+}
+
+func (s *fakeStore) ListChaptersPerComic(ctx context.Context, comicID int32) ([]db.Chapter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chapters := []db.Chapter{}
+	for _, chap := range s.chapters {
+		if chap.ComicID == comicID {
+			chapters = append(chapters, chap)
+		}
+	}
+	return chapters, nil
+}
+
+func (s *fakeStore) UpdateNewChapter(ctx context.Context, comic *db.Comic, chapters, newChapters []db.Chapter, oldImgURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only fields saved by db.UpdateComic are changed
+	c := s.comics[comic.ID]
+	c.LatestChap = comic.LatestChap
+	c.ChapUrl = comic.ChapUrl
+	c.ImgUrl = comic.ImgUrl
+	c.CloudImgUrl = comic.CloudImgUrl
+	c.LastUpdate = comic.LastUpdate
+	s.comics[comic.ID] = c
+
+	for _, chap := range chapters {
+		chap.ID = int32(len(s.chapters) + 1)
+		chap.ComicID = comic.ID
+		chap.CreatedAt = time.Now()
+		s.chapters = append(s.chapters, chap)
+	}
+
+	for _, newChap := range newChapters {
+		for _, chap := range s.chapters {
+			if chap.ComicID == comic.ID && chap.Url == newChap.Url {
+				s.queueNotifications(chap)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *fakeStore) ReleaseChapter(ctx context.Context, chap db.Chapter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chap.Status = db.ChapterReleased
+	s.chapters[chap.ID-1] = chap
+	s.queueNotifications(chap)
+
+	// Same as db.UpdateComicLatestChapter, chapter ID increases with release order
+	c := s.comics[chap.ComicID]
+	for _, released := range s.chapters {
+		if released.ComicID == chap.ComicID && released.Status != db.ChapterQuarantined {
+			c.LatestChap, c.ChapUrl = released.Name, released.Url
+		}
+	}
+	s.comics[chap.ComicID] = c
+	return nil
+}
+
+// queueNotifications add notifications of chapter for every user, caller must hold s.mu
+func (s *fakeStore) queueNotifications(chap db.Chapter) {
+	for _, u := range s.users {
+		s.outbox = append(s.outbox, db.Notification{
+			ID:            int32(len(s.outbox) + 1),
+			UserID:        u.ID,
+			ComicID:       chap.ComicID,
+			ChapterID:     chap.ID,
+			Status:        db.NotificationPending,
+			NextAttemptAt: time.Now(),
+		})
+	}
+}
+
+func (s *fakeStore) InitLastReadChapters(ctx context.Context, arg db.InitLastReadChaptersParams) error {
+	return nil
+}
+
+func (s *fakeStore) ListDueNotifications(ctx context.Context, limit int32) ([]db.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []db.Notification{}
+	waiting := map[string]bool{}
+	for _, n := range s.outbox {
+		key := fmt.Sprintf("%d-%d", n.UserID, n.ComicID)
+		if n.Status != db.NotificationPending || waiting[key] {
+			continue
+		}
+
+		if !n.NextAttemptAt.Before(time.Now()) {
+			waiting[key] = true
+			continue
+		}
+
+		if len(due) < int(limit) {
+			due = append(due, n)
+		}
+	}
+	return due, nil
+}
+
+func (s *fakeStore) UpdateNotificationStatus(ctx context.Context, arg db.UpdateNotificationStatusParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := &s.outbox[arg.ID-1]
+	n.Status = arg.Status
+	n.Attempts++
+	n.NextAttemptAt = arg.NextAttemptAt
+	n.LastError = arg.LastError
+	return nil
+}
+
+func (s *fakeStore) GetUser(ctx context.Context, id int32) (db.User, error) {
+	return s.users[id-1], nil
+}
+
+func (s *fakeStore) GetComic(ctx context.Context, id int32) (db.Comic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.comics[id]
+	if !ok {
+		return c, sql.ErrNoRows
+	}
+	return c, nil
+}
+
+func (s *fakeStore) GetChapter(ctx context.Context, id int32) (db.Chapter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.chapters[id-1], nil
+}
+
+// pending return number of notifications which are not sent yet
+func (s *fakeStore) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, n := range s.outbox {
+		if n.Status != db.NotificationSent {
+			count++
+		}
+	}
+	return count
+}
+
+// fakeCrawler release one more chapter of a comic each time it's crawled, until maxChap
+type fakeCrawler struct {
+	mu          sync.Mutex
+	crawls      map[string]int
+	maxChap     int
+	notModified bool                                   // GetComicUpdate report page is unchanged
+	failing     bool                                   // GetComicUpdate fails to crawl
+	throttled   bool                                   // GetComicUpdate is put off by host limiter
+	stats       func(chapURL string) (int, int, error) // GetChapterStats result, nil means spoiler check is not supported
+}
+
+func (c *fakeCrawler) GetComicInfo(ctx context.Context, comicURL string) (comic db.Comic, chapters []db.Chapter, err error) {
+	c.mu.Lock()
+	c.crawls[comicURL]++
+	latest := c.crawls[comicURL] + 1
+	c.mu.Unlock()
+
+	if latest > c.maxChap {
+		latest = c.maxChap
+	}
+
+	for i := 1; i <= latest; i++ {
+		chapters = append(chapters, db.Chapter{Name: fmt.Sprintf("Chapter %d", i), Url: fmt.Sprintf("%s/%d", comicURL, i)})
+	}
+
+	comic = db.Comic{
+		Page:       "test.vn",
+		Name:       comicURL,
+		Url:        comicURL,
+		LatestChap: chapters[latest-1].Name,
+		ChapUrl:    chapters[latest-1].Url,
+		LastUpdate: time.Now(),
+	}
+	return
+}
+
+func (c *fakeCrawler) GetComicUpdate(ctx context.Context, comicURL string, cache db.PageCache) (comic db.Comic, chapters []db.Chapter, newCache db.PageCache, err error) {
+	if c.failing {
+		return comic, chapters, cache, util.ErrCrawlFailed
+	}
+	if c.throttled {
+		return comic, chapters, cache, util.ErrThrottled
+	}
+	if c.notModified {
+		return comic, chapters, cache, util.ErrNotModified
+	}
+
+	comic, chapters, err = c.GetComicInfo(ctx, comicURL)
+	return comic, chapters, cache, err
+}
+
+func (c *fakeCrawler) GetChapterStats(ctx context.Context, chapURL string) (images, pageSize int, err error) {
+	if c.stats == nil {
+		return 0, 0, util.ErrSpoilerCheckNotSupported
+	}
+
+	return c.stats(chapURL)
+}
+
+func (c *fakeCrawler) SearchComic(ctx context.Context, keyword string) ([]db.Comic, error) {
+	return []db.Comic{}, nil
+}
+
+func (c *fakeCrawler) GetUserInfoFromFacebook(field, id, pageToken string) (user db.User, err error) {
+	return
+}
+
+// recordNotifier save chapters sent to each user per comic
+type recordNotifier struct {
+	mu   sync.Mutex
+	sent map[string][]string
+}
+
+func (r *recordNotifier) Notify(ctx context.Context, user *db.User, comic *db.Comic) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := fmt.Sprintf("%d-%d", user.ID, comic.ID)
+	r.sent[key] = append(r.sent[key], comic.ChapUrl)
+	return nil
+}
+
+func (r *recordNotifier) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, chaps := range r.sent {
+		count += len(chaps)
+	}
+	return count
+}
+
+func runServices(ctx context.Context, s *fakeStore, crwl *fakeCrawler) *sync.WaitGroup {
+
+	var wg sync.WaitGroup
+	wake := make(chan struct{}, 1)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		updateComicService(ctx, crwl, s, 2, time.Millisecond, wake, nil)
+	}()
+	go func() {
+		defer wg.Done()
+		notifyService(ctx, s, 3, wake)
+	}()
+
+	return &wg
+}
+
+// requireSentInOrder verify every queued notification is sent exactly once, outbox is ordered by chapter per user and comic
+func requireSentInOrder(t *testing.T, s *fakeStore, rec *recordNotifier) {
+
+	expected := map[string][]string{}
+	for _, n := range s.outbox {
+		key := fmt.Sprintf("%d-%d", n.UserID, n.ComicID)
+		expected[key] = append(expected[key], s.chapters[n.ChapterID-1].Url)
+	}
+
+	require.Equal(t, expected, rec.sent)
+}
+
+func TestServicesRunConcurrently(t *testing.T) {
+
+	s := newFakeStore(3, 4)
+	crwl := &fakeCrawler{crawls: map[string]int{}, maxChap: 6}
+	rec := &recordNotifier{sent: map[string][]string{}}
+	notifiers = map[string]Notifier{channelMessenger: rec}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := runServices(ctx, s, crwl)
+
+	// Each user receive chapter 2 to 6 of every comic
+	require.Eventually(t, func() bool {
+		return rec.count() == 3*4*5 && s.pending() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	wg.Wait()
+
+	requireSentInOrder(t, s, rec)
+	for _, chaps := range rec.sent {
+		require.Len(t, chaps, 5)
+		require.Contains(t, chaps[0], "/2")
+		require.Contains(t, chaps[4], "/6")
+	}
+}
+
+func TestServicesShutdownKeepNotifications(t *testing.T) {
+
+	s := newFakeStore(3, 4)
+	crwl := &fakeCrawler{crawls: map[string]int{}, maxChap: 50}
+	rec := &recordNotifier{sent: map[string][]string{}}
+	notifiers = map[string]Notifier{channelMessenger: rec}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := runServices(ctx, s, crwl)
+
+	require.Eventually(t, func() bool {
+		return rec.count() > 0
+	}, 5*time.Second, time.Millisecond)
+
+	// Stop both services in the middle of their work
+	cancel()
+	wg.Wait()
+
+	// Notifications queued before shutdown are still in outbox and sent after restart
+	drainNotifications(context.Background(), s, 3)
+	require.Equal(t, 0, s.pending())
+	requireSentInOrder(t, s, rec)
+}
+
+func TestUpdateComicNotModified(t *testing.T) {
+
+	s := newFakeStore(1, 1)
+	crwl := &fakeCrawler{crawls: map[string]int{}, maxChap: 6, notModified: true}
+
+	history, err := updateComic(context.Background(), s, crwl, s.comics[1], make(chan struct{}, 1), newSiteOutcomes())
+	require.Nil(t, err)
+	require.Len(t, history, 1)
+	require.Empty(t, s.outbox)
+	require.Equal(t, "https://test.vn/comic-1/1", s.comics[1].ChapUrl)
+}
+
+func TestQuarantineChapterUntilComplete(t *testing.T) {
+
+	s := newFakeStore(1, 2)
+	uploaded := 2
+	crwl := &fakeCrawler{crawls: map[string]int{}, maxChap: 2, stats: func(chapURL string) (int, int, error) {
+		return uploaded, uploaded * 1000, nil
+	}}
+
+	// Only 2 images of new chapter are uploaded, nobody is notified
+	updateComic(context.Background(), s, crwl, s.comics[1], make(chan struct{}, 1), newSiteOutcomes())
+	require.Equal(t, db.ChapterQuarantined, s.chapters[1].Status)
+	require.Empty(t, s.outbox)
+
+	// Comic keeps showing its released chapter
+	require.Equal(t, "Chapter 1", s.comics[1].LatestChap)
+	require.Equal(t, "https://test.vn/comic-1/1", s.comics[1].ChapUrl)
+
+	// Chapter is checked again though comic page is unchanged
+	crwl.notModified = true
+	updateComic(context.Background(), s, crwl, s.comics[1], make(chan struct{}, 1), newSiteOutcomes())
+	require.Empty(t, s.outbox)
+
+	uploaded = 20
+	wake := make(chan struct{}, 1)
+	updateComic(context.Background(), s, crwl, s.comics[1], wake, newSiteOutcomes())
+	require.Equal(t, db.ChapterReleased, s.chapters[1].Status)
+	require.Equal(t, int32(20), s.chapters[1].ImageCount)
+	require.Equal(t, "https://test.vn/comic-1/2", s.comics[1].ChapUrl)
+	require.Len(t, s.outbox, 2)
+	require.Len(t, wake, 1)
+}
+
+func TestCrawlFailuresAreCounted(t *testing.T) {
+
+	s := newFakeStore(1, 1)
+	crwl := &fakeCrawler{crawls: map[string]int{}, maxChap: 2, failing: true}
+
+	for i := 0; i < db.FailoverCrawlFailures; i++ {
+		history, err := updateComic(context.Background(), s, crwl, s.comics[1], make(chan struct{}, 1), newSiteOutcomes())
+		require.Nil(t, err)
+		require.Nil(t, history)
+	}
+	require.Equal(t, int32(db.FailoverCrawlFailures), s.comics[1].CrawlFailures)
+	require.Equal(t, crawlErrFetch, s.comics[1].LastCrawlError)
+	require.False(t, s.comics[1].LastSuccessAt.Valid)
+
+	// Source recovers
+	crwl.failing = false
+	updateComic(context.Background(), s, crwl, s.comics[1], make(chan struct{}, 1), newSiteOutcomes())
+	require.Zero(t, s.comics[1].CrawlFailures)
+	require.Empty(t, s.comics[1].LastCrawlError)
+	require.True(t, s.comics[1].LastSuccessAt.Valid)
+	require.Len(t, s.outbox, 1)
+}
+
+func TestChapterLayoutChangedFailsCrawl(t *testing.T) {
+
+	s := newFakeStore(1, 1)
+	crwl := &fakeCrawler{crawls: map[string]int{}, maxChap: 2, stats: func(chapURL string) (int, int, error) {
+		return 0, 0, errors.Wrap(util.ErrLayoutChanged, "Image list is missing")
+	}}
+	outcomes := newSiteOutcomes()
+
+	// Chapter isn't quarantined as a spoiler, it's found again once site definition is fixed
+	history, err := updateComic(context.Background(), s, crwl, s.comics[1], make(chan struct{}, 1), outcomes)
+	require.Nil(t, err)
+	require.Nil(t, history)
+	require.Len(t, s.chapters, 1)
+	require.Empty(t, s.outbox)
+	require.Equal(t, int32(1), s.comics[1].CrawlFailures)
+	require.Equal(t, crawlErrLayout, s.comics[1].LastCrawlError)
+	require.Equal(t, crawlErrLayout, outcomes.sites["test.vn"].lastError)
+}
+
+func TestThrottledComicStaysDue(t *testing.T) {
+
+	s := newFakeStore(1, 1)
+	crwl := &fakeCrawler{crawls: map[string]int{}, maxChap: 2, throttled: true}
+	outcomes := newSiteOutcomes()
+
+	// Waiting for our own rate limiter isn't a crawl failure, comic isn't rescheduled
+	require.Nil(t, checkComic(context.Background(), s, crwl, s.comics[1], time.Hour, make(chan struct{}, 1), outcomes))
+	require.Zero(t, s.comics[1].CrawlFailures)
+	require.Empty(t, s.comics[1].LastCrawlError)
+	require.True(t, s.comics[1].NextCheckAt.IsZero())
+	require.Empty(t, outcomes.sites)
+
+	crwl.throttled = false
+	require.NotNil(t, checkComic(context.Background(), s, crwl, s.comics[1], time.Hour, make(chan struct{}, 1), outcomes))
+	require.True(t, s.comics[1].NextCheckAt.After(time.Now()))
+}
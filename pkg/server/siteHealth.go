@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+// Site is flagged broken when most of its comics fail in one sweep, or when its comics keep failing across sweeps
+// which crawl too few of them to tell a failure rate. It recovers on the first sweep with a normal failure rate
+const (
+	brokenFailureRate         = 0.8
+	minSiteCrawls             = 3
+	brokenConsecutiveFailures = 10
+)
+
+// Kinds of crawl error saved in comic and site health
+const (
+	crawlErrTimeout = "timeout"
+	crawlErrFetch   = "fetch"
+	crawlErrLayout  = "layout"
+	crawlErrUnknown = "unknown"
+)
+
+// crawlErrorKind classify crawl error, a site redesign shows up as layout errors
+func crawlErrorKind(err error) string {
+
+	switch errors.Cause(err) {
+	case util.ErrCrawlTimeout:
+		return crawlErrTimeout
+	case util.ErrCrawlFailed:
+		return crawlErrFetch
+	case util.ErrLayoutChanged:
+		return crawlErrLayout
+	}
+
+	return crawlErrUnknown
+}
+
+// crawlOutcome label crawl result in metrics
+func crawlOutcome(crawlErr error) string {
+
+	switch crawlErr {
+	case nil:
+		return "success"
+	case util.ErrNotModified:
+		return "not_modified"
+	case util.ErrThrottled:
+		return "throttled"
+	}
+
+	return crawlErrorKind(crawlErr)
+}
+
+// siteOutcomes collect crawl outcomes of each site during one sweep, it's shared by all workers of the sweep
+type siteOutcomes struct {
+	mu    sync.Mutex
+	sites map[string]*siteOutcome
+}
+
+type siteOutcome struct {
+	crawls           int32
+	failures         int32
+	trailingFailures int32 // failures since the last successful crawl of the sweep
+	lastSuccess      time.Time
+	lastError        string
+	lastErrorAt      time.Time
+}
+
+func newSiteOutcomes() *siteOutcomes {
+	return &siteOutcomes{sites: map[string]*siteOutcome{}}
+}
+
+// record count one crawl of site, unmodified page is a successful crawl
+func (o *siteOutcomes) record(page string, crawlErr error, now time.Time) {
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	out, ok := o.sites[page]
+	if !ok {
+		out = &siteOutcome{}
+		o.sites[page] = out
+	}
+
+	out.crawls++
+	if crawlErr == nil || crawlErr == util.ErrNotModified {
+		out.trailingFailures = 0
+		out.lastSuccess = now
+		return
+	}
+
+	out.failures++
+	out.trailingFailures++
+	out.lastError = crawlErrorKind(crawlErr)
+	out.lastErrorAt = now
+}
+
+// updateSiteHealth merge outcomes of a finished sweep into site health, admin is alerted when a site
+// is flagged broken or recovers
+func updateSiteHealth(ctx context.Context, s db.Store, outcomes *siteOutcomes) {
+
+	outcomes.mu.Lock()
+	defer outcomes.mu.Unlock()
+
+	for page, out := range outcomes.sites {
+		log := logging.Ctx(ctx).With(logging.FieldSite, page)
+
+		health, err := s.GetSiteHealth(ctx, page)
+		if err != nil && err != sql.ErrNoRows {
+			log.Danger(err)
+			continue
+		}
+		health.Page = page
+
+		next := nextSiteHealth(health, out, time.Now())
+		if err = s.UpsertSiteHealth(ctx, next); err != nil {
+			log.Danger(err)
+			continue
+		}
+
+		switch {
+		case next.Broken && !health.Broken:
+			alertAdmin(fmt.Sprintf("Site %s looks broken: %d/%d crawls failed in last sweep, %d in a row, last error: %s",
+				page, out.failures, out.crawls, next.ConsecutiveFailures, next.LastError))
+		case !next.Broken && health.Broken:
+			alertAdmin(fmt.Sprintf("Site %s is crawled again: %d/%d crawls succeeded in last sweep",
+				page, out.crawls-out.failures, out.crawls))
+		}
+	}
+}
+
+// nextSiteHealth return site health after one sweep
+func nextSiteHealth(health db.SiteHealth, out *siteOutcome, now time.Time) db.UpsertSiteHealthParams {
+
+	next := db.UpsertSiteHealthParams{
+		Page:                health.Page,
+		Crawls:              health.Crawls + out.crawls,
+		Failures:            health.Failures + out.failures,
+		ConsecutiveFailures: health.ConsecutiveFailures + out.failures,
+		LastSuccessAt:       health.LastSuccessAt,
+		LastError:           health.LastError,
+		LastErrorAt:         health.LastErrorAt,
+		Broken:              health.Broken,
+		BrokenSince:         health.BrokenSince,
+	}
+
+	if !out.lastSuccess.IsZero() {
+		next.ConsecutiveFailures = out.trailingFailures
+		next.LastSuccessAt = sql.NullTime{Time: out.lastSuccess, Valid: true}
+	}
+	if out.lastError != "" {
+		next.LastError = out.lastError
+		next.LastErrorAt = sql.NullTime{Time: out.lastErrorAt, Valid: true}
+	}
+
+	rate := float64(out.failures) / float64(out.crawls)
+	spike := out.crawls >= minSiteCrawls && rate >= brokenFailureRate
+
+	switch {
+	case !health.Broken && (spike || next.ConsecutiveFailures >= brokenConsecutiveFailures):
+		next.Broken = true
+		next.BrokenSince = sql.NullTime{Time: now, Valid: true}
+	case health.Broken && !out.lastSuccess.IsZero() && rate < brokenFailureRate:
+		next.Broken = false
+		next.BrokenSince = sql.NullTime{}
+	}
+
+	return next
+}
+
+// alertAdmin log alert and send it to admin's Messenger if admin is configured
+func alertAdmin(message string) {
+
+	logging.Warning(message)
+
+	if adminPSID == "" {
+		return
+	}
+
+	if err := sendMsgTagsText(context.Background(), adminPSID, message); err != nil {
+		logging.Danger("Can't send alert to admin, err", err)
+	}
+}
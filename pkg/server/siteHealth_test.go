@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+func TestCrawlErrorKind(t *testing.T) {
+
+	require.Equal(t, crawlErrTimeout, crawlErrorKind(util.ErrCrawlTimeout))
+	require.Equal(t, crawlErrFetch, crawlErrorKind(util.ErrCrawlFailed))
+	require.Equal(t, crawlErrLayout, crawlErrorKind(util.ErrLayoutChanged))
+	require.Equal(t, crawlErrLayout, crawlErrorKind(errors.Wrap(util.ErrLayoutChanged, "Comic name is missing")))
+	require.Equal(t, crawlErrUnknown, crawlErrorKind(errors.New("Unknown panic")))
+}
+
+func TestNextSiteHealth(t *testing.T) {
+
+	now := time.Now()
+	sweep := func(successes, failures int) *siteOutcome {
+		o := newSiteOutcomes()
+		for i := 0; i < successes; i++ {
+			o.record("test.vn", nil, now)
+		}
+		for i := 0; i < failures; i++ {
+			o.record("test.vn", util.ErrLayoutChanged, now)
+		}
+		return o.sites["test.vn"]
+	}
+
+	// Few failures are not a spike
+	next := nextSiteHealth(db.SiteHealth{Page: "test.vn"}, sweep(8, 2), now)
+	require.False(t, next.Broken)
+	require.Equal(t, int32(2), next.ConsecutiveFailures)
+	require.Equal(t, crawlErrLayout, next.LastError)
+	require.True(t, next.LastSuccessAt.Valid)
+
+	// Most comics fail in one sweep
+	next = nextSiteHealth(db.SiteHealth{Page: "test.vn"}, sweep(1, 9), now)
+	require.True(t, next.Broken)
+	require.Equal(t, now, next.BrokenSince.Time)
+	require.Equal(t, int32(10), next.Crawls)
+	require.Equal(t, int32(9), next.Failures)
+
+	// Sweeps with too few comics to tell rate, failures are counted across sweeps
+	next = nextSiteHealth(db.SiteHealth{Page: "test.vn"}, sweep(0, 2), now)
+	require.False(t, next.Broken)
+	next = nextSiteHealth(db.SiteHealth{Page: "test.vn", ConsecutiveFailures: brokenConsecutiveFailures - 1}, sweep(0, 1), now)
+	require.True(t, next.Broken)
+
+	// Broken site recovers on a sweep with normal failure rate
+	broken := db.SiteHealth{Page: "test.vn", Broken: true, BrokenSince: next.BrokenSince}
+	next = nextSiteHealth(broken, sweep(0, 1), now)
+	require.True(t, next.Broken)
+	next = nextSiteHealth(broken, sweep(3, 1), now)
+	require.False(t, next.Broken)
+	require.False(t, next.BrokenSince.Valid)
+}
+
+func TestSweepFlagsBrokenSite(t *testing.T) {
+
+	s := newFakeStore(5, 1)
+	crwl := &fakeCrawler{crawls: map[string]int{}, maxChap: 1, failing: true}
+
+	updateComics(context.Background(), crwl, s, 2, time.Minute, make(chan struct{}, 1))
+	health := s.sites["test.vn"]
+	require.True(t, health.Broken)
+	require.Equal(t, int32(5), health.Failures)
+	require.Equal(t, int32(5), health.ConsecutiveFailures)
+	require.Equal(t, crawlErrFetch, health.LastError)
+	require.False(t, health.LastSuccessAt.Valid)
+
+	// Site is fixed
+	crwl.failing = false
+	updateComics(context.Background(), crwl, s, 2, time.Minute, make(chan struct{}, 1))
+	health = s.sites["test.vn"]
+	require.False(t, health.Broken)
+	require.Equal(t, int32(10), health.Crawls)
+	require.Zero(t, health.ConsecutiveFailures)
+	require.True(t, health.LastSuccessAt.Valid)
+}
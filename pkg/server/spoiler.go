@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+const (
+	spoilerThreshold = 0.5            // chapter with score from this value is quarantined
+	minChapterImages = 3              // chapter with fewer images is likely a spoiler or an incomplete upload
+	maxQuarantine    = 72 * time.Hour // quarantined chapter is released anyway after this long, so it's never dropped silently
+)
+
+// Weight of each spoiler signal, score is capped at 1
+const (
+	keywordWeight       = 0.6 // chapter name contains a spoiler keyword
+	fewImagesWeight     = 0.5 // chapter has fewer than minChapterImages images
+	relativeImageWeight = 0.5 // chapter has less than half of images of comic's usual chapter
+	pageSizeWeight      = 0.3 // chapter page is less than half of comic's usual page size
+)
+
+// spoilerKeywords mark chapter which is not an official release, matched case-insensitively against chapter name
+var spoilerKeywords = []string{
+	"leak", "spoil", "preview", "[raw]", "(raw)",
+	"bản nháp", "chưa dịch", "đang dịch", "đang cập nhật", "tiết lộ", "rò rỉ",
+}
+
+// spoilerScore return confidence from 0 to 1 that chapter is a spoiler or an incomplete upload,
+// history is comic's released chapters. Chapter which is not inspected (zero page size) is scored by its name only
+func spoilerScore(chap db.Chapter, history []db.Chapter) float64 {
+
+	score := 0.0
+
+	name := strings.ToLower(chap.Name)
+	for _, keyword := range spoilerKeywords {
+		if strings.Contains(name, keyword) {
+			score += keywordWeight
+			break
+		}
+	}
+
+	if chap.PageSize > 0 {
+		images, pageSizes := []int32{}, []int32{}
+		for _, h := range history {
+			if h.Status == db.ChapterReleased && h.PageSize > 0 {
+				images = append(images, h.ImageCount)
+				pageSizes = append(pageSizes, h.PageSize)
+			}
+		}
+
+		if chap.ImageCount < minChapterImages {
+			score += fewImagesWeight
+		}
+		if len(images) != 0 && chap.ImageCount*2 < median(images) {
+			score += relativeImageWeight
+		}
+		if len(pageSizes) != 0 && chap.PageSize*2 < median(pageSizes) {
+			score += pageSizeWeight
+		}
+	}
+
+	if score > 1 {
+		score = 1
+	}
+
+	return score
+}
+
+// inspectChapter fetch chapter's stats and set its status by spoiler score, chapter which can't be fetched is quarantined
+// to be checked again on next update. util.ErrThrottled is returned when chapter isn't fetched because its host is
+// throttled, and util.ErrLayoutChanged when its images can't be found in page, chapter is left uninspected
+func inspectChapter(ctx context.Context, crwl infoCrawler, chap db.Chapter, history []db.Chapter) (db.Chapter, error) {
+
+	images, pageSize, err := crwl.GetChapterStats(ctx, chap.Url)
+	if err == util.ErrThrottled || errors.Cause(err) == util.ErrLayoutChanged {
+		return chap, err
+	}
+	if err != nil && err != util.ErrSpoilerCheckNotSupported {
+		logging.Ctx(ctx).Danger(err)
+		chap.Status = db.ChapterQuarantined
+		return chap, nil
+	}
+
+	chap.ImageCount = int32(images)
+	chap.PageSize = int32(pageSize)
+
+	chap.Status = db.ChapterReleased
+	if spoilerScore(chap, history) >= spoilerThreshold {
+		chap.Status = db.ChapterQuarantined
+	}
+
+	return chap, nil
+}
+
+// releaseQuarantined re-check comic's quarantined chapters and release ones which look complete now or are quarantined
+// for longer than maxQuarantine, chapters are updated in place. Return true if any chapter is released
+func releaseQuarantined(ctx context.Context, s db.Store, crwl infoCrawler, chapters []db.Chapter) bool {
+
+	released := false
+	for i, chap := range chapters {
+		if chap.Status != db.ChapterQuarantined {
+			continue
+		}
+
+		checked, err := inspectChapter(ctx, crwl, chap, chapters)
+		if err != nil {
+			// Chapter is checked on next update, even if it's quarantined too long
+			continue
+		}
+		if checked.Status == db.ChapterQuarantined {
+			if time.Since(chap.CreatedAt) < maxQuarantine {
+				continue
+			}
+			logging.Ctx(ctx).Info("Chapter", chap.ID, "-", chap.Name, "is quarantined too long, release it")
+		}
+
+		err = s.ReleaseChapter(ctx, checked)
+		if err != nil {
+			logging.Ctx(ctx).Danger(err)
+			continue
+		}
+
+		logging.Ctx(ctx).Info("Comic", chap.ComicID, "quarantined chapter", chap.Name, "is released")
+		checked.Status = db.ChapterReleased
+		chapters[i] = checked
+		released = true
+	}
+
+	return released
+}
+
+func median(values []int32) int32 {
+
+	sorted := append([]int32(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return sorted[len(sorted)/2]
+}
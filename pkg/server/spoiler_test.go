@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+func TestSpoilerScoreKeywords(t *testing.T) {
+
+	names := []string{
+		"One Piece Chapter 1009 leak",
+		"Chapter 1009 - SPOILER",
+		"Chương 1009 [RAW]",
+		"Chương 1009 (bản nháp)",
+		"Chương 1009 - đang cập nhật",
+		"Chương 1009 tiết lộ",
+	}
+	for _, name := range names {
+		require.GreaterOrEqual(t, spoilerScore(db.Chapter{Name: name}, nil), spoilerThreshold, name)
+	}
+
+	require.Less(t, spoilerScore(db.Chapter{Name: "Chương 1009: Raw power"}, nil), spoilerThreshold)
+}
+
+func TestSpoilerScoreStats(t *testing.T) {
+
+	history := []db.Chapter{
+		{ImageCount: 18, PageSize: 40000, Status: db.ChapterReleased},
+		{ImageCount: 20, PageSize: 42000, Status: db.ChapterReleased},
+		{ImageCount: 19, PageSize: 41000, Status: db.ChapterReleased},
+		{ImageCount: 2, PageSize: 9000, Status: db.ChapterQuarantined},
+	}
+
+	// Complete chapter
+	require.Zero(t, spoilerScore(db.Chapter{Name: "Chapter 1009", ImageCount: 17, PageSize: 39000}, history))
+
+	// Too few images, even without history
+	require.GreaterOrEqual(t, spoilerScore(db.Chapter{Name: "Chapter 1009", ImageCount: 2, PageSize: 39000}, nil), spoilerThreshold)
+
+	// Less than half of usual images
+	require.GreaterOrEqual(t, spoilerScore(db.Chapter{Name: "Chapter 1009", ImageCount: 8, PageSize: 39000}, history), spoilerThreshold)
+
+	// Small page alone is not enough
+	require.Less(t, spoilerScore(db.Chapter{Name: "Chapter 1009", ImageCount: 17, PageSize: 10000}, history), spoilerThreshold)
+
+	// Every signal together is capped
+	require.Equal(t, 1.0, spoilerScore(db.Chapter{Name: "Chapter 1009 leak", ImageCount: 1, PageSize: 1000}, history))
+
+	// Chapter not inspected is scored by name only
+	require.Zero(t, spoilerScore(db.Chapter{Name: "Chapter 1009"}, history))
+}
+
+func TestInspectChapter(t *testing.T) {
+
+	crwl := &fakeCrawler{}
+	chap, err := inspectChapter(context.Background(), crwl, db.Chapter{Name: "Chapter 2"}, nil)
+	require.Nil(t, err)
+	require.Equal(t, db.ChapterReleased, chap.Status)
+
+	// Chapter page can't be fetched, check it again later
+	crwl.stats = func(chapURL string) (int, int, error) {
+		return 0, 0, errors.New("Crawl failed")
+	}
+	chap, err = inspectChapter(context.Background(), crwl, db.Chapter{Name: "Chapter 2"}, nil)
+	require.Nil(t, err)
+	require.Equal(t, db.ChapterQuarantined, chap.Status)
+
+	// Our own throttling isn't a fetch error, chapter is left for next update
+	crwl.stats = func(chapURL string) (int, int, error) {
+		return 0, 0, util.ErrThrottled
+	}
+	_, err = inspectChapter(context.Background(), crwl, db.Chapter{Name: "Chapter 2"}, nil)
+	require.Equal(t, util.ErrThrottled, err)
+}
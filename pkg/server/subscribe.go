@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/asaskevich/govalidator"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/msg"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+// Fields which identify a Facebook user, see infoCrawler.GetUserInfoFromFacebook
+const (
+	userFieldPSID  = "psid"
+	userFieldAppID = "appid"
+)
+
+// SubscribeService crawl, dedupe and subscribe comics, it's shared by Messenger and REST API
+// so a comic subscribed from both at the same time is saved once
+type SubscribeService struct {
+	sync.Mutex // held while comic is saved, so a comic subscribed through different URLs at once is saved once
+	store      db.Store
+	crawler    infoCrawler
+
+	urlMu sync.Mutex
+	urls  map[string]*urlLock // comic URLs being subscribed
+}
+
+// urlLock serialize subscribes of one comic URL, refs is number of subscribes holding or waiting for it
+type urlLock struct {
+	sync.Mutex
+	refs int
+}
+
+// NewSubscribeService return new subscribe service
+func NewSubscribeService(s db.Store, crwl infoCrawler) *SubscribeService {
+	return &SubscribeService{store: s, crawler: crwl, urls: map[string]*urlLock{}}
+}
+
+// lockURL wait until no other subscribe of comicURL is running, so a new comic is crawled once. Return func which
+// unlocks it, subscribes of other URLs don't wait
+func (sv *SubscribeService) lockURL(comicURL string) func() {
+
+	sv.urlMu.Lock()
+	l, ok := sv.urls[comicURL]
+	if !ok {
+		l = &urlLock{}
+		sv.urls[comicURL] = l
+	}
+	l.refs++
+	sv.urlMu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		sv.urlMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(sv.urls, comicURL)
+		}
+		sv.urlMu.Unlock()
+	}
+}
+
+// Subscribe add comic and user to DB, user is identified by userField and userID. User who is not in DB yet
+// is fetched from Facebook. On util.ErrAlreadySubscribed, the subscribed comic is returned too. Crawl and Facebook
+// lookup only block subscribes of the same URL, saving comic is serialized across all subscribes
+func (sv *SubscribeService) Subscribe(ctx context.Context, userField, userID, comicURL string) (*db.Comic, error) {
+
+	var (
+		err      error
+		comic    db.Comic
+		chapters []db.Chapter
+		user     db.User
+	)
+
+	if !govalidator.IsURL(comicURL) {
+		return nil, util.ErrInvalidURL
+	}
+
+	unlock := sv.lockURL(comicURL)
+	defer unlock()
+
+	comic, err = sv.store.GetComicByURL(ctx, comicURL)
+	if err != nil {
+
+		if err != sql.ErrNoRows {
+			logging.Ctx(ctx).Danger(err)
+			return nil, err
+		}
+		// Comic is not in DB, need to get it's info using crawler pkg
+		comic, chapters, err = sv.crawler.GetComicInfo(ctx, comicURL)
+		if err != nil {
+			logging.Ctx(ctx).Danger(err)
+			return nil, err
+		}
+	}
+
+	user, err = sv.getUser(ctx, userField, userID)
+	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+		return nil, err
+	}
+
+	sv.Lock()
+	defer sv.Unlock()
+
+	// Verify comic again to avoid multiple URL represents same comic, by checking Page + Comic Name
+	c, err := sv.store.GetComicByPageAndComicName(ctx, db.GetComicByPageAndComicNameParams{
+		Page: comic.Page,
+		Name: comic.Name,
+	})
+
+	if err == nil {
+		comic.ID = c.ID
+	}
+
+	// New user may be saved by another subscribe while Facebook was looked up
+	if user.ID == 0 {
+		if u, err := sv.storedUser(ctx, userField, userID); err == nil {
+			user = u
+		}
+	}
+
+	_, err = sv.store.GetSubscriber(ctx, db.GetSubscriberParams{
+		UserID:  user.ID,
+		ComicID: comic.ID,
+	})
+
+	if err == nil {
+		return &comic, util.ErrAlreadySubscribed
+	}
+
+	if err != sql.ErrNoRows {
+		logging.Ctx(ctx).Danger(err)
+		return nil, err
+	}
+
+	err = sv.store.SubscribeComic(ctx, &comic, chapters, &user)
+	return &comic, err
+}
+
+// getUser return user in DB, or user info from Facebook with zero ID if user is not saved yet
+func (sv *SubscribeService) getUser(ctx context.Context, userField, userID string) (user db.User, err error) {
+
+	user, err = sv.storedUser(ctx, userField, userID)
+	if err != sql.ErrNoRows {
+		return
+	}
+
+	if userField == userFieldAppID {
+		return sv.crawler.GetUserInfoFromFacebook(userField, userID, "")
+	}
+
+	// PSID is scoped to the page user messaged, so user is looked up with that page's token and replied from it later
+	user, err = sv.crawler.GetUserInfoFromFacebook(userField, userID, pageAccessToken(ctx))
+	if pageID := msg.PageID(ctx); pageID != "" {
+		user.MessengerPageID = sql.NullString{String: pageID, Valid: true}
+	}
+
+	return
+}
+
+// storedUser return user identified by userField and userID in DB
+func (sv *SubscribeService) storedUser(ctx context.Context, userField, userID string) (db.User, error) {
+
+	id := sql.NullString{String: userID, Valid: true}
+	if userField == userFieldAppID {
+		return sv.store.GetUserByAppID(ctx, id)
+	}
+
+	return sv.store.GetUserByPSID(ctx, id)
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/tinoquang/comic-notifier/pkg/api"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+	"github.com/tinoquang/comic-notifier/pkg/util"
+)
+
+func (s *fakeStore) GetComicByURL(ctx context.Context, url string) (db.Comic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.comics {
+		if c.Url == url {
+			return c, nil
+		}
+	}
+	return db.Comic{}, sql.ErrNoRows
+}
+
+func (s *fakeStore) GetComicByPageAndComicName(ctx context.Context, arg db.GetComicByPageAndComicNameParams) (db.Comic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.comics {
+		if c.Page == arg.Page && c.Name == arg.Name {
+			return c, nil
+		}
+	}
+	return db.Comic{}, sql.ErrNoRows
+}
+
+func (s *fakeStore) GetUserByAppID(ctx context.Context, appid sql.NullString) (db.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Appid == appid {
+			return u, nil
+		}
+	}
+	return db.User{}, sql.ErrNoRows
+}
+
+func (s *fakeStore) GetUserByPSID(ctx context.Context, psid sql.NullString) (db.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Psid == psid {
+			return u, nil
+		}
+	}
+	return db.User{}, sql.ErrNoRows
+}
+
+func (s *fakeStore) GetSubscriber(ctx context.Context, arg db.GetSubscriberParams) (db.Subscriber, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subscribers {
+		if sub.UserID == arg.UserID && sub.ComicID == arg.ComicID {
+			return sub, nil
+		}
+	}
+	return db.Subscriber{}, sql.ErrNoRows
+}
+
+func (s *fakeStore) SubscribeComic(ctx context.Context, comic *db.Comic, chapters []db.Chapter, user *db.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if comic.ID == 0 {
+		comic.ID = int32(len(s.comics) + 1)
+		s.comics[comic.ID] = *comic
+	}
+
+	if user.ID == 0 {
+		user.ID = int32(len(s.users) + 1)
+		s.users = append(s.users, *user)
+	}
+
+	s.subscribers = append(s.subscribers, db.Subscriber{UserID: user.ID, ComicID: comic.ID})
+	return nil
+}
+
+func (s *fakeStore) UnsubscribeComic(ctx context.Context, userID, comicID int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.comics[comicID]; !ok {
+		return sql.ErrNoRows
+	}
+
+	subscribers := []db.Subscriber{}
+	for _, sub := range s.subscribers {
+		if sub.UserID != userID || sub.ComicID != comicID {
+			subscribers = append(subscribers, sub)
+		}
+	}
+	s.subscribers = subscribers
+	return nil
+}
+
+// newSubscribeContext return echo context of subscribe request sent by user 1
+func newSubscribeContext(body string) (echo.Context, *httptest.ResponseRecorder) {
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	ctx, rec := newAdminContext("")
+	ctx.SetRequest(req)
+	return ctx, rec
+}
+
+func TestSubscribeService(t *testing.T) {
+
+	s := newFakeStore(1, 1)
+	s.users[0].Psid = sql.NullString{String: "psid-1", Valid: true}
+	crwl := &fakeCrawler{crawls: map[string]int{}, maxChap: 2}
+	sv := NewSubscribeService(s, crwl)
+
+	_, err := sv.Subscribe(context.Background(), userFieldPSID, "psid-1", "not a link")
+	require.Equal(t, util.ErrInvalidURL, err)
+	require.Empty(t, crwl.crawls)
+
+	// Comic in DB is not crawled again
+	comic, err := sv.Subscribe(context.Background(), userFieldPSID, "psid-1", "https://test.vn/comic-1")
+	require.Nil(t, err)
+	require.Equal(t, int32(1), comic.ID)
+	require.Empty(t, crwl.crawls)
+
+	comic, err = sv.Subscribe(context.Background(), userFieldPSID, "psid-1", "https://test.vn/comic-1")
+	require.Equal(t, util.ErrAlreadySubscribed, err)
+	require.Equal(t, int32(1), comic.ID)
+
+	// New comic is crawled, user who is not in DB yet is created
+	comic, err = sv.Subscribe(context.Background(), userFieldAppID, "app-2", "https://test.vn/comic-2")
+	require.Nil(t, err)
+	require.Equal(t, int32(2), comic.ID)
+	require.Equal(t, 1, crwl.crawls["https://test.vn/comic-2"])
+	require.Len(t, s.users, 2)
+	require.Equal(t, db.Subscriber{UserID: 2, ComicID: 2}, s.subscribers[1])
+}
+
+// slowCrawler block crawl of slowURL until release is closed
+type slowCrawler struct {
+	*fakeCrawler
+	slowURL string
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *slowCrawler) GetComicInfo(ctx context.Context, comicURL string) (db.Comic, []db.Chapter, error) {
+	if comicURL == c.slowURL {
+		c.started <- struct{}{}
+		<-c.release
+	}
+	return c.fakeCrawler.GetComicInfo(ctx, comicURL)
+}
+
+func TestSubscribeServiceSlowCrawl(t *testing.T) {
+
+	s := newFakeStore(0, 1)
+	s.users[0].Psid = sql.NullString{String: "psid-1", Valid: true}
+	crwl := &slowCrawler{
+		fakeCrawler: &fakeCrawler{crawls: map[string]int{}, maxChap: 2},
+		slowURL:     "https://test.vn/comic-slow",
+		started:     make(chan struct{}, 1),
+		release:     make(chan struct{}),
+	}
+	sv := NewSubscribeService(s, crwl)
+
+	errs := make(chan error, 2)
+	subscribe := func() {
+		_, err := sv.Subscribe(context.Background(), userFieldPSID, "psid-1", crwl.slowURL)
+		errs <- err
+	}
+	go subscribe()
+	<-crwl.started
+
+	// Subscribe of another comic isn't held up by the slow crawl
+	_, err := sv.Subscribe(context.Background(), userFieldPSID, "psid-1", "https://test.vn/comic-2")
+	require.Nil(t, err)
+
+	// Comic being crawled is crawled once, whoever else subscribes it meanwhile
+	go subscribe()
+	close(crwl.release)
+	results := []error{<-errs, <-errs}
+	require.ElementsMatch(t, []error{nil, util.ErrAlreadySubscribed}, results)
+	require.Equal(t, 1, crwl.crawls[crwl.slowURL])
+}
+
+func TestAPISubscribeComic(t *testing.T) {
+
+	s := newFakeStore(0, 1)
+	s.users[0].Appid = sql.NullString{String: "1", Valid: true}
+	crwl := &fakeCrawler{crawls: map[string]int{}, maxChap: 2}
+	a := NewAPI(s, crwl, NewSubscribeService(s, crwl), 0, nil, nil)
+
+	ctx, rec := newSubscribeContext(`{"url": "https://test.vn/comic-1"}`)
+	require.Nil(t, a.SubscribeComic(ctx, "1"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"chapURL":"https://test.vn/comic-1/2"`)
+
+	ctx, rec = newSubscribeContext(`{"url": "https://test.vn/comic-1"}`)
+	require.Nil(t, a.SubscribeComic(ctx, "1"))
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Contains(t, rec.Body.String(), `"code":"already_subscribed"`)
+
+	// Users can't subscribe for others
+	ctx, rec = newSubscribeContext(`{"url": "https://test.vn/comic-1"}`)
+	require.Nil(t, a.SubscribeComic(ctx, "2"))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestAPIUnsubscribeComic(t *testing.T) {
+
+	s := newFakeStore(1, 2)
+	s.users[0].Appid = sql.NullString{String: "1", Valid: true}
+	s.subscribers = []db.Subscriber{{UserID: 1, ComicID: 1}, {UserID: 2, ComicID: 1}}
+	a := NewAPI(s, &fakeCrawler{}, nil, 0, nil, nil)
+
+	ctx, rec := newAdminContext("")
+	require.Nil(t, a.UnsubscribeComic(ctx, "1", 1))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, []db.Subscriber{{UserID: 2, ComicID: 1}}, s.subscribers)
+
+	ctx, rec = newAdminContext("")
+	require.Nil(t, a.UnsubscribeComic(ctx, "1", 2))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestSubscribeError(t *testing.T) {
+
+	cases := []struct {
+		err    error
+		status int
+		code   api.ErrorCode
+	}{
+		{util.ErrInvalidURL, http.StatusBadRequest, api.ErrorCodeInvalidUrl},
+		{util.ErrPageNotSupported, http.StatusUnprocessableEntity, api.ErrorCodeUnsupportedPage},
+		{util.ErrCrawlTimeout, http.StatusGatewayTimeout, api.ErrorCodeCrawlTimeout},
+		{util.ErrAlreadySubscribed, http.StatusConflict, api.ErrorCodeAlreadySubscribed},
+	}
+
+	for _, c := range cases {
+		status, apiErr := subscribeError(c.err)
+		require.Equal(t, c.status, status)
+		require.Equal(t, c.code, apiErr.Code)
+	}
+
+	status, apiErr := subscribeError(util.ErrCrawlFailed)
+	require.Equal(t, http.StatusInternalServerError, status)
+	require.Nil(t, apiErr)
+}
@@ -2,135 +2,369 @@ package server
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/pkg/errors"
 	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
 	"github.com/tinoquang/comic-notifier/pkg/logging"
+	"github.com/tinoquang/comic-notifier/pkg/metrics"
+	"github.com/tinoquang/comic-notifier/pkg/util"
 )
 
-var updateDone chan struct{}
+// updateComicService crawl due comics every interval or when sweep is triggered, until ctx is cancelled. notifyService
+// is woken up after a sweep which queued new chapters. A sweep in progress stops taking new comics on cancel and waits for running workers
+func updateComicService(ctx context.Context, crwl infoCrawler, s db.Store, workerNum int, interval time.Duration, wake chan<- struct{}, sweep <-chan struct{}) {
 
-func initUpdateService(updateLock *sync.Mutex, crwl infoCrawler, s db.Store, workerNum, timeout int) {
+	for {
+		updateComics(ctx, crwl, s, workerNum, interval, wake)
 
-	updateDone = make(chan struct{})
-	go updateComicService(updateLock, crwl, s, workerNum, timeout)
+		select {
+		case <-ctx.Done():
+			return
+		case <-sweep:
+		case <-time.After(interval):
+		}
+	}
 }
 
-// UpdateThread read comic database and update each comic to each latest chap
-func updateComicService(updateLock *sync.Mutex, crwl infoCrawler, s db.Store, workerNum, timeout int) {
+// updateComics run one update sweep over comics which are due to be checked, see nextCheckAt
+func updateComics(ctx context.Context, crwl infoCrawler, s db.Store, workerNum int, interval time.Duration, wake chan<- struct{}) {
 
-	var wg sync.WaitGroup
+	ctx = logging.With(ctx, logging.FieldJob, "update")
+	listCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 
-	// Start update routine, then sleep for a while and re-update
-	for {
+	// Get due comics in DB
+	comics, err := s.ListDueComics(listCtx)
+	cancel() // Call context cancel here to avoid context leak
+
+	if err != nil {
+		logging.Ctx(ctx).Danger("Get list of comic fails, err", err)
+		return
+	}
 
-		// Need to verify NotifyService is not running
-		updateLock.Lock()
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if len(comics) == 0 {
+		return
+	}
 
-		// Get all comics in DB
-		comics, err := s.ListComics(ctx)
-		cancel() // Call context cancel here to avoid context leak
+	logging.Ctx(ctx).Info(fmt.Sprintf("Update %d comic(s) ...", len(comics)))
+	start := time.Now()
 
-		if err != nil {
-			logging.Danger("Get list of comic fails, err", err)
-			time.Sleep(time.Duration(timeout) * time.Minute)
-			continue
+	// Create workers, notifyService is woken up once sweep is done so chapters of the same sweep are sent together
+	atomic.StoreInt32(&sweepRunning, 1)
+	var wg sync.WaitGroup
+	comicPool := make(chan db.Comic, workerNum)
+	outcomes := newSiteOutcomes()
+	queued := make(chan struct{}, 1)
+	for i := 0; i < workerNum; i++ {
+		go worker(i, s, crwl, &wg, comicPool, interval, queued, outcomes)
+		wg.Add(1)
+	}
+
+	// Query successful, for each comic put into job channel for worker to do the update stuffs
+dispatch:
+	for _, comic := range comics {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case comicPool <- comic:
 		}
+	}
+	close(comicPool)
 
-		if len(comics) != 0 {
+	wg.Wait()
+	atomic.StoreInt32(&sweepRunning, 0)
+	metrics.SweepDuration.Observe(time.Since(start).Seconds())
+	logging.Ctx(ctx).Info("All comics is updated")
 
-			logging.Info(fmt.Sprintf("Update %d comic(s) ...", len(comics)))
+	if len(queued) != 0 {
+		wakeNotifyService(wake)
+	}
 
-			// Create workers
-			comicPool := make(chan db.Comic, workerNum)
-			for i := 0; i < workerNum; i++ {
-				go worker(i, s, crwl, &wg, comicPool)
-				wg.Add(1)
-			}
+	// Site health is saved even on shutdown, outcomes of the comics crawled so far are still valid
+	healthCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	updateSiteHealth(healthCtx, s, outcomes)
+	cancel()
+}
 
-			// Query successful, for each comic put into job channel for worker to do the update stuffs
-			for _, comic := range comics {
-				comicPool <- comic
-			}
-			close(comicPool)
+func worker(id int, s db.Store, crwl infoCrawler, wg *sync.WaitGroup, comicPool <-chan db.Comic, interval time.Duration, wake chan<- struct{}, outcomes *siteOutcomes) {
 
-			wg.Wait()
-			logging.Info("All comics is updated")
-		}
+	// Get comic from updateComics, which run only when updateComics push comic into comicPool
+	for oldComic := range comicPool {
+		// Comic being updated is finished even on shutdown, so its new chapters and notifications are saved together
+		ctx := logging.With(context.Background(), logging.FieldJob, "update", logging.FieldComicID, oldComic.ID, logging.FieldSite, oldComic.Page)
+		ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 
-		updateLock.Unlock()
-		updateDone <- struct{}{}
-		time.Sleep(time.Duration(timeout) * time.Minute)
-		// time.Sleep(15 * time.Second)
+		checkComic(ctx, s, crwl, oldComic, interval, wake, outcomes)
+
+		cancel() // Call context cancel here to avoid context leak
 	}
 
-	// Never reach here
+	wg.Done()
 }
 
-func worker(id int, s db.Store, crwl infoCrawler, wg *sync.WaitGroup, comicPool <-chan db.Comic) {
+// checkComic update comic and schedule its next check from its chapter history, return nil if comic can't be crawled.
+// Comic which is put off by throttling isn't rescheduled, so it's still due on next sweep
+func checkComic(ctx context.Context, s db.Store, crwl infoCrawler, comic db.Comic, interval time.Duration, wake chan<- struct{}, outcomes *siteOutcomes) []db.Chapter {
 
-	// Get comic from updateComicThread, which run only when updateComicThread push comic into comicPool
-	for oldComic := range comicPool {
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	history, err := updateComic(ctx, s, crwl, comic, wake, outcomes)
+	if err == util.ErrThrottled {
+		return nil
+	}
 
-		// Synchronized firebase img
-		err := s.SyncComicImage(&oldComic)
-		if err != nil {
-			logging.Danger(err)
+	err = s.UpdateComicNextCheck(ctx, db.UpdateComicNextCheckParams{
+		ID:          comic.ID,
+		NextCheckAt: nextCheckAt(history, time.Now(), interval),
+	})
+	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+	}
+
+	return history
+}
+
+// updateComic crawl comic and save its new chapters, return comic's chapter history ordered oldest first,
+// history is nil when comic can't be crawled or its new chapter pages aren't recognized. Crawl outcome is counted in comic's site outcomes. util.ErrThrottled
+// is returned when comic is put off because its host is throttled by us, it's not a crawl failure and comic stays due
+func updateComic(ctx context.Context, s db.Store, crwl infoCrawler, oldComic db.Comic, wake chan<- struct{}, outcomes *siteOutcomes) ([]db.Chapter, error) {
+
+	// Synchronized firebase img
+	err := s.SyncComicImage(&oldComic)
+	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+	}
+
+	cache, err := s.GetPageCache(ctx, oldComic.ID)
+	if err != nil && err != sql.ErrNoRows {
+		logging.Ctx(ctx).Danger(err)
+	}
+	cache.ComicID = oldComic.ID
+
+	start := time.Now()
+	c, chapters, newCache, crawlErr := crwl.GetComicUpdate(ctx, oldComic.Url, cache)
+	metrics.CrawlDuration.WithLabelValues(oldComic.Page, crawlOutcome(crawlErr)).Observe(time.Since(start).Seconds())
+	if crawlErr == util.ErrThrottled {
+		logging.Ctx(ctx).Info("Comic", oldComic.ID, "-", oldComic.Name, "is put off, its site is throttled")
+		return nil, crawlErr
+	}
+
+	// Outcome is recorded once comic is processed, since chapter page which isn't recognized fails the crawl too
+	outcome := crawlErr
+	defer func() {
+		recordCrawlResult(ctx, s, oldComic, outcome)
+		outcomes.record(oldComic.Page, outcome, time.Now())
+	}()
+	if crawlErr != nil && crawlErr != util.ErrNotModified {
+		logging.Ctx(ctx).Danger(crawlErr)
+		return nil, nil
+	}
+
+	stored, err := s.ListChaptersPerComic(ctx, oldComic.ID)
+	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+		return nil, nil
+	}
+
+	// Quarantined chapters are re-checked on every update, even when comic page is unchanged
+	if releaseQuarantined(ctx, s, crwl, stored) {
+		wakeNotifyService(wake)
+	}
+
+	// Page is unchanged since last update, nothing to parse
+	if crawlErr == util.ErrNotModified {
+		return stored, nil
+	}
+
+	if c.Page != "hocvientruyentranh.net" {
+		if c.LastUpdate.Sub(oldComic.LastUpdate) < 0 { // Avoid update old chapter
+			savePageCache(ctx, s, newCache)
+			return stored, nil
 		}
+	}
 
-		c, err := crwl.GetComicInfo(ctx, oldComic.Url, true)
+	newChaps := unseenChapters(chapters, stored, oldComic.ChapUrl)
+	if len(newChaps) == 0 && c.ChapUrl == oldComic.ChapUrl && len(stored) != 0 {
+		savePageCache(ctx, s, newCache)
+		return stored, nil
+	}
+
+	// Suspicious chapters are saved as quarantined, their subscribers are notified once they are released
+	inspected := map[string]db.Chapter{}
+	releasedChaps := []db.Chapter{}
+	for i := range newChaps {
+		newChaps[i], err = inspectChapter(ctx, crwl, newChaps[i], stored)
 		if err != nil {
-			logging.Danger(err)
-			cancel()
-			continue
+			// Page cache isn't saved, so new chapters are found and inspected again on next sweep
+			if errors.Is(err, util.ErrThrottled) {
+				logging.Ctx(ctx).Info("Comic", oldComic.ID, "-", oldComic.Name, "is put off, its site is throttled")
+				return nil, err
+			}
+			logging.Ctx(ctx).Danger(err)
+			outcome = err
+			return nil, nil
 		}
+		inspected[newChaps[i].Url] = newChaps[i]
+		if newChaps[i].Status == db.ChapterReleased {
+			releasedChaps = append(releasedChaps, newChaps[i])
+		}
+	}
 
-		if c.Page != "hocvientruyentranh.net" {
-			if c.LastUpdate.Sub(oldComic.LastUpdate) < 0 { // Avoid update old chapter
-				cancel()
-				continue
+	// Comic has no chapter history yet, save the whole list so later updates can be compared with it
+	saveChaps := newChaps
+	if len(stored) == 0 {
+		saveChaps = make([]db.Chapter, len(chapters))
+		for i, chap := range chapters {
+			if inspectedChap, ok := inspected[chap.Url]; ok {
+				chap = inspectedChap
 			}
+			saveChaps[i] = chap
 		}
+	}
 
-		if c.ChapUrl == oldComic.ChapUrl {
-			cancel()
-			continue
+	// Quarantined chapter isn't shown as comic's latest chapter until it's released, see releaseQuarantined
+	if chap, ok := inspected[c.ChapUrl]; ok && chap.Status == db.ChapterQuarantined {
+		c.LatestChap, c.ChapUrl = oldComic.LatestChap, oldComic.ChapUrl
+		if latest, ok := latestReleased(append(stored, saveChaps...)); ok {
+			c.LatestChap, c.ChapUrl = latest.Name, latest.Url
 		}
+	}
+
+	c.ID = oldComic.ID
+	err = s.UpdateNewChapter(ctx, &c, saveChaps, releasedChaps, oldComic.ImgUrl)
+	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+		return stored, nil
+	}
+	savePageCache(ctx, s, newCache)
 
-		c.ID = oldComic.ID
-		err = s.UpdateNewChapter(ctx, &c, oldComic.ImgUrl)
+	// Subscribers had read up to comic's current chapter before its history is saved
+	if len(stored) == 0 {
+		err = s.InitLastReadChapters(ctx, db.InitLastReadChaptersParams{
+			ComicID: c.ID,
+			Url:     oldComic.ChapUrl,
+		})
 		if err != nil {
-			logging.Danger(err)
-			cancel()
+			logging.Ctx(ctx).Danger(err)
+		}
+	}
+
+	for _, chap := range newChaps {
+		if chap.Status == db.ChapterQuarantined {
+			logging.Ctx(ctx).Info("Comic", c.ID, "-", c.Name, "new chapter", chap.Name, "looks like a spoiler, quarantine it")
 			continue
 		}
+		logging.Ctx(ctx).Info("Comic", c.ID, "-", c.Name, "new chapter", chap.Name)
+	}
+	if len(releasedChaps) != 0 {
+		wakeNotifyService(wake)
+	}
 
-		logging.Info("Comic", c.ID, "-", c.Name, "new chapter", c.LatestChap)
-		addNewNotification(ctx, s, c)
+	now := time.Now()
+	for _, chap := range saveChaps {
+		chap.CreatedAt = now
+		stored = append(stored, chap)
+	}
 
-		cancel() // Call context cancel here to avoid context leak
+	return stored, nil
+}
+
+// latestReleased return newest chapter which is not quarantined, chapters are ordered oldest first
+func latestReleased(chapters []db.Chapter) (db.Chapter, bool) {
+
+	for i := len(chapters) - 1; i >= 0; i-- {
+		if chapters[i].Status != db.ChapterQuarantined {
+			return chapters[i], true
+		}
 	}
 
-	wg.Done()
+	return db.Chapter{}, false
 }
 
-func addNewNotification(ctx context.Context, s db.Store, comic db.Comic) {
+// recordCrawlResult save comic's crawl outcome, subscribers of a comic which keeps failing are notified
+// from other sources of its series, see db.FailoverCrawlFailures
+func recordCrawlResult(ctx context.Context, s db.Store, comic db.Comic, crawlErr error) {
+
+	if crawlErr == nil || crawlErr == util.ErrNotModified {
+		err := s.RecordComicCrawlSuccess(ctx, comic.ID)
+		if err != nil {
+			logging.Ctx(ctx).Danger(err)
+			return
+		}
+		if comic.CrawlFailures >= db.FailoverCrawlFailures {
+			logging.Ctx(ctx).Info("Comic", comic.ID, "-", comic.Name, "is crawled again after", comic.CrawlFailures, "failures")
+		}
+		return
+	}
 
-	users, err := s.ListUsersPerComic(ctx, comic.ID)
+	failures, err := s.RecordComicCrawlFailure(ctx, db.RecordComicCrawlFailureParams{
+		ID:             comic.ID,
+		LastCrawlError: crawlErrorKind(crawlErr),
+	})
 	if err != nil {
-		logging.Danger("Can't send notification for comic %s, err: %s", comic.Name, err.Error())
+		logging.Ctx(ctx).Danger(err)
 		return
 	}
 
-	for _, user := range users {
-		newNotification <- notification{
-			userID: user.Psid.String,
-			comic:  comic,
-			retry:  0,
+	if failures == db.FailoverCrawlFailures && comic.SeriesID.Valid {
+		logging.Ctx(ctx).Info("Comic", comic.ID, "-", comic.Name, "keeps failing to crawl, its subscribers fail over to other sources of series", comic.SeriesID.Int32)
+	}
+}
+
+// savePageCache save validators of comic page, it's called only after the page is fully processed,
+// so a failed update is retried with full crawl instead of being skipped as not modified
+func savePageCache(ctx context.Context, s db.Store, cache db.PageCache) {
+
+	err := s.UpsertPageCache(ctx, db.UpsertPageCacheParams{
+		ComicID:      cache.ComicID,
+		Etag:         cache.Etag,
+		LastModified: cache.LastModified,
+		BodyHash:     cache.BodyHash,
+	})
+	if err != nil {
+		logging.Ctx(ctx).Danger(err)
+	}
+}
+
+// unseenChapters return crawled chapters which are not stored yet, both lists are ordered oldest first.
+// When comic has no chapter history, chapters up to comic's current chapter are considered already notified
+func unseenChapters(crawled, stored []db.Chapter, currentChapURL string) []db.Chapter {
+
+	unseen := []db.Chapter{}
+	if len(crawled) == 0 {
+		return unseen
+	}
+
+	known := make(map[string]bool, len(stored))
+	for _, chap := range stored {
+		known[chap.Url] = true
+	}
+
+	if len(stored) == 0 {
+		current := -1
+		for i, chap := range crawled {
+			if chap.Url == currentChapURL {
+				current = i
+			}
+		}
+
+		// Current chapter is not in the list anymore, fall back to only compare the latest chapter
+		if current == -1 {
+			current = len(crawled) - 2
+		}
+
+		for _, chap := range crawled[:current+1] {
+			known[chap.Url] = true
+		}
+	}
+
+	for _, chap := range crawled {
+		if !known[chap.Url] {
+			unseen = append(unseen, chap)
 		}
 	}
+
+	return unseen
 }
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	db "github.com/tinoquang/comic-notifier/pkg/db/sqlc"
+)
+
+func chapterList(urls ...string) []db.Chapter {
+
+	chapters := []db.Chapter{}
+	for _, url := range urls {
+		chapters = append(chapters, db.Chapter{Name: url, Url: url})
+	}
+	return chapters
+}
+
+func TestUnseenChapters(t *testing.T) {
+
+	crawled := chapterList("c1", "c2", "c3", "c4")
+
+	// Two chapters released between polls
+	unseen := unseenChapters(crawled, chapterList("c1", "c2"), "c2")
+	require.Equal(t, chapterList("c3", "c4"), unseen)
+
+	// Older chapter uploaded late is still notified
+	unseen = unseenChapters(crawled, chapterList("c1", "c3", "c4"), "c4")
+	require.Equal(t, chapterList("c2"), unseen)
+
+	unseen = unseenChapters(crawled, crawled, "c4")
+	require.Empty(t, unseen)
+}
+
+func TestUnseenChaptersWithoutHistory(t *testing.T) {
+
+	crawled := chapterList("c1", "c2", "c3", "c4")
+
+	unseen := unseenChapters(crawled, nil, "c2")
+	require.Equal(t, chapterList("c3", "c4"), unseen)
+
+	unseen = unseenChapters(crawled, nil, "c4")
+	require.Empty(t, unseen)
+
+	// Current chapter is gone from the list, only latest chapter is new
+	unseen = unseenChapters(crawled, nil, "c0")
+	require.Equal(t, chapterList("c4"), unseen)
+
+	require.Empty(t, unseenChapters(nil, nil, "c0"))
+}
@@ -1,6 +1,9 @@
 package util
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -56,6 +59,48 @@ func MakeGetRequest(URL string, queries map[string]string) (respBody []byte, err
 
 }
 
+// MakePostRequest send HTTP POST request with body encoded as JSON
+func MakePostRequest(ctx context.Context, URL string, body interface{}) (respBody []byte, err error) {
+	return PostJSON(ctx, &http.Client{Timeout: 10 * time.Second}, URL, body)
+}
+
+// maxPostResponseSize is the most of response body PostJSON reads
+const maxPostResponseSize = 4 << 10
+
+// PostJSON send HTTP POST request with body encoded as JSON through c
+func PostJSON(ctx context.Context, c *http.Client, URL string, body interface{}) (respBody []byte, err error) {
+
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, URL, bytes.NewReader(reqBody))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return
+	}
+
+	// URL may be given by user, so its response is neither trusted to be small nor logged
+	defer resp.Body.Close()
+	respBody, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxPostResponseSize))
+	if err != nil {
+		return
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		logging.Danger("POST request failed, status code", resp.StatusCode)
+		return nil, errors.New(resp.Status)
+	}
+
+	return
+}
+
 // DownloadFile simple function for downloading file bypass cloudfare
 func DownloadFile(fileURL string, fileName string) (err error) {
 
@@ -0,0 +1,23 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostJSONLimitsResponse(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 1<<20)))
+	}))
+	defer srv.Close()
+
+	body, err := PostJSON(context.Background(), srv.Client(), srv.URL, map[string]string{})
+	require.Nil(t, err)
+	require.Len(t, body, maxPostResponseSize)
+}
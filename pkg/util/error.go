@@ -3,13 +3,18 @@ package util
 import "github.com/pkg/errors"
 
 var (
-	ErrImgUpToDate       = errors.New("Image is up-to-date")
-	ErrAlreadySubscribed = errors.New("Already subscribed")
-	ErrNotFound          = errors.New("Not found")
-	ErrInvalidURL        = errors.New("Invalid URL")
-	ErrCrawlTimeout      = errors.New("Time out when crawl comic")
-	ErrDownloadFile      = errors.New("Cant' download file")
-	ErrCrawlFailed       = errors.New("Crawl failed")
-	ErrComicUpToDate     = errors.New("Comic is up-to-date, no new chapter")
-	ErrPageNotSupported  = errors.New("Page is not supported yet")
+	ErrImgUpToDate              = errors.New("Image is up-to-date")
+	ErrAlreadySubscribed        = errors.New("Already subscribed")
+	ErrNotFound                 = errors.New("Not found")
+	ErrInvalidURL               = errors.New("Invalid URL")
+	ErrCrawlTimeout             = errors.New("Time out when crawl comic")
+	ErrDownloadFile             = errors.New("Cant' download file")
+	ErrCrawlFailed              = errors.New("Crawl failed")
+	ErrThrottled                = errors.New("Host is throttled, request is put off")
+	ErrPrivateAddress           = errors.New("Address is not reachable from the internet")
+	ErrLayoutChanged            = errors.New("Page layout is not recognized")
+	ErrComicUpToDate            = errors.New("Comic is up-to-date, no new chapter")
+	ErrPageNotSupported         = errors.New("Page is not supported yet")
+	ErrNotModified              = errors.New("Page is not modified since last crawl")
+	ErrSpoilerCheckNotSupported = errors.New("Spoiler check is not supported for this page")
 )
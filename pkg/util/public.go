@@ -0,0 +1,99 @@
+package util
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// privateNets are address ranges which aren't reachable from the internet, besides loopback and link-local ones
+var privateNets = func() []*net.IPNet {
+
+	nets := []*net.IPNet{}
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10", // carrier-grade NAT
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"198.18.0.0/15", // benchmarking
+		"fc00::/7",      // unique local
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+
+	return nets
+}()
+
+// IsPublicIP report whether ip is reachable from the internet, loopback, link-local and private addresses are not
+func IsPublicIP(ip net.IP) bool {
+
+	if !ip.IsGlobalUnicast() {
+		return false
+	}
+
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ValidatePublicURL verify URL given by user is https and doesn't name a local host. Host names are resolved
+// only when they're connected to, see NewPublicClient
+func ValidatePublicURL(rawURL string) error {
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return errors.New("URL must be https")
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".local") || strings.HasSuffix(host, ".internal") {
+		return ErrPrivateAddress
+	}
+
+	if ip := net.ParseIP(host); ip != nil && !IsPublicIP(ip) {
+		return ErrPrivateAddress
+	}
+
+	return nil
+}
+
+// NewPublicClient return client for URLs given by users, it connects only to public addresses. Address is checked
+// after host name is resolved, so names resolving to internal hosts are refused too. Redirects are not followed
+func NewPublicClient(timeout time.Duration) *http.Client {
+
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !IsPublicIP(net.ParseIP(host)) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,29 @@
+package util
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPublicIP(t *testing.T) {
+
+	for _, ip := range []string{"93.184.216.34", "2606:4700::1111"} {
+		require.True(t, IsPublicIP(net.ParseIP(ip)), ip)
+	}
+
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "172.20.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fe80::1", "fd00::1"} {
+		require.False(t, IsPublicIP(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestValidatePublicURL(t *testing.T) {
+
+	require.Nil(t, ValidatePublicURL("https://example.com/hook"))
+	require.NotNil(t, ValidatePublicURL("http://example.com/hook"))
+	require.NotNil(t, ValidatePublicURL("https:///hook"))
+	require.Equal(t, ErrPrivateAddress, ValidatePublicURL("https://metadata.google.internal/"))
+	require.Equal(t, ErrPrivateAddress, ValidatePublicURL("https://LOCALHOST./hook"))
+	require.Equal(t, ErrPrivateAddress, ValidatePublicURL("https://192.168.0.1/hook"))
+}
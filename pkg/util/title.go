@@ -0,0 +1,58 @@
+package util
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	chapterKeywordNumber = regexp.MustCompile(`(?i)(?:chapter|chap|chương|chuong|ch\.)\s*(\d+(?:\.\d+)?)`)
+	anyNumber            = regexp.MustCompile(`\d+(?:\.\d+)?`)
+)
+
+// NormalizeTitle fold comic title for comparing across sites: lowercase, without Vietnamese accents,
+// punctuation is removed and spaces are collapsed, e.g "Đảo Hải Tặc!" -> "dao hai tac"
+func NormalizeTitle(title string) string {
+
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, strings.ToLower(title))
+	if err != nil {
+		folded = strings.ToLower(title)
+	}
+	folded = strings.NewReplacer("đ", "d").Replace(folded)
+
+	words := strings.FieldsFunc(folded, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	return strings.Join(words, " ")
+}
+
+// ChapterNumber parse chapter number from chapter name, number following "chapter", "chap" or "chương"
+// is preferred, otherwise the last number in name is used
+func ChapterNumber(name string) (float64, bool) {
+
+	number := ""
+	if m := chapterKeywordNumber.FindStringSubmatch(name); m != nil {
+		number = m[1]
+	} else if all := anyNumber.FindAllString(name, -1); len(all) != 0 {
+		number = all[len(all)-1]
+	}
+
+	if number == "" {
+		return 0, false
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
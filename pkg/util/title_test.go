@@ -0,0 +1,37 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeTitle(t *testing.T) {
+
+	require.Equal(t, "dao hai tac", NormalizeTitle("Đảo Hải Tặc"))
+	require.Equal(t, "dao hai tac", NormalizeTitle("  đảo hải  TẶC! "))
+	require.Equal(t, "one piece", NormalizeTitle("One-Piece"))
+	require.Equal(t, "kimetsu no yaiba 2", NormalizeTitle("Kimetsu no Yaiba (2)"))
+}
+
+func TestChapterNumber(t *testing.T) {
+
+	names := map[string]float64{
+		"Chapter 1008":                1008,
+		"Chương 1008: Trận chiến 2":   1008,
+		"One Piece Chapter 1008.5":    1008.5,
+		"One Piece 1008":              1008,
+		"Chap 12 - Phần 2":            12,
+		"Black Clover Ch. 286":        286,
+		"Hunter x Hunter 390 (raw)":   390,
+		"Kimetsu no Yaiba 2 - Ch 205": 205,
+	}
+	for name, want := range names {
+		n, ok := ChapterNumber(name)
+		require.True(t, ok, name)
+		require.Equal(t, want, n, name)
+	}
+
+	_, ok := ChapterNumber("Oneshot")
+	require.False(t, ok)
+}